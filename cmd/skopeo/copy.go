@@ -1,22 +1,32 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"os"
+	"slices"
 	"strings"
+	"sync"
 
 	encconfig "github.com/containers/ocicrypt/config"
 	enchelpers "github.com/containers/ocicrypt/helpers"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	commonFlag "go.podman.io/common/pkg/flag"
 	"go.podman.io/common/pkg/retry"
 	"go.podman.io/image/v5/copy"
 	"go.podman.io/image/v5/docker/reference"
 	"go.podman.io/image/v5/manifest"
+	"go.podman.io/image/v5/signature"
 	"go.podman.io/image/v5/transports"
 	"go.podman.io/image/v5/transports/alltransports"
+	"go.podman.io/image/v5/types"
 )
 
 type copyOptions struct {
@@ -29,6 +39,7 @@ type copyOptions struct {
 	additionalTags      []string                  // For docker-archive: destinations, in addition to the name:tag specified as destination, also add these
 	signIdentity        string                    // Identity of the signed image, must be a fully specified docker reference
 	digestFile          string                    // Write digest to this file
+	reportFile          string                    // Write a JSON report about the copy to this file
 	quiet               bool                      // Suppress output information when copying images
 	all                 bool                      // Copy all of the images if the source is a list
 	multiArch           commonFlag.OptionalString // How to handle multi architecture images
@@ -36,6 +47,11 @@ type copyOptions struct {
 	encryptionKeys      []string                  // Keys needed to encrypt the image
 	decryptionKeys      []string                  // Keys needed to decrypt the image
 	imageParallelCopies uint                      // Maximum number of parallel requests when copying images
+	platforms           []string                  // Copy only instances matching these OS/ARCH[/VARIANT] values if the source is a list
+	instances           []string                  // Copy only instances with these digests if the source is a list
+	sparseManifestList  commonFlag.OptionalString // How to handle the list when only some of its instances are copied
+	fromFile            string                    // Read SOURCE-IMAGE DESTINATION-IMAGE pairs to copy from this file
+	parallelImages      uint                      // Maximum number of images to copy simultaneously with fromFile
 }
 
 func copyCmd(global *globalOptions) *cobra.Command {
@@ -81,9 +97,15 @@ See skopeo(1) section "IMAGE NAMES" for the expected format
 	flags.Var(commonFlag.NewOptionalStringValue(&opts.multiArch), "multi-arch", `How to handle multi-architecture images (system, all, or index-only)`)
 	flags.StringVar(&opts.signIdentity, "sign-identity", "", "Identity of signed image, must be a fully specified docker reference. Defaults to the target docker reference.")
 	flags.StringVar(&opts.digestFile, "digestfile", "", "Write the digest of the pushed image to the specified file")
+	flags.StringVar(&opts.reportFile, "report", "", "Write a JSON report about the copy to `FILE`")
 	flags.StringSliceVar(&opts.encryptionKeys, "encryption-key", []string{}, "*Experimental* key with the encryption protocol to use needed to encrypt the image (e.g. jwe:/path/to/key.pem)")
 	flags.IntSliceVar(&opts.encryptLayer, "encrypt-layer", []int{}, "*Experimental* the 0-indexed layer indices, with support for negative indexing (e.g. 0 is the first layer, -1 is the last layer)")
 	flags.StringSliceVar(&opts.decryptionKeys, "decryption-key", []string{}, "*Experimental* key needed to decrypt the image")
+	flags.StringSliceVar(&opts.platforms, "platform", []string{}, "Copy only images matching `OS/ARCH[/VARIANT]` if SOURCE-IMAGE is a list (can be specified multiple times)")
+	flags.StringSliceVar(&opts.instances, "instance", []string{}, "Copy only the image with `DIGEST` if SOURCE-IMAGE is a list (can be specified multiple times)")
+	flags.Var(commonFlag.NewOptionalStringValue(&opts.sparseManifestList), "sparse-manifest-list", "How to handle the list if only some images are copied with --platform or --instance (keep or strip)")
+	flags.StringVar(&opts.fromFile, "from-file", "", "Copy all SOURCE-IMAGE DESTINATION-IMAGE pairs listed in `FILE` (- for standard input), instead of a single image")
+	flags.UintVar(&opts.parallelImages, "parallel-images", 1, "Maximum number of images to copy simultaneously when using --from-file")
 	flags.UintVar(&opts.imageParallelCopies, "image-parallel-copies", 0, "Maximum number of image layers to be copied (pulled/pushed) simultaneously. Not setting this field will fall back to containers/image defaults.")
 	return cmd
 }
@@ -101,42 +123,170 @@ func parseMultiArch(multiArch string) (copy.ImageListSelection, error) {
 	// CopySpecificImages.
 	case "index-only":
 		return copy.CopySpecificImages, nil
-	// We don't expose CopySpecificImages other than index-only above, because
-	// we currently don't provide an option to choose the images to copy. That
-	// could be added in the future.
+	// Selecting specific images to copy is done using --platform and --instance,
+	// see parseInstanceSelection.
 	default:
 		return copy.CopySystemImage, fmt.Errorf("unknown multi-arch option %q. Choose one of the supported options: 'system', 'all', or 'index-only'", multiArch)
 	}
 }
 
-func (opts *copyOptions) run(args []string, stdout io.Writer) (retErr error) {
-	if len(args) != 2 {
-		return errorShouldDisplayUsage{errors.New("Exactly two arguments expected")}
+// platformSelector is a parsed OS/ARCH[/VARIANT] value, as used by --platform.
+type platformSelector struct {
+	os           string
+	architecture string
+	variant      string // "" if not specified; in that case, all variants match
+}
+
+// parsePlatform parses an OS/ARCH[/VARIANT] value.
+func parsePlatform(value string) (platformSelector, error) {
+	parts := strings.Split(value, "/")
+	if len(parts) < 2 || len(parts) > 3 || slices.Contains(parts, "") {
+		return platformSelector{}, fmt.Errorf("invalid platform %q, expected OS/ARCH[/VARIANT]", value)
 	}
-	opts.deprecatedTLSVerify.warnIfUsed([]string{"--src-tls-verify", "--dest-tls-verify"})
-	imageNames := args
+	res := platformSelector{os: parts[0], architecture: parts[1]}
+	if len(parts) == 3 {
+		res.variant = parts[2]
+	}
+	return res, nil
+}
 
-	if err := reexecIfNecessaryForImages(imageNames...); err != nil {
-		return err
+// matches returns true if platform is matched by p.
+func (p platformSelector) matches(platform *imgspecv1.Platform) bool {
+	if platform == nil {
+		return false
 	}
+	return platform.OS == p.os && platform.Architecture == p.architecture &&
+		(p.variant == "" || platform.Variant == p.variant)
+}
 
-	policyContext, err := opts.global.getPolicyContext()
+// parseSparseManifestList parses the --sparse-manifest-list option.
+func parseSparseManifestList(value string) (copy.SparseManifestListAction, error) {
+	switch value {
+	case "keep":
+		return copy.KeepSparseManifestList, nil
+	case "strip":
+		return copy.StripSparseManifestList, nil
+	default:
+		return copy.KeepSparseManifestList, fmt.Errorf("unknown sparse-manifest-list option %q. Choose one of the supported options: 'keep' or 'strip'", value)
+	}
+}
+
+// parseInstanceSelection parses the --instance and --platform values.
+// It returns the explicitly listed instance digests, the platforms which can be passed
+// to copy.Options.InstancePlatforms directly, and the platforms which specify a variant;
+// the latter must be resolved to instance digests using resolvePlatformInstances,
+// because copy.InstancePlatformFilter does not support variants.
+func parseInstanceSelection(instances, platforms []string) ([]digest.Digest, []copy.InstancePlatformFilter, []platformSelector, error) {
+	var digests []digest.Digest
+	for _, instance := range instances {
+		d, err := digest.Parse(instance)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("invalid instance digest %q: %w", instance, err)
+		}
+		digests = append(digests, d)
+	}
+	var filters []copy.InstancePlatformFilter
+	var withVariants []platformSelector
+	for _, value := range platforms {
+		p, err := parsePlatform(value)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if p.variant == "" {
+			filters = append(filters, copy.InstancePlatformFilter{OS: p.os, Architecture: p.architecture})
+		} else {
+			withVariants = append(withVariants, p)
+		}
+	}
+	return digests, filters, withVariants, nil
+}
+
+// resolvePlatformInstances returns digests of instances in the list at ref matching any of platforms,
+// and fails if one of platforms does not match any instance.
+// It returns an empty slice, and no error, if ref is not a list.
+func resolvePlatformInstances(ctx context.Context, sys *types.SystemContext, ref types.ImageReference, platforms []platformSelector) (retDigests []digest.Digest, retErr error) {
+	src, err := ref.NewImageSource(ctx, sys)
 	if err != nil {
-		return fmt.Errorf("Error loading trust policy: %v", err)
+		return nil, err
 	}
 	defer func() {
-		if err := policyContext.Destroy(); err != nil {
-			retErr = noteCloseFailure(retErr, "tearing down policy context", err)
+		if err := src.Close(); err != nil {
+			retErr = noteCloseFailure(retErr, "closing image source", err)
 		}
 	}()
-
-	srcRef, err := alltransports.ParseImageName(imageNames[0])
+	rawManifest, mimeType, err := src.GetManifest(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("Invalid source name %s: %v", imageNames[0], err)
+		return nil, err
+	}
+	if !manifest.MIMETypeIsMultiImage(mimeType) {
+		return nil, nil
+	}
+	list, err := manifest.ListFromBlob(rawManifest, mimeType)
+	if err != nil {
+		return nil, fmt.Errorf("parsing manifest list: %w", err)
+	}
+	var res []digest.Digest
+	matched := make([]bool, len(platforms))
+	for _, d := range list.Instances() {
+		instance, err := list.Instance(d)
+		if err != nil {
+			return nil, err
+		}
+		instanceMatched := false
+		for i, p := range platforms {
+			if p.matches(instance.ReadOnly.Platform) {
+				matched[i] = true
+				instanceMatched = true
+			}
+		}
+		if instanceMatched {
+			res = append(res, d)
+		}
+	}
+	for i, p := range platforms {
+		if !matched[i] {
+			return nil, fmt.Errorf("no image in the list matches platform %s/%s/%s", p.os, p.architecture, p.variant)
+		}
+	}
+	return res, nil
+}
+
+func (opts *copyOptions) run(args []string, stdout io.Writer) (retErr error) {
+	var pairs []copyPair
+	if opts.fromFile != "" {
+		if len(args) != 0 {
+			return errorShouldDisplayUsage{errors.New("No arguments expected when using --from-file")}
+		}
+		if opts.digestFile != "" || opts.reportFile != "" || opts.signIdentity != "" {
+			return errors.New("--digestfile, --report and --sign-identity cannot be used with --from-file")
+		}
+		p, err := readCopyPairs(opts.fromFile, os.Stdin)
+		if err != nil {
+			return err
+		}
+		pairs = p
+	} else {
+		if len(args) != 2 {
+			return errorShouldDisplayUsage{errors.New("Exactly two arguments expected")}
+		}
+		pairs = []copyPair{{Source: args[0], Destination: args[1]}}
 	}
-	destRef, err := alltransports.ParseImageName(imageNames[1])
+	if opts.parallelImages == 0 {
+		return errors.New("--parallel-images must be at least 1")
+	}
+	opts.deprecatedTLSVerify.warnIfUsed([]string{"--src-tls-verify", "--dest-tls-verify"})
+	imageNames := []string{}
+	for _, pair := range pairs {
+		imageNames = append(imageNames, pair.Source, pair.Destination)
+	}
+
+	if err := reexecIfNecessaryForImages(imageNames...); err != nil {
+		return err
+	}
+
+	policy, err := opts.global.getPolicy()
 	if err != nil {
-		return fmt.Errorf("Invalid destination name %s: %v", imageNames[1], err)
+		return fmt.Errorf("Error loading trust policy: %v", err)
 	}
 
 	sourceCtx, err := opts.srcImage.newSystemContext()
@@ -163,10 +313,6 @@ func (opts *copyOptions) run(args []string, stdout io.Writer) (retErr error) {
 	ctx, cancel := opts.global.commandTimeoutContext()
 	defer cancel()
 
-	if opts.quiet {
-		stdout = nil
-	}
-
 	imageListSelection := copy.CopySystemImage
 	if opts.multiArch.Present() && opts.all {
 		return fmt.Errorf("Cannot use --all and --multi-arch flags together")
@@ -181,6 +327,28 @@ func (opts *copyOptions) run(args []string, stdout io.Writer) (retErr error) {
 		imageListSelection = copy.CopyAllImages
 	}
 
+	instances, instancePlatforms, variantPlatforms, err := parseInstanceSelection(opts.instances, opts.platforms)
+	if err != nil {
+		return err
+	}
+	specificImages := len(instances) > 0 || len(instancePlatforms) > 0 || len(variantPlatforms) > 0
+	if specificImages {
+		if opts.all || opts.multiArch.Present() {
+			return fmt.Errorf("Cannot use --platform or --instance together with --all or --multi-arch")
+		}
+		imageListSelection = copy.CopySpecificImages
+	}
+	sparseManifestListAction := copy.KeepSparseManifestList
+	if opts.sparseManifestList.Present() {
+		if !specificImages {
+			return fmt.Errorf("--sparse-manifest-list can only be used with --platform or --instance")
+		}
+		sparseManifestListAction, err = parseSparseManifestList(opts.sparseManifestList.Value())
+		if err != nil {
+			return err
+		}
+	}
+
 	if len(opts.encryptionKeys) > 0 && len(opts.decryptionKeys) > 0 {
 		return fmt.Errorf("--encryption-key and --decryption-key cannot be specified together")
 	}
@@ -225,9 +393,21 @@ func (opts *copyOptions) run(args []string, stdout io.Writer) (retErr error) {
 		}
 	}
 
-	opts.destImage.warnAboutIneffectiveOptions(destRef.Transport())
+	progress, reportWriter, err := opts.copy.newProgressReporter(stdout)
+	if err != nil {
+		return err
+	}
+	if opts.quiet || (len(pairs) > 1 && opts.parallelImages > 1) {
+		// Human-readable progress of concurrent copies would be interleaved and unreadable.
+		reportWriter = nil
+	}
+	defer func() {
+		if err := progress.close(); err != nil {
+			retErr = noteCloseFailure(retErr, "closing progress output", err)
+		}
+	}()
 
-	copyOpts, cleanupOptions, err := opts.copy.copyOptions(stdout)
+	copyOpts, cleanupOptions, err := opts.copy.copyOptions(reportWriter)
 	if err != nil {
 		return err
 	}
@@ -236,17 +416,89 @@ func (opts *copyOptions) run(args []string, stdout io.Writer) (retErr error) {
 	copyOpts.SourceCtx = sourceCtx
 	copyOpts.DestinationCtx = destinationCtx
 	copyOpts.ImageListSelection = imageListSelection
+	copyOpts.Instances = instances
+	copyOpts.InstancePlatforms = instancePlatforms
+	copyOpts.SparseManifestListAction = sparseManifestListAction
 	copyOpts.OciDecryptConfig = decConfig
 	copyOpts.OciEncryptLayers = encLayers
 	copyOpts.OciEncryptConfig = encConfig
 	copyOpts.MaxParallelDownloads = opts.imageParallelCopies
 	copyOpts.ForceCompressionFormat = opts.destImage.forceCompressionFormat
 
-	return retry.IfNecessary(ctx, func() error {
-		manifestBytes, err := copy.Image(ctx, policyContext, destRef, srcRef, copyOpts)
+	if opts.fromFile == "" {
+		policyContext, err := opts.global.newPolicyContext(policy)
 		if err != nil {
+			return fmt.Errorf("Error loading trust policy: %v", err)
+		}
+		defer func() {
+			if err := policyContext.Destroy(); err != nil {
+				retErr = noteCloseFailure(retErr, "tearing down policy context", err)
+			}
+		}()
+		return opts.copyImage(ctx, policyContext, pairs[0], *copyOpts, variantPlatforms, progress)
+	}
+	return opts.copyBatch(ctx, policy, pairs, copyOpts, variantPlatforms, progress)
+}
+
+// copyImage copies a single pair, using options (which is modified by copyImage, so the caller should pass a copy of a shared value).
+func (opts *copyOptions) copyImage(ctx context.Context, policyContext *signature.PolicyContext, pair copyPair,
+	options copy.Options, variantPlatforms []platformSelector, progress *jsonProgressReporter,
+) error {
+	srcRef, err := alltransports.ParseImageName(pair.Source)
+	if err != nil {
+		return fmt.Errorf("Invalid source name %s: %v", pair.Source, err)
+	}
+	destRef, err := alltransports.ParseImageName(pair.Destination)
+	if err != nil {
+		return fmt.Errorf("Invalid destination name %s: %v", pair.Destination, err)
+	}
+
+	opts.destImage.warnAboutIneffectiveOptions(destRef.Transport())
+	if opts.copy.referrers {
+		if err := checkReferrersTransports(srcRef.Transport().Name(), destRef.Transport().Name()); err != nil {
 			return err
 		}
+	}
+
+	if len(variantPlatforms) > 0 {
+		var resolved []digest.Digest
+		if err := retry.IfNecessary(ctx, func() error {
+			resolved, err = resolvePlatformInstances(ctx, options.SourceCtx, srcRef, variantPlatforms)
+			return err
+		}, opts.retryOpts); err != nil {
+			return fmt.Errorf("Error resolving --platform values: %w", err)
+		}
+		options.Instances = slices.Concat(options.Instances, resolved)
+	}
+
+	var report *copyReport
+	var resolvedDest types.ImageReference
+	if opts.reportFile != "" {
+		report = newCopyReport(srcRef, destRef)
+		options.ReportResolvedReference = &resolvedDest
+	}
+
+	var manifestBytes []byte
+	copyErr := retry.IfNecessary(ctx, func() error {
+		var listeners []func(types.ProgressProperties)
+		copySrc := srcRef
+		if report != nil {
+			report.Blobs = blobStats{} // Only report the last attempt
+			listeners = append(listeners, report.Blobs.blobProgress)
+			copySrc, err = report.resolveSource(ctx, options.SourceCtx, srcRef)
+			if err != nil {
+				return fmt.Errorf("Error reading source manifest digest: %w", err)
+			}
+		}
+		progressDone := progress.startImage(&options, srcRef, destRef, listeners...)
+		manifestBytes, err = copy.Image(ctx, policyContext, destRef, copySrc, &options)
+		progressDone(manifestBytes, err)
+		if err != nil {
+			return err
+		}
+		if err := opts.copy.copyReferrers(ctx, &options, copySrc, destRef, manifestBytes); err != nil {
+			return fmt.Errorf("Error copying referrers: %w", err)
+		}
 		if opts.digestFile != "" {
 			manifestDigest, err := manifest.Digest(manifestBytes)
 			if err != nil {
@@ -258,4 +510,137 @@ func (opts *copyOptions) run(args []string, stdout io.Writer) (retErr error) {
 		}
 		return nil
 	}, opts.retryOpts)
+
+	if report != nil {
+		if err := report.finish(manifestBytes, resolvedDest, copyErr); err != nil {
+			return err
+		}
+		if err := report.write(opts.reportFile); err != nil {
+			if copyErr != nil {
+				logrus.Error(err)
+				return copyErr
+			}
+			return err
+		}
+	}
+	return copyErr
+}
+
+// copyPair is a single SOURCE-IMAGE DESTINATION-IMAGE pair to copy.
+type copyPair struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+}
+
+// readCopyPairs reads the --from-file input at path, or from stdin if path is "-".
+func readCopyPairs(path string, stdin io.Reader) ([]copyPair, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error reading image pairs: %w", err)
+	}
+	pairs, err := parseCopyPairs(data)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing image pairs from %q: %w", path, err)
+	}
+	if len(pairs) == 0 {
+		return nil, fmt.Errorf("No image pairs found in %q", path)
+	}
+	return pairs, nil
+}
+
+// parseCopyPairs parses copy pairs from data, which is either a JSON array of {"source": …, "destination": …} objects,
+// or text with one whitespace-separated SOURCE-IMAGE DESTINATION-IMAGE pair per line
+// (ignoring empty lines and lines starting with #).
+func parseCopyPairs(data []byte) ([]copyPair, error) {
+	var pairs []copyPair
+	if trimmed := bytes.TrimSpace(data); bytes.HasPrefix(trimmed, []byte("[")) {
+		if err := json.Unmarshal(trimmed, &pairs); err != nil {
+			return nil, err
+		}
+		for i, pair := range pairs {
+			if pair.Source == "" || pair.Destination == "" {
+				return nil, fmt.Errorf("entry %d: both source and destination must be specified", i+1)
+			}
+		}
+		return pairs, nil
+	}
+
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected SOURCE-IMAGE DESTINATION-IMAGE, got %q", i+1, line)
+		}
+		pairs = append(pairs, copyPair{Source: fields[0], Destination: fields[1]})
+	}
+	return pairs, nil
+}
+
+// copyBatch copies all of pairs using opts.parallelImages workers, logs the result of each copy,
+// and fails if any of the copies failed.
+func (opts *copyOptions) copyBatch(ctx context.Context, policy *signature.Policy, pairs []copyPair,
+	options *copy.Options, variantPlatforms []platformSelector, progress *jsonProgressReporter,
+) error {
+	// A PolicyContext can only be used by one goroutine at a time, so each worker uses its own one, all sharing the same policy.
+	workers := min(int(opts.parallelImages), len(pairs))
+	policyContexts := make([]*signature.PolicyContext, 0, workers)
+	defer func() {
+		for _, pc := range policyContexts {
+			if err := pc.Destroy(); err != nil {
+				logrus.Warnf("Error tearing down policy context: %v", err)
+			}
+		}
+	}()
+	for range workers {
+		pc, err := opts.global.newPolicyContext(policy)
+		if err != nil {
+			return fmt.Errorf("Error loading trust policy: %v", err)
+		}
+		policyContexts = append(policyContexts, pc)
+	}
+
+	results := make([]error, len(pairs))
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	for _, pc := range policyContexts {
+		wg.Go(func() {
+			for i := range indices {
+				logrus.Infof("Copying image %d/%d: %s to %s", i+1, len(pairs), pairs[i].Source, pairs[i].Destination)
+				results[i] = opts.copyImage(ctx, pc, pairs[i], *options, variantPlatforms, progress)
+			}
+		})
+	}
+	for i := range pairs {
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+
+	failed := 0
+	for i, err := range results {
+		fields := logrus.Fields{
+			"from": pairs[i].Source,
+			"to":   pairs[i].Destination,
+		}
+		if err != nil {
+			failed++
+			logrus.WithFields(fields).WithError(err).Error("Copy failed")
+		} else {
+			logrus.WithFields(fields).Info("Copy succeeded")
+		}
+	}
+	logrus.Infof("Copied %d of %d images", len(pairs)-failed, len(pairs))
+	if failed != 0 {
+		return fmt.Errorf("%d of %d image copies failed", failed, len(pairs))
+	}
+	return nil
 }
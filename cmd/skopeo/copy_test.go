@@ -1,6 +1,17 @@
 package main
 
-import "testing"
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.podman.io/image/v5/copy"
+)
 
 func TestCopy(t *testing.T) {
 	// Invalid command-line arguments
@@ -13,6 +24,123 @@ func TestCopy(t *testing.T) {
 		assertTestFailed(t, out, err, "Exactly two arguments expected")
 	}
 
+	// Arguments together with --from-file
+	out, err := runSkopeo("--insecure-policy", "copy", "--from-file", "/dev/null", "a1", "a2")
+	assertTestFailed(t, out, err, "No arguments expected when using --from-file")
+
 	// FIXME: Much more test coverage
 	// Actual feature tests exist in integration and systemtest
 }
+
+func TestCopyFromFile(t *testing.T) {
+	const fixture = "../../integration/fixtures/uncompressed-image-s2"
+	dir := t.TempDir()
+	pairsFile := filepath.Join(dir, "pairs")
+	err := os.WriteFile(pairsFile, fmt.Appendf(nil, "dir:%s oci:%s/first:latest\n"+
+		"dir:%s oci:%s/second:latest\n"+
+		"dir:/this/does/not/exist oci:%s/third:latest\n",
+		fixture, dir, fixture, dir, dir), 0o600)
+	require.NoError(t, err)
+
+	_, err = runSkopeo("--insecure-policy", "copy", "-q", "--parallel-images", "2", "--from-file", pairsFile)
+	assert.ErrorContains(t, err, "1 of 3 image copies failed")
+	for _, name := range []string{"first", "second"} {
+		_, err := runSkopeo("inspect", "oci:"+dir+"/"+name+":latest")
+		assert.NoError(t, err, name)
+	}
+
+	// Progress is only suppressed for concurrent copies
+	out, err := runSkopeo("--insecure-policy", "copy", "--parallel-images", "2", "--from-file", pairsFile)
+	assert.ErrorContains(t, err, "1 of 3 image copies failed")
+	assert.NotContains(t, out, "Writing manifest")
+	out, err = runSkopeo("--insecure-policy", "copy", "--parallel-images", "2", "dir:"+fixture, "oci:"+dir+"/single:latest")
+	require.NoError(t, err)
+	assert.Contains(t, out, "Writing manifest")
+}
+
+func TestParseCopyPairs(t *testing.T) {
+	expected := []copyPair{
+		{Source: "docker://quay.io/a:1", Destination: "docker://example.com/a:1"},
+		{Source: "dir:/b", Destination: "oci:/c:latest"},
+	}
+	for _, input := range []string{
+		"docker://quay.io/a:1 docker://example.com/a:1\ndir:/b oci:/c:latest\n",
+		"# comment\n\n  docker://quay.io/a:1\tdocker://example.com/a:1  \n\ndir:/b    oci:/c:latest",
+		`[{"source": "docker://quay.io/a:1", "destination": "docker://example.com/a:1"},
+		  {"source": "dir:/b", "destination": "oci:/c:latest"}]`,
+	} {
+		res, err := parseCopyPairs([]byte(input))
+		require.NoError(t, err, input)
+		assert.Equal(t, expected, res, input)
+	}
+
+	res, err := parseCopyPairs([]byte("\n# only a comment\n"))
+	require.NoError(t, err)
+	assert.Empty(t, res)
+
+	for _, input := range []string{
+		"only-one-field",
+		"a b c",
+		`[{"source": "a"}]`,
+		`[{"source": "a", "destination": "b"}`,
+	} {
+		_, err := parseCopyPairs([]byte(input))
+		assert.Error(t, err, input)
+	}
+}
+
+func TestParsePlatform(t *testing.T) {
+	for _, c := range []struct {
+		input    string
+		expected platformSelector
+	}{
+		{"linux/amd64", platformSelector{os: "linux", architecture: "amd64"}},
+		{"linux/arm64/v8", platformSelector{os: "linux", architecture: "arm64", variant: "v8"}},
+	} {
+		res, err := parsePlatform(c.input)
+		require.NoError(t, err, c.input)
+		assert.Equal(t, c.expected, res, c.input)
+	}
+
+	for _, input := range []string{"", "linux", "linux/", "/amd64", "linux//v8", "linux/arm64/v8/extra"} {
+		_, err := parsePlatform(input)
+		assert.Error(t, err, input)
+	}
+}
+
+func TestPlatformSelectorMatches(t *testing.T) {
+	p := platformSelector{os: "linux", architecture: "arm"}
+	assert.True(t, p.matches(&imgspecv1.Platform{OS: "linux", Architecture: "arm", Variant: "v7"}))
+	assert.False(t, p.matches(&imgspecv1.Platform{OS: "linux", Architecture: "arm64"}))
+	assert.False(t, p.matches(nil))
+
+	p = platformSelector{os: "linux", architecture: "arm", variant: "v6"}
+	assert.True(t, p.matches(&imgspecv1.Platform{OS: "linux", Architecture: "arm", Variant: "v6"}))
+	assert.False(t, p.matches(&imgspecv1.Platform{OS: "linux", Architecture: "arm", Variant: "v7"}))
+}
+
+func TestParseInstanceSelection(t *testing.T) {
+	const d1 = "sha256:0000000000000000000000000000000000000000000000000000000000000001"
+
+	instances, filters, withVariants, err := parseInstanceSelection([]string{d1}, []string{"linux/amd64", "linux/arm/v7"})
+	require.NoError(t, err)
+	assert.Equal(t, []digest.Digest{d1}, instances)
+	assert.Equal(t, []copy.InstancePlatformFilter{{OS: "linux", Architecture: "amd64"}}, filters)
+	assert.Equal(t, []platformSelector{{os: "linux", architecture: "arm", variant: "v7"}}, withVariants)
+
+	_, _, _, err = parseInstanceSelection([]string{"not-a-digest"}, nil)
+	assert.Error(t, err)
+	_, _, _, err = parseInstanceSelection(nil, []string{"linux"})
+	assert.Error(t, err)
+}
+
+func TestParseSparseManifestList(t *testing.T) {
+	res, err := parseSparseManifestList("keep")
+	require.NoError(t, err)
+	assert.Equal(t, copy.KeepSparseManifestList, res)
+	res, err = parseSparseManifestList("strip")
+	require.NoError(t, err)
+	assert.Equal(t, copy.StripSparseManifestList, res)
+	_, err = parseSparseManifestList("invalid")
+	assert.Error(t, err)
+}
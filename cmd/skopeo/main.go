@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"runtime/debug"
 	"strings"
@@ -108,12 +109,14 @@ func createApp() (*cobra.Command, *globalOptions) {
 		loginCmd(&opts),
 		logoutCmd(&opts),
 		manifestDigestCmd(),
+		policyCmd(&opts),
 		proxyCmd(&opts),
 		syncCmd(&opts),
 		standaloneSignCmd(),
 		standaloneVerifyCmd(),
 		tagsCmd(&opts),
 		untrustedSignatureDumpCmd(),
+		verifyCmd(&opts),
 	)
 	return rootCommand, &opts
 }
@@ -152,6 +155,11 @@ func main() {
 	}
 	rootCmd, _ := createApp()
 	if err := rootCmd.Execute(); err != nil {
+		var exitCodeErr errorWithExitCode
+		if errors.As(err, &exitCodeErr) {
+			logrus.StandardLogger().Log(logrus.FatalLevel, err)
+			logrus.Exit(exitCodeErr.exitCode)
+		}
 		if isNotFoundImageError(err) {
 			logrus.StandardLogger().Log(logrus.FatalLevel, err)
 			logrus.Exit(2)
@@ -162,6 +170,18 @@ func main() {
 
 // getPolicyContext returns a *signature.PolicyContext based on opts.
 func (opts *globalOptions) getPolicyContext() (*signature.PolicyContext, error) {
+	policy, err := opts.getPolicy()
+	if err != nil {
+		return nil, err
+	}
+	return opts.newPolicyContext(policy)
+}
+
+// getPolicy returns a *signature.Policy based on opts.
+// Callers which need several *signature.PolicyContext instances, e.g. to evaluate the policy concurrently
+// (a PolicyContext can only be used by one goroutine at a time), can use this together with newPolicyContext
+// to only load the policy once.
+func (opts *globalOptions) getPolicy() (*signature.Policy, error) {
 	var policy *signature.Policy // This could be cached across calls in opts.
 	if opts.insecurePolicy {
 		policy = &signature.Policy{Default: []signature.PolicyRequirement{signature.NewPRInsecureAcceptAnything()}}
@@ -182,7 +202,11 @@ func (opts *globalOptions) getPolicyContext() (*signature.PolicyContext, error)
 		}
 		policy = p
 	}
+	return policy, nil
+}
 
+// newPolicyContext returns a *signature.PolicyContext for policy, based on opts.
+func (opts *globalOptions) newPolicyContext(policy *signature.Policy) (*signature.PolicyContext, error) {
 	pc, err := signature.NewPolicyContext(policy)
 	if err != nil {
 		return nil, err
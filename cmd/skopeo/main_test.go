@@ -3,13 +3,23 @@ package main
 import (
 	"bytes"
 	"crypto/tls"
+	"os"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.podman.io/image/v5/types"
+	"go.podman.io/storage/pkg/reexec"
 )
 
+func TestMain(m *testing.M) {
+	// containers-storage runs parts of itself in subprocesses of the test binary, like main does.
+	if reexec.Init() {
+		return
+	}
+	os.Exit(m.Run())
+}
+
 // runSkopeo creates an app object and runs it with args, with an implied first "skopeo".
 // Returns output intended for stdout and the returned error, if any.
 func runSkopeo(args ...string) (string, error) {
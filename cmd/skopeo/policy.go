@@ -0,0 +1,372 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"go.podman.io/image/v5/docker"
+	"go.podman.io/image/v5/signature"
+	"go.podman.io/image/v5/transports"
+	"go.podman.io/image/v5/transports/alltransports"
+	"go.podman.io/image/v5/types"
+	"go.podman.io/storage/pkg/homedir"
+	"gopkg.in/yaml.v3"
+)
+
+func policyCmd(global *globalOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "policy COMMAND",
+		Short: "Inspect and edit the signature verification policy",
+		Long:  "Inspect and edit the signature verification policy (policy.json), and inspect the related registries.d configuration",
+		RunE:  requireSubcommand,
+	}
+	adjustGroupUsage(cmd)
+	cmd.AddCommand(
+		policyAddRequirementCmd(global),
+		policyExplainCmd(global),
+		policyLintCmd(global),
+		policyRemoveScopeCmd(global),
+	)
+	return cmd
+}
+
+type policyExplainOptions struct {
+	global *globalOptions
+	format string // Output format, "text" or "json"
+}
+
+func policyExplainCmd(global *globalOptions) *cobra.Command {
+	opts := policyExplainOptions{
+		global: global,
+	}
+	cmd := &cobra.Command{
+		Use:   "explain [command options] IMAGE-NAME",
+		Short: "Show which policy requirements and signature storage apply to IMAGE-NAME",
+		Long: fmt.Sprintf(`Show the policy scope and requirements which apply to "IMAGE-NAME",
+and the signature storage locations configured in registries.d for it.
+
+The image is not accessed.
+
+Supported transports:
+%s
+
+See skopeo(1) section "IMAGE NAMES" for the expected format
+`, strings.Join(transports.ListNames(), ", ")),
+		RunE:              commandAction(opts.run),
+		Example:           `skopeo --policy ./policy.json policy explain docker://registry.example.com/example/busybox:latest`,
+		ValidArgsFunction: autocompleteImageNames,
+	}
+	adjustUsage(cmd)
+	flags := cmd.Flags()
+	flags.StringVar(&opts.format, "format", "text", "Output the result in `FORMAT` (text or json)")
+	return cmd
+}
+
+// policyExplanation is the output of (skopeo policy explain).
+type policyExplanation struct {
+	Image          string            `json:"image"`
+	Transport      string            `json:"transport"`
+	Identity       string            `json:"identity"`   // The policy configuration identity of the image
+	Namespaces     []string          `json:"namespaces"` // Scopes considered after Identity, in order
+	Scope          policyScope       `json:"scope"`
+	Requirements   []json.RawMessage `json:"requirements"`
+	RequireSigned  bool              `json:"requireSigned,omitempty"` // (skopeo --require-signed) additionally rejects unsigned images
+	SignatureStore *signatureStorage `json:"signatureStorage,omitempty"`
+}
+
+// signatureStorage describes the registries.d configuration which applies to a docker:// image.
+type signatureStorage struct {
+	RegistriesDir          string          `json:"registriesDir"`
+	Lookaside              storageLocation `json:"lookaside"`
+	LookasideWrite         storageLocation `json:"lookasideWrite"`
+	UseSigstoreAttachments bool            `json:"useSigstoreAttachments"`
+	SigstoreAttachmentsSet string          `json:"useSigstoreAttachmentsSource"` // Where UseSigstoreAttachments was configured
+}
+
+// storageLocation is a lookaside signature storage location, and the configuration which chose it.
+type storageLocation struct {
+	URL    string `json:"url"`
+	Source string `json:"source"`
+}
+
+// sourceBuiltinDefault is the value of storageLocation.Source and signatureStorage.SigstoreAttachmentsSet
+// if nothing was configured in registries.d.
+const sourceBuiltinDefault = "built-in default"
+
+func (opts *policyExplainOptions) run(args []string, stdout io.Writer) error {
+	if len(args) != 1 {
+		return errorShouldDisplayUsage{errors.New("Exactly one argument expected")}
+	}
+	if opts.format != "text" && opts.format != "json" {
+		return fmt.Errorf("unknown output format %q. Choose one of the supported formats: 'text', 'json'", opts.format)
+	}
+	imageName := args[0]
+
+	policy, err := opts.global.getPolicy()
+	if err != nil {
+		return fmt.Errorf("Error loading trust policy: %w", err)
+	}
+	ref, err := alltransports.ParseImageName(imageName)
+	if err != nil {
+		return fmt.Errorf("Invalid image name %s: %v", imageName, err)
+	}
+	sys, err := opts.global.newSystemContext()
+	if err != nil {
+		return err
+	}
+
+	res, err := explainPolicy(sys, policy, ref)
+	if err != nil {
+		return err
+	}
+	res.RequireSigned = opts.global.requireSigned
+
+	if opts.format == "json" {
+		out, err := json.MarshalIndent(res, "", "    ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "%s\n", string(out))
+		return nil
+	}
+	res.writeText(stdout)
+	return nil
+}
+
+// explainPolicy returns the policy and signature storage configuration which applies to ref.
+func explainPolicy(sys *types.SystemContext, policy *signature.Policy, ref types.ImageReference) (*policyExplanation, error) {
+	res := &policyExplanation{
+		Image:        transports.ImageName(ref),
+		Transport:    ref.Transport().Name(),
+		Identity:     ref.PolicyConfigurationIdentity(),
+		Namespaces:   ref.PolicyConfigurationNamespaces(),
+		Requirements: []json.RawMessage{},
+	}
+	if res.Namespaces == nil {
+		res.Namespaces = []string{}
+	}
+	var requirements signature.PolicyRequirements
+	res.Scope, requirements = policyRequirementsForImage(policy, ref)
+	for _, req := range requirements {
+		reqJSON, err := json.Marshal(req)
+		if err != nil {
+			return nil, err
+		}
+		res.Requirements = append(res.Requirements, reqJSON)
+	}
+
+	if ref.Transport().Name() == docker.Transport.Name() {
+		storage, err := explainSignatureStorage(sys, ref)
+		if err != nil {
+			return nil, err
+		}
+		res.SignatureStore = storage
+	}
+	return res, nil
+}
+
+// policyScope describes the policy section used for an image.
+type policyScope struct {
+	Default   bool   `json:"default"`             // The global default requirements were used
+	Transport string `json:"transport,omitempty"` // Set if !Default
+	Scope     string `json:"scope"`               // Set if !Default; "" is the transport-wide default scope
+}
+
+// policyRequirementsForImage returns the policy section which applies to ref, and its requirements.
+// This mirrors the lookup done by signature.PolicyContext.
+func policyRequirementsForImage(policy *signature.Policy, ref types.ImageReference) (policyScope, signature.PolicyRequirements) {
+	transportName := ref.Transport().Name()
+	if transportScopes, ok := policy.Transports[transportName]; ok {
+		identity := ref.PolicyConfigurationIdentity()
+		if reqs, ok := transportScopes[identity]; ok {
+			return policyScope{Transport: transportName, Scope: identity}, reqs
+		}
+		for _, name := range ref.PolicyConfigurationNamespaces() {
+			if reqs, ok := transportScopes[name]; ok {
+				return policyScope{Transport: transportName, Scope: name}, reqs
+			}
+		}
+		if reqs, ok := transportScopes[""]; ok {
+			return policyScope{Transport: transportName, Scope: ""}, reqs
+		}
+	}
+	return policyScope{Default: true}, policy.Default
+}
+
+// registriesDNamespace is a single "docker" or "default-docker" section of a registries.d file.
+// NOTE: Keep this in sync with containers-registries.d(5).
+type registriesDNamespace struct {
+	Lookaside              string `yaml:"lookaside"`
+	LookasideStaging       string `yaml:"lookaside-staging"`
+	SigStore               string `yaml:"sigstore"`
+	SigStoreStaging        string `yaml:"sigstore-staging"`
+	UseSigstoreAttachments *bool  `yaml:"use-sigstore-attachments,omitempty"`
+}
+
+// registriesDFile is the contents of a registries.d file.
+type registriesDFile struct {
+	DefaultDocker *registriesDNamespace           `yaml:"default-docker"`
+	Docker        map[string]registriesDNamespace `yaml:"docker"`
+}
+
+// registriesDSection is a registries.d section which may apply to an image.
+type registriesDSection struct {
+	name string // Human-readable description, including the file name
+	ns   registriesDNamespace
+}
+
+// registriesDirPath returns the registries.d directory used for sys.
+// This mirrors the lookup done by c/image/docker; that does not export it.
+func registriesDirPath(sys *types.SystemContext) string {
+	if sys != nil && sys.RegistriesDirPath != "" {
+		return sys.RegistriesDirPath
+	}
+	userPath := filepath.Join(homedir.Get(), ".config/containers/registries.d")
+	if _, err := os.Stat(userPath); err == nil {
+		return userPath
+	}
+	if sys != nil && sys.RootForImplicitAbsolutePaths != "" {
+		return filepath.Join(sys.RootForImplicitAbsolutePaths, "/etc/containers/registries.d")
+	}
+	return "/etc/containers/registries.d"
+}
+
+// registriesDSectionsForImage returns the sections of the registries.d configuration in dirPath which may apply to ref,
+// most specific first.
+func registriesDSectionsForImage(dirPath string, ref types.ImageReference) ([]registriesDSection, error) {
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var defaultDocker *registriesDSection
+	namespaces := map[string]registriesDSection{}
+	for _, e := range entries {
+		if !strings.HasSuffix(e.Name(), ".yaml") {
+			continue
+		}
+		path := filepath.Join(dirPath, e.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var file registriesDFile
+		if err := yaml.Unmarshal(data, &file); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", path, err)
+		}
+		if file.DefaultDocker != nil {
+			defaultDocker = &registriesDSection{name: fmt.Sprintf(`"default-docker" in %s`, path), ns: *file.DefaultDocker}
+		}
+		for name, ns := range file.Docker {
+			namespaces[name] = registriesDSection{name: fmt.Sprintf(`"docker" namespace %q in %s`, name, path), ns: ns}
+		}
+	}
+
+	var res []registriesDSection
+	for _, name := range append([]string{ref.PolicyConfigurationIdentity()}, ref.PolicyConfigurationNamespaces()...) {
+		if section, ok := namespaces[name]; ok {
+			res = append(res, section)
+		}
+	}
+	if defaultDocker != nil {
+		res = append(res, *defaultDocker)
+	}
+	return res, nil
+}
+
+// explainSignatureStorage returns the registries.d configuration which applies to ref, a docker:// reference.
+func explainSignatureStorage(sys *types.SystemContext, ref types.ImageReference) (*signatureStorage, error) {
+	dirPath := registriesDirPath(sys)
+	sections, err := registriesDSectionsForImage(dirPath, ref)
+	if err != nil {
+		return nil, err
+	}
+	res := &signatureStorage{
+		RegistriesDir:          dirPath,
+		SigstoreAttachmentsSet: sourceBuiltinDefault,
+	}
+	for _, write := range []bool{false, true} {
+		u, err := docker.SignatureStorageBaseURL(sys, ref, write)
+		if err != nil {
+			return nil, err
+		}
+		loc := storageLocation{URL: u.Redacted(), Source: sourceBuiltinDefault}
+		for _, section := range sections {
+			if lookasideTopLevel(section.ns, write) != "" {
+				loc.Source = section.name
+				break
+			}
+		}
+		if write {
+			res.LookasideWrite = loc
+		} else {
+			res.Lookaside = loc
+		}
+	}
+	for _, section := range sections {
+		if section.ns.UseSigstoreAttachments != nil {
+			res.UseSigstoreAttachments = *section.ns.UseSigstoreAttachments
+			res.SigstoreAttachmentsSet = section.name
+			break
+		}
+	}
+	return res, nil
+}
+
+// lookasideTopLevel returns the lookaside URL configured in ns, for write access if “write”, or "" if none.
+func lookasideTopLevel(ns registriesDNamespace, write bool) string {
+	if write {
+		if ns.LookasideStaging != "" {
+			return ns.LookasideStaging
+		}
+		if ns.SigStoreStaging != "" {
+			return ns.SigStoreStaging
+		}
+	}
+	if ns.Lookaside != "" {
+		return ns.Lookaside
+	}
+	return ns.SigStore
+}
+
+// writeText writes a human-readable version of e to w.
+func (e *policyExplanation) writeText(w io.Writer) {
+	fmt.Fprintf(w, "Image:           %s\n", e.Image)
+	fmt.Fprintf(w, "Transport:       %s\n", e.Transport)
+	fmt.Fprintf(w, "Identity:        %q\n", e.Identity)
+	if e.Scope.Default {
+		fmt.Fprintf(w, "Policy scope:    default\n")
+	} else {
+		fmt.Fprintf(w, "Policy scope:    transport %q, scope %q\n", e.Scope.Transport, e.Scope.Scope)
+	}
+	if e.RequireSigned {
+		fmt.Fprintf(w, "Require signed:  true\n")
+	}
+
+	fmt.Fprintf(w, "\nRequirements:\n")
+	if len(e.Requirements) == 0 {
+		fmt.Fprintf(w, "  (none)\n")
+	}
+	for i, req := range e.Requirements {
+		fmt.Fprintf(w, "  [%d] %s\n", i, string(req))
+	}
+
+	fmt.Fprintf(w, "\nSignature storage:\n")
+	if e.SignatureStore == nil {
+		fmt.Fprintf(w, "  Signatures are stored by the %q transport; registries.d does not apply\n", e.Transport)
+		return
+	}
+	s := e.SignatureStore
+	fmt.Fprintf(w, "  registries.d:             %s\n", s.RegistriesDir)
+	fmt.Fprintf(w, "  Lookaside (read):         %s (%s)\n", s.Lookaside.URL, s.Lookaside.Source)
+	fmt.Fprintf(w, "  Lookaside (write):        %s (%s)\n", s.LookasideWrite.URL, s.LookasideWrite.Source)
+	fmt.Fprintf(w, "  Use sigstore attachments: %t (%s)\n", s.UseSigstoreAttachments, s.SigstoreAttachmentsSet)
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"go.podman.io/image/v5/signature"
+	"go.podman.io/storage/pkg/ioutils"
+)
+
+// editablePolicy is a policy.json file being edited.
+// It only interprets the structure of the file down to individual requirements,
+// so that other contents of the file are preserved as much as possible.
+type editablePolicy struct {
+	path       string
+	mode       os.FileMode
+	topLevel   map[string]json.RawMessage // All top-level fields, except for "default" and "transports"
+	defaultReq []json.RawMessage
+	transports map[string]map[string][]json.RawMessage
+}
+
+// loadEditablePolicy reads a policy from path.
+func loadEditablePolicy(path string) (*editablePolicy, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := signature.NewPolicyFromBytes(contents); err != nil {
+		return nil, fmt.Errorf("invalid policy in %q: %w", path, err)
+	}
+	p := editablePolicy{path: path, mode: fi.Mode().Perm()}
+	if err := json.Unmarshal(contents, &p.topLevel); err != nil {
+		return nil, fmt.Errorf("parsing %q: %w", path, err)
+	}
+	if err := json.Unmarshal(p.topLevel["default"], &p.defaultReq); err != nil {
+		return nil, fmt.Errorf("parsing %q: %w", path, err)
+	}
+	delete(p.topLevel, "default")
+	if transportsJSON, ok := p.topLevel["transports"]; ok {
+		if err := json.Unmarshal(transportsJSON, &p.transports); err != nil {
+			return nil, fmt.Errorf("parsing %q: %w", path, err)
+		}
+		delete(p.topLevel, "transports")
+	}
+	if p.transports == nil {
+		p.transports = map[string]map[string][]json.RawMessage{}
+	}
+	return &p, nil
+}
+
+// marshal returns the contents of p, after validating them.
+func (p *editablePolicy) marshal() ([]byte, error) {
+	fields := map[string]any{}
+	for k, v := range p.topLevel {
+		fields[k] = v
+	}
+	fields["default"] = p.defaultReq
+	if len(p.transports) != 0 {
+		fields["transports"] = p.transports
+	}
+	contents, err := json.MarshalIndent(fields, "", "    ")
+	if err != nil {
+		return nil, err
+	}
+	contents = append(contents, '\n')
+	if _, err := signature.NewPolicyFromBytes(contents); err != nil {
+		return nil, fmt.Errorf("the modified policy would be invalid: %w", err)
+	}
+	return contents, nil
+}
+
+// save validates p and atomically replaces the original file with it.
+func (p *editablePolicy) save() error {
+	contents, err := p.marshal()
+	if err != nil {
+		return err
+	}
+	// AtomicWriteFile replaces path, so write to the target of a symlink instead of replacing the symlink.
+	path, err := filepath.EvalSymlinks(p.path)
+	if err != nil {
+		return err
+	}
+	return ioutils.AtomicWriteFile(path, contents, p.mode)
+}
+
+type policyAddRequirementOptions struct {
+	global          *globalOptions
+	transport       string   // Transport of the scope to modify; "" for the global default
+	scope           string   // Scope within transport
+	replace         bool     // Replace all existing requirements of the scope
+	requirementType string   // Type of the new requirement, if not requirementJSON
+	keyType         string   // signedBy keyType
+	keyPaths        []string // Key files for signedBy or sigstoreSigned
+	signedIdentity  string   // Type of the signedIdentity matcher, if not the default
+	requirementJSON string   // The new requirement, in JSON
+}
+
+func policyAddRequirementCmd(global *globalOptions) *cobra.Command {
+	opts := policyAddRequirementOptions{
+		global: global,
+	}
+	cmd := &cobra.Command{
+		Use:   "add-requirement [command options] POLICY-FILE",
+		Short: "Add a requirement to a scope of POLICY-FILE",
+		Long: `Add a requirement to a scope of a signature verification policy file, creating the scope if it does not exist.
+
+The requirement is specified either using --type and related options, or as JSON using --requirement.
+Key files referenced by the new requirement must exist and be usable.
+
+The file is only replaced if the modified policy is valid.`,
+		RunE: commandAction(opts.run),
+		Example: `skopeo policy add-requirement --transport docker --scope registry.example.com --type signedBy --key-path /etc/pki/example.gpg /etc/containers/policy.json
+skopeo policy add-requirement --transport docker --scope registry.example.com --replace --type sigstoreSigned --key-path /etc/pki/example.pub /etc/containers/policy.json`,
+	}
+	adjustUsage(cmd)
+	flags := cmd.Flags()
+	flags.StringVar(&opts.transport, "transport", "", "Modify a scope of `TRANSPORT` (default: the global default requirements)")
+	flags.StringVar(&opts.scope, "scope", "", "Modify `SCOPE` of the transport (default: the transport-wide scope)")
+	flags.BoolVar(&opts.replace, "replace", false, "Replace all existing requirements of the scope")
+	flags.StringVar(&opts.requirementType, "type", "", "Add a requirement of `TYPE` (reject, insecureAcceptAnything, signedBy, sigstoreSigned)")
+	flags.StringVar(&opts.keyType, "key-type", "GPGKeys", "Use `KEY-TYPE` for a signedBy requirement")
+	flags.StringSliceVar(&opts.keyPaths, "key-path", nil, "Trust keys in `PATH` for a signedBy or sigstoreSigned requirement (can be repeated)")
+	flags.StringVar(&opts.signedIdentity, "signed-identity", "", "Match the signed identity using `TYPE` (matchExact, matchRepoDigestOrExact, matchRepository)")
+	flags.StringVar(&opts.requirementJSON, "requirement", "", "Add `JSON` as the requirement")
+	return cmd
+}
+
+// newRequirementFields is a requirement built by (skopeo policy add-requirement --type …).
+type newRequirementFields struct {
+	Type           string                  `json:"type"`
+	KeyType        string                  `json:"keyType,omitempty"`
+	KeyPath        string                  `json:"keyPath,omitempty"`
+	KeyPaths       []string                `json:"keyPaths,omitempty"`
+	SignedIdentity *newRequirementIdentity `json:"signedIdentity,omitempty"`
+}
+
+// newRequirementIdentity is a signedIdentity of newRequirementFields.
+type newRequirementIdentity struct {
+	Type string `json:"type"`
+}
+
+// newRequirement returns the JSON of the requirement specified by opts.
+func (opts *policyAddRequirementOptions) newRequirement() (json.RawMessage, error) {
+	if opts.requirementJSON != "" {
+		if opts.requirementType != "" || len(opts.keyPaths) != 0 || opts.signedIdentity != "" {
+			return nil, errors.New("--requirement can not be used together with --type, --key-path or --signed-identity")
+		}
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, []byte(opts.requirementJSON)); err != nil {
+			return nil, fmt.Errorf("parsing --requirement: %w", err)
+		}
+		return buf.Bytes(), nil
+	}
+
+	req := newRequirementFields{Type: opts.requirementType}
+	switch opts.requirementType {
+	case "":
+		return nil, errors.New("Either --type or --requirement must be specified")
+	case "reject", "insecureAcceptAnything":
+		if len(opts.keyPaths) != 0 || opts.signedIdentity != "" {
+			return nil, fmt.Errorf("--key-path and --signed-identity can not be used with --type %s", opts.requirementType)
+		}
+		return json.Marshal(req)
+	case "signedBy":
+		req.KeyType = opts.keyType
+	case "sigstoreSigned":
+	default:
+		return nil, fmt.Errorf("unsupported requirement type %q, use --requirement", opts.requirementType)
+	}
+	if len(opts.keyPaths) == 0 {
+		return nil, fmt.Errorf("--key-path is required for --type %s", opts.requirementType)
+	}
+	keyPaths := make([]string, 0, len(opts.keyPaths))
+	for _, path := range opts.keyPaths {
+		// The policy is used by commands running in arbitrary working directories.
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return nil, err
+		}
+		keyPaths = append(keyPaths, absPath)
+	}
+	if len(keyPaths) == 1 {
+		req.KeyPath = keyPaths[0]
+	} else {
+		req.KeyPaths = keyPaths
+	}
+	switch opts.signedIdentity {
+	case "":
+	case "matchExact", "matchRepoDigestOrExact", "matchRepository":
+		req.SignedIdentity = &newRequirementIdentity{Type: opts.signedIdentity}
+	default:
+		return nil, fmt.Errorf("unsupported --signed-identity %q, use --requirement", opts.signedIdentity)
+	}
+	return json.Marshal(req)
+}
+
+func (opts *policyAddRequirementOptions) run(args []string, stdout io.Writer) error {
+	if len(args) != 1 {
+		return errorShouldDisplayUsage{errors.New("Exactly one argument expected")}
+	}
+	if opts.transport == "" && opts.scope != "" {
+		return errorShouldDisplayUsage{errors.New("--scope requires --transport")}
+	}
+	reqJSON, err := opts.newRequirement()
+	if err != nil {
+		return err
+	}
+	req, err := newPolicyRequirementFromJSON(reqJSON)
+	if err != nil {
+		return err
+	}
+	fields, err := lintFieldsOfRequirement(req)
+	if err != nil {
+		return err
+	}
+	for _, f := range fields.lint("new requirement") {
+		if f.Severity == policyLintError {
+			return fmt.Errorf("%s: %s", f.Location, f.Message)
+		}
+		logrus.Warnf("%s: %s", f.Location, f.Message)
+	}
+
+	p, err := loadEditablePolicy(args[0])
+	if err != nil {
+		return err
+	}
+	var location string
+	if opts.transport == "" {
+		location = "default"
+		if opts.replace {
+			p.defaultReq = nil
+		}
+		p.defaultReq = append(p.defaultReq, reqJSON)
+	} else {
+		location = fmt.Sprintf("transports[%q][%q]", opts.transport, opts.scope)
+		scopes, ok := p.transports[opts.transport]
+		if !ok {
+			scopes = map[string][]json.RawMessage{}
+			p.transports[opts.transport] = scopes
+		}
+		if opts.replace {
+			scopes[opts.scope] = nil
+		}
+		scopes[opts.scope] = append(scopes[opts.scope], reqJSON)
+	}
+	if err := p.save(); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "Added %s to %s\n", string(reqJSON), location)
+	return nil
+}
+
+// newPolicyRequirementFromJSON parses a single policy requirement.
+func newPolicyRequirementFromJSON(reqJSON []byte) (signature.PolicyRequirement, error) {
+	// signature.Policy is the only public way to parse requirements.
+	policy, err := signature.NewPolicyFromBytes(fmt.Appendf(nil, `{"default":[%s]}`, reqJSON))
+	if err != nil {
+		return nil, fmt.Errorf("invalid requirement %s: %w", string(reqJSON), err)
+	}
+	return policy.Default[0], nil
+}
+
+type policyRemoveScopeOptions struct {
+	global    *globalOptions
+	transport string // Transport of the scope to remove
+	scope     string // Scope within transport
+}
+
+func policyRemoveScopeCmd(global *globalOptions) *cobra.Command {
+	opts := policyRemoveScopeOptions{
+		global: global,
+	}
+	cmd := &cobra.Command{
+		Use:   "remove-scope [command options] --transport TRANSPORT POLICY-FILE",
+		Short: "Remove a scope from POLICY-FILE",
+		Long: `Remove a scope, with all of its requirements, from a signature verification policy file.
+Images in that scope will be subject to the requirements of a less specific scope.
+
+The file is only replaced if the modified policy is valid.`,
+		RunE:    commandAction(opts.run),
+		Example: `skopeo policy remove-scope --transport docker --scope registry.example.com /etc/containers/policy.json`,
+	}
+	adjustUsage(cmd)
+	flags := cmd.Flags()
+	flags.StringVar(&opts.transport, "transport", "", "Remove a scope of `TRANSPORT`")
+	flags.StringVar(&opts.scope, "scope", "", "Remove `SCOPE` of the transport (default: the transport-wide scope)")
+	return cmd
+}
+
+func (opts *policyRemoveScopeOptions) run(args []string, stdout io.Writer) error {
+	if len(args) != 1 {
+		return errorShouldDisplayUsage{errors.New("Exactly one argument expected")}
+	}
+	if opts.transport == "" {
+		return errorShouldDisplayUsage{errors.New("--transport must be specified; the global default requirements can not be removed")}
+	}
+	p, err := loadEditablePolicy(args[0])
+	if err != nil {
+		return err
+	}
+	location := fmt.Sprintf("transports[%q][%q]", opts.transport, opts.scope)
+	scopes := p.transports[opts.transport]
+	if _, ok := scopes[opts.scope]; !ok {
+		existing := make([]string, 0, len(scopes))
+		for scope := range scopes {
+			existing = append(existing, fmt.Sprintf("%q", scope))
+		}
+		slices.Sort(existing)
+		return fmt.Errorf("%s does not exist in %s (existing scopes of transport %q: %v)", location, p.path, opts.transport, existing)
+	}
+	delete(scopes, opts.scope)
+	if len(scopes) == 0 {
+		delete(p.transports, opts.transport)
+	}
+	if err := p.save(); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "Removed %s\n", location)
+	return nil
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/spf13/cobra"
+	"go.podman.io/image/v5/signature"
+	"go.podman.io/image/v5/transports"
+)
+
+type policyLintOptions struct {
+	global *globalOptions
+}
+
+func policyLintCmd(global *globalOptions) *cobra.Command {
+	opts := policyLintOptions{
+		global: global,
+	}
+	cmd := &cobra.Command{
+		Use:   "lint [command options] POLICY-FILE",
+		Short: "Check POLICY-FILE for errors",
+		Long: `Check a signature verification policy file for errors which would only be detected when copying images:
+the file must be a valid policy, and all key and certificate files it refers to must exist and be usable.
+
+Also warn about requirements which are likely to be mistakes, e.g. insecureAcceptAnything in transport-specific scopes.
+
+Exits with a non-zero status if errors were found.`,
+		RunE:    commandAction(opts.run),
+		Example: `skopeo policy lint /etc/containers/policy.json`,
+	}
+	adjustUsage(cmd)
+	return cmd
+}
+
+// policyLintSeverity is the severity of a policyLintFinding.
+type policyLintSeverity string
+
+const (
+	policyLintError   policyLintSeverity = "error"
+	policyLintWarning policyLintSeverity = "warning"
+)
+
+// policyLintFinding is a single problem found by lintPolicy.
+type policyLintFinding struct {
+	Location string // e.g. `transports["docker"]["quay.io"][0]`
+	Severity policyLintSeverity
+	Message  string
+}
+
+func (f policyLintFinding) String() string {
+	return fmt.Sprintf("%s: %s: %s", f.Location, f.Severity, f.Message)
+}
+
+func (opts *policyLintOptions) run(args []string, stdout io.Writer) error {
+	if len(args) != 1 {
+		return errorShouldDisplayUsage{errors.New("Exactly one argument expected")}
+	}
+	policyPath := args[0]
+
+	contents, err := os.ReadFile(policyPath)
+	if err != nil {
+		return err
+	}
+	policy, err := signature.NewPolicyFromBytes(contents)
+	if err != nil {
+		return fmt.Errorf("invalid policy in %q: %w", policyPath, err)
+	}
+
+	errorCount := 0
+	for _, f := range lintPolicy(policy) {
+		fmt.Fprintf(stdout, "%s: %s\n", policyPath, f)
+		if f.Severity == policyLintError {
+			errorCount++
+		}
+	}
+	if errorCount != 0 {
+		return fmt.Errorf("%d errors found in %s", errorCount, policyPath)
+	}
+	return nil
+}
+
+// lintPolicy returns problems found in policy, which has already been successfully parsed.
+func lintPolicy(policy *signature.Policy) []policyLintFinding {
+	res := lintRequirements("default", policy.Default, false)
+
+	transportNames := make([]string, 0, len(policy.Transports))
+	for name := range policy.Transports {
+		transportNames = append(transportNames, name)
+	}
+	sort.Strings(transportNames)
+	for _, transportName := range transportNames {
+		if transports.Get(transportName) == nil {
+			res = append(res, policyLintFinding{
+				Location: fmt.Sprintf("transports[%q]", transportName),
+				Severity: policyLintWarning,
+				Message:  fmt.Sprintf("unknown transport %q, its scopes are not validated", transportName),
+			})
+		}
+		scopes := policy.Transports[transportName]
+		scopeNames := make([]string, 0, len(scopes))
+		for name := range scopes {
+			scopeNames = append(scopeNames, name)
+		}
+		sort.Strings(scopeNames)
+		for _, scopeName := range scopeNames {
+			location := fmt.Sprintf("transports[%q][%q]", transportName, scopeName)
+			res = append(res, lintRequirements(location, scopes[scopeName], true)...)
+		}
+	}
+	return res
+}
+
+// lintRequirements returns problems found in reqs, the requirements of a scope at location.
+// transportScope is true for scopes in the "transports" section, false for the global default.
+func lintRequirements(location string, reqs signature.PolicyRequirements, transportScope bool) []policyLintFinding {
+	var res []policyLintFinding
+	reqTypes := map[string]bool{}
+	for i, req := range reqs {
+		reqLocation := fmt.Sprintf("%s[%d]", location, i)
+		fields, err := lintFieldsOfRequirement(req)
+		if err != nil {
+			res = append(res, policyLintFinding{Location: reqLocation, Severity: policyLintError, Message: err.Error()})
+			continue
+		}
+		reqTypes[fields.Type] = true
+		if fields.Type == "insecureAcceptAnything" && transportScope {
+			res = append(res, policyLintFinding{
+				Location: reqLocation,
+				Severity: policyLintWarning,
+				Message:  "insecureAcceptAnything in a transport-specific scope accepts images regardless of the default requirements",
+			})
+		}
+		res = append(res, fields.lint(reqLocation)...)
+	}
+	if len(reqs) > 1 {
+		if reqTypes["reject"] {
+			res = append(res, policyLintFinding{
+				Location: location,
+				Severity: policyLintWarning,
+				Message:  "the scope contains a reject requirement, so all images are rejected and the other requirements have no effect",
+			})
+		} else if reqTypes["insecureAcceptAnything"] {
+			res = append(res, policyLintFinding{
+				Location: location,
+				Severity: policyLintWarning,
+				Message:  "insecureAcceptAnything has no effect when combined with other requirements",
+			})
+		}
+	}
+	return res
+}
+
+// lintRequirementFields contains the fields of a marshaled signature.PolicyRequirement which refer to keys or certificates.
+type lintRequirementFields struct {
+	requirementFields
+	KeyDatas [][]byte `json:"keyDatas"`
+	Fulcio   *struct {
+		CAPath string `json:"caPath"`
+		CAData []byte `json:"caData"`
+	} `json:"fulcio"`
+	RekorPublicKeyPath  string   `json:"rekorPublicKeyPath"`
+	RekorPublicKeyPaths []string `json:"rekorPublicKeyPaths"`
+	RekorPublicKeyData  []byte   `json:"rekorPublicKeyData"`
+	RekorPublicKeyDatas [][]byte `json:"rekorPublicKeyDatas"`
+	PKI                 *struct {
+		CARootsPath         string `json:"caRootsPath"`
+		CARootsData         []byte `json:"caRootsData"`
+		CAIntermediatesPath string `json:"caIntermediatesPath"`
+		CAIntermediatesData []byte `json:"caIntermediatesData"`
+	} `json:"pki"`
+}
+
+// lintFieldsOfRequirement returns the fields of req relevant for lintRequirements.
+func lintFieldsOfRequirement(req signature.PolicyRequirement) (*lintRequirementFields, error) {
+	reqJSON, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	var fields lintRequirementFields
+	if err := json.Unmarshal(reqJSON, &fields); err != nil {
+		return nil, err
+	}
+	return &fields, nil
+}
+
+// lint returns problems found in the keys and certificates referenced by f, a requirement at location.
+func (f *lintRequirementFields) lint(location string) []policyLintFinding {
+	var res []policyLintFinding
+	check := func(what, path string, data []byte, parse func([]byte) error) {
+		if path != "" {
+			if !filepath.IsAbs(path) {
+				res = append(res, policyLintFinding{
+					Location: location,
+					Severity: policyLintWarning,
+					Message:  fmt.Sprintf("%s path %q is relative, it will be resolved relative to the working directory of each command", what, path),
+				})
+			}
+			d, err := os.ReadFile(path)
+			if err != nil {
+				res = append(res, policyLintFinding{Location: location, Severity: policyLintError, Message: fmt.Sprintf("reading %s: %v", what, err)})
+				return
+			}
+			data = d
+		}
+		if err := parse(data); err != nil {
+			source := "inline data"
+			if path != "" {
+				source = path
+			}
+			res = append(res, policyLintFinding{Location: location, Severity: policyLintError, Message: fmt.Sprintf("invalid %s in %s: %v", what, source, err)})
+		}
+	}
+
+	switch f.Type {
+	case "signedBy":
+		if f.KeyType != "GPGKeys" {
+			res = append(res, policyLintFinding{
+				Location: location,
+				Severity: policyLintError,
+				Message:  fmt.Sprintf("keyType %q is not supported, only GPGKeys is implemented", f.KeyType),
+			})
+			break
+		}
+		for _, path := range append([]string{f.KeyPath}, f.KeyPaths...) {
+			if path != "" {
+				check("GPG key", path, nil, parseGPGKeys)
+			}
+		}
+		if f.KeyData != nil {
+			check("GPG key", "", f.KeyData, parseGPGKeys)
+		}
+
+	case "sigstoreSigned":
+		for _, path := range append([]string{f.KeyPath}, f.KeyPaths...) {
+			if path != "" {
+				check("public key", path, nil, parsePEMPublicKeys)
+			}
+		}
+		for _, data := range append([][]byte{f.KeyData}, f.KeyDatas...) {
+			if data != nil {
+				check("public key", "", data, parsePEMPublicKeys)
+			}
+		}
+		if f.Fulcio != nil {
+			if f.Fulcio.CAPath != "" || f.Fulcio.CAData != nil {
+				check("Fulcio CA certificate", f.Fulcio.CAPath, f.Fulcio.CAData, parsePEMCertificates)
+			}
+		}
+		for _, path := range append([]string{f.RekorPublicKeyPath}, f.RekorPublicKeyPaths...) {
+			if path != "" {
+				check("Rekor public key", path, nil, parsePEMPublicKeys)
+			}
+		}
+		for _, data := range append([][]byte{f.RekorPublicKeyData}, f.RekorPublicKeyDatas...) {
+			if data != nil {
+				check("Rekor public key", "", data, parsePEMPublicKeys)
+			}
+		}
+		if f.PKI != nil {
+			if f.PKI.CARootsPath != "" || f.PKI.CARootsData != nil {
+				check("PKI root certificate", f.PKI.CARootsPath, f.PKI.CARootsData, parsePEMCertificates)
+			}
+			if f.PKI.CAIntermediatesPath != "" || f.PKI.CAIntermediatesData != nil {
+				check("PKI intermediate certificate", f.PKI.CAIntermediatesPath, f.PKI.CAIntermediatesData, parsePEMCertificates)
+			}
+		}
+	}
+	return res
+}
+
+// parseGPGKeys returns an error if data does not contain any usable GPG public keys.
+func parseGPGKeys(data []byte) error {
+	mech, keyIdentities, err := signature.NewEphemeralGPGSigningMechanism(data)
+	if err != nil {
+		return err
+	}
+	mech.Close()
+	if len(keyIdentities) == 0 {
+		return errors.New("no keys found")
+	}
+	return nil
+}
+
+// parsePEMBlocks calls parse for each PEM block of blockType in data, and returns an error if there are none.
+func parsePEMBlocks(data []byte, blockType string, parse func([]byte) error) error {
+	found := false
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != blockType {
+			continue
+		}
+		if err := parse(block.Bytes); err != nil {
+			return err
+		}
+		found = true
+	}
+	if !found {
+		return fmt.Errorf("no PEM %q blocks found", blockType)
+	}
+	return nil
+}
+
+// parsePEMPublicKeys returns an error if data does not contain usable PEM public keys.
+func parsePEMPublicKeys(data []byte) error {
+	return parsePEMBlocks(data, "PUBLIC KEY", func(der []byte) error {
+		_, err := x509.ParsePKIXPublicKey(der)
+		return err
+	})
+}
+
+// parsePEMCertificates returns an error if data does not contain usable PEM certificates.
+func parsePEMCertificates(data []byte) error {
+	return parsePEMBlocks(data, "CERTIFICATE", func(der []byte) error {
+		_, err := x509.ParseCertificate(der)
+		return err
+	})
+}
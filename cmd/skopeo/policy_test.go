@@ -0,0 +1,324 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.podman.io/image/v5/directory"
+	"go.podman.io/image/v5/signature"
+)
+
+func TestPolicyExplain(t *testing.T) {
+	policyPath := filepath.Join(t.TempDir(), "policy.json")
+	err := os.WriteFile(policyPath, []byte(`{"default":[{"type":"reject"}],"transports":{"docker":{`+
+		`"registry.example.com/ns":[{"type":"signedBy","keyType":"GPGKeys","keyPath":"/key.gpg"}],`+
+		`"":[{"type":"insecureAcceptAnything"}]}}}`), 0o644)
+	require.NoError(t, err)
+	registriesDir := t.TempDir()
+	err = os.WriteFile(filepath.Join(registriesDir, "default.yaml"), []byte("default-docker:\n  lookaside: file:///default\n"), 0o644)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(registriesDir, "example.yaml"), []byte(`docker:
+  registry.example.com/ns:
+    lookaside-staging: file:///staging
+    use-sigstore-attachments: true
+`), 0o644)
+	require.NoError(t, err)
+
+	out, err := runSkopeo("--policy", policyPath, "--registries.d", registriesDir, "policy", "explain", "--format", "json",
+		"docker://registry.example.com/ns/repo:tag")
+	require.NoError(t, err)
+	var res policyExplanation
+	err = json.Unmarshal([]byte(out), &res)
+	require.NoError(t, err)
+	assert.Equal(t, "docker", res.Transport)
+	assert.Equal(t, "registry.example.com/ns/repo:tag", res.Identity)
+	assert.Equal(t, []string{"registry.example.com/ns/repo", "registry.example.com/ns", "registry.example.com", "*.example.com", "*.com"}, res.Namespaces)
+	assert.Equal(t, policyScope{Transport: "docker", Scope: "registry.example.com/ns"}, res.Scope)
+	require.Len(t, res.Requirements, 1)
+	assert.JSONEq(t, `{"type":"signedBy","keyType":"GPGKeys","keyPath":"/key.gpg","signedIdentity":{"type":"matchRepoDigestOrExact"}}`, string(res.Requirements[0]))
+	require.NotNil(t, res.SignatureStore)
+	assert.Equal(t, signatureStorage{
+		RegistriesDir: registriesDir,
+		Lookaside: storageLocation{
+			URL:    "file:///default/ns/repo",
+			Source: `"default-docker" in ` + filepath.Join(registriesDir, "default.yaml"),
+		},
+		LookasideWrite: storageLocation{
+			URL:    "file:///staging/ns/repo",
+			Source: `"docker" namespace "registry.example.com/ns" in ` + filepath.Join(registriesDir, "example.yaml"),
+		},
+		UseSigstoreAttachments: true,
+		SigstoreAttachmentsSet: `"docker" namespace "registry.example.com/ns" in ` + filepath.Join(registriesDir, "example.yaml"),
+	}, *res.SignatureStore)
+
+	// Text output, transport-wide scope
+	out, err = runSkopeo("--policy", policyPath, "--registries.d", registriesDir, "policy", "explain", "docker://quay.io/repo")
+	require.NoError(t, err)
+	assert.Contains(t, out, `Policy scope:    transport "docker", scope ""`)
+	assert.Contains(t, out, `[0] {"type":"insecureAcceptAnything"}`)
+	assert.Contains(t, out, "Lookaside (read):         file:///default/repo")
+	assert.Contains(t, out, "Use sigstore attachments: false (built-in default)")
+
+	// Non-docker transports, default scope
+	out, err = runSkopeo("--policy", policyPath, "policy", "explain", "--format", "json", "dir:"+t.TempDir())
+	require.NoError(t, err)
+	res = policyExplanation{}
+	err = json.Unmarshal([]byte(out), &res)
+	require.NoError(t, err)
+	assert.Equal(t, policyScope{Default: true}, res.Scope)
+	require.Len(t, res.Requirements, 1)
+	assert.JSONEq(t, `{"type":"reject"}`, string(res.Requirements[0]))
+	assert.Nil(t, res.SignatureStore)
+
+	// Invalid usage
+	_, err = runSkopeo("--policy", policyPath, "policy", "explain")
+	assert.Error(t, err)
+	_, err = runSkopeo("--policy", policyPath, "policy", "explain", "--format", "yaml", "docker://quay.io/repo")
+	assert.Error(t, err)
+	_, err = runSkopeo("--policy", policyPath, "policy", "explain", "this is not a reference")
+	assert.Error(t, err)
+	_, err = runSkopeo("--policy", policyPath, "policy")
+	assert.Error(t, err)
+}
+
+func TestPolicyRequirementsForImage(t *testing.T) {
+	base, err := filepath.EvalSymlinks(t.TempDir())
+	require.NoError(t, err)
+	imagePath := filepath.Join(base, "images", "a")
+	err = os.MkdirAll(imagePath, 0o755)
+	require.NoError(t, err)
+	ref, err := directory.Transport.ParseReference(imagePath)
+	require.NoError(t, err)
+	reject := signature.PolicyRequirements{signature.NewPRReject()}
+	accept := signature.PolicyRequirements{signature.NewPRInsecureAcceptAnything()}
+
+	for _, c := range []struct {
+		transportScopes signature.PolicyTransportScopes
+		expectedScope   policyScope
+		expectedReqs    signature.PolicyRequirements
+	}{
+		{nil, policyScope{Default: true}, reject},
+		{signature.PolicyTransportScopes{filepath.Join(base, "other"): accept}, policyScope{Default: true}, reject},
+		{signature.PolicyTransportScopes{"": accept}, policyScope{Transport: "dir", Scope: ""}, accept},
+		{signature.PolicyTransportScopes{"": reject, base: accept}, policyScope{Transport: "dir", Scope: base}, accept},
+		{
+			signature.PolicyTransportScopes{base: reject, imagePath: accept},
+			policyScope{Transport: "dir", Scope: imagePath}, accept,
+		},
+	} {
+		policy := &signature.Policy{Default: reject}
+		if c.transportScopes != nil {
+			policy.Transports = map[string]signature.PolicyTransportScopes{"dir": c.transportScopes}
+		}
+		scope, reqs := policyRequirementsForImage(policy, ref)
+		assert.Equal(t, c.expectedScope, scope)
+		assert.Equal(t, c.expectedReqs, reqs)
+	}
+}
+
+// writeSigstorePublicKey writes a PEM-encoded sigstore public key to dir, and returns its path.
+func writeSigstorePublicKey(t *testing.T, dir string) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	require.NoError(t, err)
+	path := filepath.Join(dir, "key.pub")
+	err = os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o644)
+	require.NoError(t, err)
+	return path
+}
+
+func TestPolicyLint(t *testing.T) {
+	dir := t.TempDir()
+	gpgKeyPath, err := filepath.Abs("fixtures/pubring.gpg")
+	require.NoError(t, err)
+	sigstoreKeyPath := writeSigstorePublicKey(t, dir)
+	invalidKeyPath := filepath.Join(dir, "invalid.pub")
+	err = os.WriteFile(invalidKeyPath, []byte("not a key"), 0o644)
+	require.NoError(t, err)
+	policyPath := filepath.Join(dir, "policy.json")
+
+	// A valid policy
+	err = os.WriteFile(policyPath, fmt.Appendf(nil, `{"default":[{"type":"reject"}],"transports":{"docker":{`+
+		`"registry.example.com/a":[{"type":"signedBy","keyType":"GPGKeys","keyPath":%q}],`+
+		`"registry.example.com/b":[{"type":"sigstoreSigned","keyPath":%q}]}}}`, gpgKeyPath, sigstoreKeyPath), 0o644)
+	require.NoError(t, err)
+	out, err := runSkopeo("policy", "lint", policyPath)
+	require.NoError(t, err)
+	assert.Empty(t, out)
+
+	// Errors and warnings
+	err = os.WriteFile(policyPath, fmt.Appendf(nil, `{"default":[{"type":"reject"}],"transports":{"docker":{`+
+		`"registry.example.com/a":[{"type":"signedBy","keyType":"GPGKeys","keyPath":%q}],`+
+		`"registry.example.com/b":[{"type":"sigstoreSigned","keyPath":%q}],`+
+		`"registry.example.com/c":[{"type":"insecureAcceptAnything"}]}}}`, filepath.Join(dir, "missing.gpg"), invalidKeyPath), 0o644)
+	require.NoError(t, err)
+	out, err = runSkopeo("policy", "lint", policyPath)
+	assert.ErrorContains(t, err, "2 errors found")
+	lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+	require.Len(t, lines, 3)
+	assert.Contains(t, lines[0], `transports["docker"]["registry.example.com/a"][0]: error: reading GPG key`)
+	assert.Contains(t, lines[1], `transports["docker"]["registry.example.com/b"][0]: error: invalid public key in `+invalidKeyPath)
+	assert.Contains(t, lines[2], `transports["docker"]["registry.example.com/c"][0]: warning: insecureAcceptAnything`)
+
+	// Invalid policy
+	err = os.WriteFile(policyPath, []byte(`{"default":[]}`), 0o644)
+	require.NoError(t, err)
+	_, err = runSkopeo("policy", "lint", policyPath)
+	assert.Error(t, err)
+
+	// Invalid usage
+	_, err = runSkopeo("policy", "lint")
+	assert.Error(t, err)
+	_, err = runSkopeo("policy", "lint", filepath.Join(dir, "this/does/not/exist"))
+	assert.Error(t, err)
+}
+
+func TestLintRequirements(t *testing.T) {
+	reject := signature.NewPRReject()
+	accept := signature.NewPRInsecureAcceptAnything()
+	gpgKeyPath, err := filepath.Abs("fixtures/pubring.gpg")
+	require.NoError(t, err)
+	signedBy, err := signature.NewPRSignedByKeyPath(signature.SBKeyTypeGPGKeys, gpgKeyPath, signature.NewPRMMatchRepoDigestOrExact())
+	require.NoError(t, err)
+	relative, err := signature.NewPRSignedByKeyPath(signature.SBKeyTypeGPGKeys, "fixtures/pubring.gpg", signature.NewPRMMatchRepoDigestOrExact())
+	require.NoError(t, err)
+
+	for _, c := range []struct {
+		reqs           signature.PolicyRequirements
+		transportScope bool
+		expected       []policyLintFinding
+	}{
+		{signature.PolicyRequirements{reject}, false, nil},
+		{signature.PolicyRequirements{accept}, false, nil},
+		{signature.PolicyRequirements{signedBy}, true, nil},
+		{signature.PolicyRequirements{accept}, true, []policyLintFinding{
+			{Location: "s[0]", Severity: policyLintWarning, Message: "insecureAcceptAnything in a transport-specific scope accepts images regardless of the default requirements"},
+		}},
+		{signature.PolicyRequirements{signedBy, reject}, false, []policyLintFinding{
+			{Location: "s", Severity: policyLintWarning, Message: "the scope contains a reject requirement, so all images are rejected and the other requirements have no effect"},
+		}},
+		{signature.PolicyRequirements{accept, signedBy}, false, []policyLintFinding{
+			{Location: "s", Severity: policyLintWarning, Message: "insecureAcceptAnything has no effect when combined with other requirements"},
+		}},
+		{signature.PolicyRequirements{relative}, false, []policyLintFinding{
+			{Location: "s[0]", Severity: policyLintWarning, Message: `GPG key path "fixtures/pubring.gpg" is relative, it will be resolved relative to the working directory of each command`},
+		}},
+	} {
+		res := lintRequirements("s", c.reqs, c.transportScope)
+		assert.Equal(t, c.expected, res)
+	}
+}
+
+func TestPolicyAddRequirement(t *testing.T) {
+	dir := t.TempDir()
+	gpgKeyPath, err := filepath.Abs("fixtures/pubring.gpg")
+	require.NoError(t, err)
+	sigstoreKeyPath := writeSigstorePublicKey(t, dir)
+	policyPath := filepath.Join(dir, "policy.json")
+	err = os.WriteFile(policyPath, []byte(`{"default":[{"type":"insecureAcceptAnything"}],"transports":{"docker-daemon":{"":[{"type":"insecureAcceptAnything"}]}}}`), 0o600)
+	require.NoError(t, err)
+
+	out, err := runSkopeo("policy", "add-requirement", "--transport", "docker", "--scope", "registry.example.com",
+		"--type", "signedBy", "--key-path", gpgKeyPath, "--signed-identity", "matchRepository", policyPath)
+	require.NoError(t, err)
+	assert.Contains(t, out, `transports["docker"]["registry.example.com"]`)
+	_, err = runSkopeo("policy", "add-requirement", "--transport", "docker", "--scope", "registry.example.com",
+		"--type", "sigstoreSigned", "--key-path", sigstoreKeyPath, policyPath)
+	require.NoError(t, err)
+	_, err = runSkopeo("policy", "add-requirement", "--replace", "--type", "reject", policyPath)
+	require.NoError(t, err)
+	_, err = runSkopeo("policy", "add-requirement", "--transport", "dir", "--requirement", `{"type": "reject"}`, policyPath)
+	require.NoError(t, err)
+
+	contents, err := os.ReadFile(policyPath)
+	require.NoError(t, err)
+	assert.JSONEq(t, fmt.Sprintf(`{"default":[{"type":"reject"}],"transports":{`+
+		`"dir":{"":[{"type":"reject"}]},`+
+		`"docker":{"registry.example.com":[`+
+		`{"type":"signedBy","keyType":"GPGKeys","keyPath":%q,"signedIdentity":{"type":"matchRepository"}},`+
+		`{"type":"sigstoreSigned","keyPath":%q}]},`+
+		`"docker-daemon":{"":[{"type":"insecureAcceptAnything"}]}}}`, gpgKeyPath, sigstoreKeyPath), string(contents))
+	fi, err := os.Stat(policyPath)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), fi.Mode().Perm())
+
+	// Failures do not modify the file
+	for _, args := range [][]string{
+		{"--type", "signedBy", "--key-path", filepath.Join(dir, "missing.gpg")},          // Missing key
+		{"--type", "signedBy", "--key-path", sigstoreKeyPath},                            // Not a GPG key
+		{"--type", "sigstoreSigned"},                                                     // No key
+		{"--type", "reject", "--key-path", gpgKeyPath},                                   // Key for a reject requirement
+		{"--type", "unknown"},                                                            // Unknown type
+		{"--type", "signedBy", "--key-path", gpgKeyPath, "--signed-identity", "unknown"}, // Unknown identity
+		{}, // No requirement
+		{"--type", "reject", "--requirement", `{"type":"reject"}`},                                                  // Both --type and --requirement
+		{"--requirement", `{"type":"unknown"}`},                                                                     // Invalid requirement
+		{"--requirement", `{`},                                                                                      // Invalid JSON
+		{"--scope", "registry.example.com", "--type", "reject"},                                                     // --scope without --transport
+		{"--transport", "dir", "--scope", "relative/path", "--type", "reject"},                                      // Invalid scope
+		{"--transport", "docker", "--type", "signedBy", "--key-type", "X509Certificates", "--key-path", gpgKeyPath}, // Unsupported key type
+	} {
+		_, err := runSkopeo(append(append([]string{"policy", "add-requirement"}, args...), policyPath)...)
+		assert.Error(t, err, "%#v", args)
+		after, err := os.ReadFile(policyPath)
+		require.NoError(t, err)
+		assert.Equal(t, contents, after, "%#v", args)
+	}
+	_, err = runSkopeo("policy", "add-requirement", "--type", "reject", filepath.Join(dir, "this/does/not/exist"))
+	assert.Error(t, err)
+
+	// A symlink is preserved, and its target is modified
+	linkPath := filepath.Join(dir, "link.json")
+	err = os.Symlink(policyPath, linkPath)
+	require.NoError(t, err)
+	_, err = runSkopeo("policy", "add-requirement", "--transport", "dir", "--replace", "--type", "insecureAcceptAnything", linkPath)
+	require.NoError(t, err)
+	target, err := os.Readlink(linkPath)
+	require.NoError(t, err)
+	assert.Equal(t, policyPath, target)
+	policy, err := signature.NewPolicyFromFile(policyPath)
+	require.NoError(t, err)
+	assert.Equal(t, signature.PolicyRequirements{signature.NewPRInsecureAcceptAnything()}, policy.Transports["dir"][""])
+}
+
+func TestPolicyRemoveScope(t *testing.T) {
+	policyPath := filepath.Join(t.TempDir(), "policy.json")
+	err := os.WriteFile(policyPath, []byte(`{"default":[{"type":"reject"}],"transports":{`+
+		`"docker":{"registry.example.com":[{"type":"insecureAcceptAnything"}],"":[{"type":"reject"}]},`+
+		`"docker-daemon":{"":[{"type":"insecureAcceptAnything"}]}}}`), 0o644)
+	require.NoError(t, err)
+
+	out, err := runSkopeo("policy", "remove-scope", "--transport", "docker", "--scope", "registry.example.com", policyPath)
+	require.NoError(t, err)
+	assert.Equal(t, "Removed transports[\"docker\"][\"registry.example.com\"]\n", out)
+	_, err = runSkopeo("policy", "remove-scope", "--transport", "docker-daemon", policyPath)
+	require.NoError(t, err)
+	contents, err := os.ReadFile(policyPath)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"default":[{"type":"reject"}],"transports":{"docker":{"":[{"type":"reject"}]}}}`, string(contents))
+
+	// Failures do not modify the file
+	for _, args := range [][]string{
+		{"--transport", "docker", "--scope", "registry.example.com"}, // Scope does not exist
+		{"--transport", "docker-daemon"},                             // Transport does not exist
+		{},                                                           // No --transport
+	} {
+		_, err := runSkopeo(append(append([]string{"policy", "remove-scope"}, args...), policyPath)...)
+		assert.Error(t, err, "%#v", args)
+		after, err := os.ReadFile(policyPath)
+		require.NoError(t, err)
+		assert.Equal(t, contents, after, "%#v", args)
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.podman.io/image/v5/copy"
+	"go.podman.io/image/v5/manifest"
+	"go.podman.io/image/v5/transports"
+	"go.podman.io/image/v5/types"
+)
+
+// progressEvent is a single line of the --progress=json output.
+type progressEvent struct {
+	Time        time.Time `json:"time"`
+	Event       string    `json:"event"`
+	Source      string    `json:"source,omitempty"`
+	Destination string    `json:"destination,omitempty"`
+	Digest      string    `json:"digest,omitempty"`    // Blob digest for blob-* events, manifest digest for image-done
+	MediaType   string    `json:"mediaType,omitempty"` // Blob media type, if known
+	Size        int64     `json:"size,omitempty"`      // Blob size, if known
+	Offset      uint64    `json:"offset,omitempty"`    // Number of bytes of the blob processed so far
+	Bytes       uint64    `json:"bytes,omitempty"`     // Number of bytes of the blob processed since the previous event
+	Error       string    `json:"error,omitempty"`
+}
+
+// Values of progressEvent.Event
+const (
+	progressEventImageStart  = "image-start"
+	progressEventImageDone   = "image-done"
+	progressEventImageFailed = "image-failed"
+	progressEventBlobStart   = "blob-start"
+	progressEventBlobBytes   = "blob-bytes"
+	progressEventBlobDone    = "blob-done"
+	progressEventBlobSkipped = "blob-skipped" // The blob already exists at the destination, and was reused
+)
+
+// jsonProgressReporter writes newline-delimited JSON progressEvent values.
+// All methods can be called with a nil receiver, in which case nothing is written.
+type jsonProgressReporter struct {
+	interval time.Duration
+	file     *os.File // Closed by close(), if not nil
+	mutex    sync.Mutex
+	encoder  *json.Encoder // Access guarded by mutex
+}
+
+// newProgressReporter returns a jsonProgressReporter per opts (or nil if structured progress was not requested),
+// and the writer to use for human-readable progress output, which is stdout unless stdout is used for the
+// structured output.
+func (opts *sharedCopyOptions) newProgressReporter(stdout io.Writer) (*jsonProgressReporter, io.Writer, error) {
+	switch opts.progress {
+	case "":
+		if opts.progressFD.Present() {
+			return nil, nil, fmt.Errorf("--progress-fd can only be used with --progress=json")
+		}
+		return nil, stdout, nil
+	case "json":
+	default:
+		return nil, nil, fmt.Errorf("unknown progress format %q. Choose one of the supported formats: 'json'", opts.progress)
+	}
+	if opts.progressInterval <= 0 {
+		return nil, nil, fmt.Errorf("invalid --progress-interval %v, must be positive", opts.progressInterval)
+	}
+
+	res := &jsonProgressReporter{interval: opts.progressInterval}
+	if opts.progressFD.Present() {
+		fd := opts.progressFD.Value()
+		if fd < 0 {
+			return nil, nil, fmt.Errorf("invalid --progress-fd %d", fd)
+		}
+		res.file = os.NewFile(uintptr(fd), fmt.Sprintf("progress-fd-%d", fd))
+		if res.file == nil {
+			return nil, nil, fmt.Errorf("invalid --progress-fd %d", fd)
+		}
+		res.encoder = json.NewEncoder(res.file)
+		return res, stdout, nil
+	}
+	if stdout == nil {
+		stdout = io.Discard
+	}
+	res.encoder = json.NewEncoder(stdout)
+	return res, nil, nil
+}
+
+// close releases resources held by r.
+func (r *jsonProgressReporter) close() error {
+	if r == nil || r.file == nil {
+		return nil
+	}
+	return r.file.Close()
+}
+
+// emit writes event, filling in the current time.
+func (r *jsonProgressReporter) emit(event progressEvent) {
+	event.Time = time.Now().UTC()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if err := r.encoder.Encode(event); err != nil {
+		logrus.Warnf("Error writing progress: %v", err)
+	}
+}
+
+// defaultProgressInterval is used to watch blob progress when no --progress-interval applies.
+const defaultProgressInterval = time.Second
+
+// startImage reports a start of a copy from src to dest, and updates options to report blob progress
+// to r and to all of listeners.
+// The caller must call the returned function with the result of copy.Image after the copy finishes.
+// options must not be shared with other concurrent copies.
+func (r *jsonProgressReporter) startImage(options *copy.Options, src, dest types.ImageReference, listeners ...func(types.ProgressProperties)) func(manifestBytes []byte, err error) {
+	imageEvent := progressEvent{
+		Source:      transports.ImageName(src),
+		Destination: transports.ImageName(dest),
+	}
+	interval := defaultProgressInterval
+	if r != nil {
+		start := imageEvent
+		start.Event = progressEventImageStart
+		r.emit(start)
+		interval = r.interval
+		listeners = append(listeners, func(p types.ProgressProperties) {
+			r.blobProgress(imageEvent, p)
+		})
+	}
+	if len(listeners) == 0 {
+		return func([]byte, error) {}
+	}
+
+	progress := make(chan types.ProgressProperties)
+	options.Progress = progress
+	options.ProgressInterval = interval
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for p := range progress {
+			for _, l := range listeners {
+				l(p)
+			}
+		}
+	}()
+
+	return func(manifestBytes []byte, err error) {
+		options.Progress = nil
+		close(progress)
+		<-done
+		if r == nil {
+			return
+		}
+		event := imageEvent
+		if err != nil {
+			event.Event = progressEventImageFailed
+			event.Error = err.Error()
+		} else {
+			event.Event = progressEventImageDone
+			if manifestDigest, err := manifest.Digest(manifestBytes); err == nil {
+				event.Digest = manifestDigest.String()
+			}
+		}
+		r.emit(event)
+	}
+}
+
+// blobProgress reports p, adding it to imageEvent.
+func (r *jsonProgressReporter) blobProgress(imageEvent progressEvent, p types.ProgressProperties) {
+	event := imageEvent
+	event.Digest = p.Artifact.Digest.String()
+	event.MediaType = p.Artifact.MediaType
+	if p.Artifact.Size > 0 {
+		event.Size = p.Artifact.Size
+	}
+	event.Offset = p.Offset
+	switch p.Event {
+	case types.ProgressEventNewArtifact:
+		event.Event = progressEventBlobStart
+	case types.ProgressEventRead:
+		event.Event = progressEventBlobBytes
+		event.Bytes = p.OffsetUpdate
+	case types.ProgressEventDone:
+		event.Event = progressEventBlobDone
+	case types.ProgressEventSkipped:
+		event.Event = progressEventBlobSkipped
+	default:
+		return // New event types may be added at any time; ignore them.
+	}
+	r.emit(event)
+}
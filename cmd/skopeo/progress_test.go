@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.podman.io/image/v5/copy"
+	"go.podman.io/image/v5/directory"
+	"go.podman.io/image/v5/types"
+)
+
+func TestSharedCopyOptionsNewProgressReporter(t *testing.T) {
+	stdout := &bytes.Buffer{}
+
+	// Default: no structured output
+	opts := fakeSharedCopyOptions(t, []string{})
+	reporter, reportWriter, err := opts.newProgressReporter(stdout)
+	require.NoError(t, err)
+	assert.Nil(t, reporter)
+	assert.Equal(t, stdout, reportWriter)
+
+	// JSON on stdout: human-readable output is disabled
+	opts = fakeSharedCopyOptions(t, []string{"--progress", "json"})
+	reporter, reportWriter, err = opts.newProgressReporter(stdout)
+	require.NoError(t, err)
+	assert.NotNil(t, reporter)
+	assert.Nil(t, reportWriter)
+
+	for _, args := range [][]string{
+		{"--progress", "invalid"},
+		{"--progress-fd", "3"},
+		{"--progress", "json", "--progress-fd", "-1"},
+		{"--progress", "json", "--progress-interval", "0s"},
+	} {
+		opts := fakeSharedCopyOptions(t, args)
+		_, _, err := opts.newProgressReporter(stdout)
+		assert.Error(t, err, strings.Join(args, " "))
+	}
+}
+
+func TestJSONProgressReporterStartImage(t *testing.T) {
+	src, err := directory.Transport.ParseReference("/src")
+	require.NoError(t, err)
+	dest, err := directory.Transport.ParseReference("/dest")
+	require.NoError(t, err)
+	blob := types.BlobInfo{
+		Digest:    digest.Digest("sha256:0000000000000000000000000000000000000000000000000000000000000001"),
+		Size:      10,
+		MediaType: "application/octet-stream",
+	}
+
+	// A nil reporter does nothing
+	var nilReporter *jsonProgressReporter
+	options := copy.Options{}
+	nilReporter.startImage(&options, src, dest)(nil, nil)
+	assert.Nil(t, options.Progress)
+	assert.NoError(t, nilReporter.close())
+
+	out := &bytes.Buffer{}
+	opts := fakeSharedCopyOptions(t, []string{"--progress", "json"})
+	reporter, _, err := opts.newProgressReporter(out)
+	require.NoError(t, err)
+
+	done := reporter.startImage(&options, src, dest)
+	require.NotNil(t, options.Progress)
+	assert.Equal(t, opts.progressInterval, options.ProgressInterval)
+	options.Progress <- types.ProgressProperties{Event: types.ProgressEventNewArtifact, Artifact: blob}
+	options.Progress <- types.ProgressProperties{Event: types.ProgressEventRead, Artifact: blob, Offset: 4, OffsetUpdate: 4}
+	options.Progress <- types.ProgressProperties{Event: types.ProgressEventDone, Artifact: blob, Offset: 10}
+	options.Progress <- types.ProgressProperties{Event: types.ProgressEventSkipped, Artifact: blob}
+	done([]byte("{}"), nil)
+	assert.Nil(t, options.Progress)
+
+	done = reporter.startImage(&options, src, dest)
+	done(nil, errors.New("copy failed"))
+
+	var events []progressEvent
+	decoder := json.NewDecoder(out)
+	for decoder.More() {
+		var e progressEvent
+		err := decoder.Decode(&e)
+		require.NoError(t, err)
+		assert.Equal(t, "dir:/src", e.Source)
+		assert.Equal(t, "dir:/dest", e.Destination)
+		events = append(events, e)
+	}
+	require.Len(t, events, 8)
+	var eventNames []string
+	for _, e := range events {
+		eventNames = append(eventNames, e.Event)
+	}
+	assert.Equal(t, []string{
+		progressEventImageStart, progressEventBlobStart, progressEventBlobBytes, progressEventBlobDone, progressEventBlobSkipped, progressEventImageDone,
+		progressEventImageStart, progressEventImageFailed,
+	}, eventNames)
+	assert.Equal(t, blob.Digest.String(), events[2].Digest)
+	assert.Equal(t, uint64(4), events[2].Bytes)
+	assert.Equal(t, "sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a", events[5].Digest)
+	assert.Equal(t, "copy failed", events[7].Error)
+}
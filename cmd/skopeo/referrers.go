@@ -0,0 +1,637 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+	imgspecs "github.com/opencontainers/image-spec/specs-go"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sirupsen/logrus"
+	"go.podman.io/image/v5/copy"
+	"go.podman.io/image/v5/docker"
+	"go.podman.io/image/v5/docker/reference"
+	"go.podman.io/image/v5/image"
+	"go.podman.io/image/v5/manifest"
+	ocilayout "go.podman.io/image/v5/oci/layout"
+	"go.podman.io/image/v5/pkg/blobinfocache"
+	"go.podman.io/image/v5/transports"
+	"go.podman.io/image/v5/types"
+)
+
+// referrersTransports are the transports supported by --referrers.
+var referrersTransports = []string{docker.Transport.Name(), ocilayout.Transport.Name()}
+
+// checkReferrersTransports returns an error if --referrers can't be used to copy between the named transports.
+func checkReferrersTransports(srcTransport, destTransport string) error {
+	for _, t := range []string{srcTransport, destTransport} {
+		if !slices.Contains(referrersTransports, t) {
+			return fmt.Errorf("--referrers is only supported with the %s transports, not %q", strings.Join(referrersTransports, " and "), t)
+		}
+	}
+	return nil
+}
+
+// referrersFallbackTag returns the tag used by the OCI distribution spec referrers tag schema for subject.
+func referrersFallbackTag(subject digest.Digest) string {
+	return subject.Algorithm().String() + "-" + subject.Encoded()
+}
+
+// ociLayoutDir returns the directory of an oci: reference.
+func ociLayoutDir(ref types.ImageReference) string {
+	dir, _, _ := strings.Cut(ref.StringWithinTransport(), ":")
+	return dir
+}
+
+// referrerManifest contains the fields of an OCI image manifest or index relevant for copying referrers.
+type referrerManifest struct {
+	MediaType    string                 `json:"mediaType"`
+	ArtifactType string                 `json:"artifactType,omitempty"`
+	Config       *imgspecv1.Descriptor  `json:"config,omitempty"`
+	Layers       []imgspecv1.Descriptor `json:"layers,omitempty"`
+	Manifests    []imgspecv1.Descriptor `json:"manifests,omitempty"`
+	Subject      *imgspecv1.Descriptor  `json:"subject,omitempty"`
+	Annotations  map[string]string      `json:"annotations,omitempty"`
+}
+
+// artifactType returns the artifact type of m, as defined by the OCI distribution spec for referrers.
+func (m *referrerManifest) artifactType() string {
+	if m.ArtifactType != "" {
+		return m.ArtifactType
+	}
+	if m.Config != nil {
+		return m.Config.MediaType
+	}
+	return ""
+}
+
+// rewriteSubject returns manifestBytes with the subject field replaced by subject, preserving all other fields.
+func rewriteSubject(manifestBytes []byte, subject imgspecv1.Descriptor) ([]byte, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(manifestBytes, &raw); err != nil {
+		return nil, fmt.Errorf("parsing referrer manifest: %w", err)
+	}
+	subjectBytes, err := json.Marshal(imgspecv1.Descriptor{
+		MediaType: subject.MediaType,
+		Digest:    subject.Digest,
+		Size:      subject.Size,
+	})
+	if err != nil {
+		return nil, err
+	}
+	raw["subject"] = subjectBytes
+	return json.Marshal(raw)
+}
+
+// referrerSubject is a manifest for which referrers should be copied.
+type referrerSubject struct {
+	source      digest.Digest        // Digest of the subject at the source
+	destination imgspecv1.Descriptor // The subject at the destination
+}
+
+// referrersCopier copies referrers from a single source repository to a single destination repository.
+type referrersCopier struct {
+	srcRef, destRef           types.ImageReference
+	src                       types.ImageSource
+	dest                      types.ImageDestination
+	sourceCtx, destinationCtx *types.SystemContext
+	artifactTypes             []string
+	reportWriter              io.Writer
+	cache                     types.BlobInfoCache
+
+	visited          map[digest.Digest]struct{}               // Source digests of referrers already processed
+	ociReferrers     map[digest.Digest][]imgspecv1.Descriptor // Referrers in an oci: source, by subject digest; nil if not loaded yet
+	destAPISupported *bool                                    // Whether the destination registry supports the referrers API, nil if not known yet
+	srcClient        *registryClient                          // For the referrers API of a docker: source, reused for all subjects; nil if not created yet
+	copied           int
+}
+
+// copyReferrers copies referrers of the image copied from srcRef to destRef, if requested by opts.
+// destManifest is the manifest written to destRef, as returned by copy.Image.
+// Referrers are not evaluated against the signature policy; only the image they refer to is.
+func (opts *sharedCopyOptions) copyReferrers(ctx context.Context, options *copy.Options, srcRef, destRef types.ImageReference, destManifest []byte) (retErr error) {
+	if !opts.referrers {
+		return nil
+	}
+
+	src, err := srcRef.NewImageSource(ctx, options.SourceCtx)
+	if err != nil {
+		return fmt.Errorf("Error initializing source %s: %w", transports.ImageName(srcRef), err)
+	}
+	defer func() {
+		if err := src.Close(); err != nil {
+			retErr = noteCloseFailure(retErr, "closing referrers source", err)
+		}
+	}()
+
+	// Referrers are added to an OCI layout without a name, so that they are discoverable from index.json.
+	destTarget := destRef
+	if destRef.Transport().Name() == ocilayout.Transport.Name() {
+		destTarget, err = ocilayout.NewReference(ociLayoutDir(destRef), "")
+		if err != nil {
+			return err
+		}
+	}
+	dest, err := destTarget.NewImageDestination(ctx, options.DestinationCtx)
+	if err != nil {
+		return fmt.Errorf("Error initializing destination %s: %w", transports.ImageName(destRef), err)
+	}
+	defer func() {
+		if err := dest.Close(); err != nil {
+			retErr = noteCloseFailure(retErr, "closing referrers destination", err)
+		}
+	}()
+
+	c := &referrersCopier{
+		srcRef:         srcRef,
+		destRef:        destRef,
+		src:            src,
+		dest:           dest,
+		sourceCtx:      options.SourceCtx,
+		destinationCtx: options.DestinationCtx,
+		artifactTypes:  opts.referrersArtifactTypes,
+		reportWriter:   options.ReportWriter,
+		cache:          blobinfocache.DefaultCache(options.DestinationCtx),
+		visited:        map[digest.Digest]struct{}{},
+	}
+	subjects, err := c.subjects(ctx, destManifest)
+	if err != nil {
+		return err
+	}
+	for _, subject := range subjects {
+		if err := c.copyReferrersOf(ctx, subject); err != nil {
+			return err
+		}
+	}
+	if c.copied == 0 {
+		return nil
+	}
+	return dest.Commit(ctx, image.UnparsedInstance(src, nil))
+}
+
+// subjects returns the manifests for which referrers should be copied, given destManifest written by copy.Image.
+func (c *referrersCopier) subjects(ctx context.Context, destManifest []byte) ([]referrerSubject, error) {
+	srcManifest, _, err := c.src.GetManifest(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	srcDigest, err := manifest.Digest(srcManifest)
+	if err != nil {
+		return nil, err
+	}
+	destDigest, err := manifest.Digest(destManifest)
+	if err != nil {
+		return nil, err
+	}
+	srcMIMEType := manifest.GuessMIMEType(srcManifest)
+	destMIMEType := manifest.GuessMIMEType(destManifest)
+
+	topLevel := referrerSubject{
+		source: srcDigest,
+		destination: imgspecv1.Descriptor{
+			MediaType: destMIMEType,
+			Digest:    destDigest,
+			Size:      int64(len(destManifest)),
+		},
+	}
+	if !manifest.MIMETypeIsMultiImage(srcMIMEType) {
+		return []referrerSubject{topLevel}, nil
+	}
+	srcList, err := manifest.ListFromBlob(srcManifest, srcMIMEType)
+	if err != nil {
+		return nil, fmt.Errorf("parsing manifest list: %w", err)
+	}
+	if !manifest.MIMETypeIsMultiImage(destMIMEType) {
+		// Only a single instance of a multi-platform image was copied. Referrers of the multi-platform image
+		// don’t apply to it, but referrers of the instance do. copy.Image has chosen the instance the same way.
+		instance, err := srcList.ChooseInstance(c.sourceCtx)
+		if err != nil {
+			return nil, err
+		}
+		topLevel.source = instance
+		return []referrerSubject{topLevel}, nil
+	}
+
+	res := []referrerSubject{topLevel}
+	destList, err := manifest.ListFromBlob(destManifest, destMIMEType)
+	if err != nil {
+		return nil, fmt.Errorf("parsing manifest list: %w", err)
+	}
+	sources, err := sourceInstances(srcList, destList)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range destList.Instances() {
+		source, ok := sources[d]
+		if !ok {
+			logrus.Debugf("Not copying referrers of %s, which does not correspond to an instance of the source", d)
+			continue
+		}
+		instance, err := destList.Instance(d)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, referrerSubject{
+			source: source,
+			destination: imgspecv1.Descriptor{
+				MediaType: instance.MediaType,
+				Digest:    d,
+				Size:      instance.Size,
+			},
+		})
+	}
+	return res, nil
+}
+
+// sourceInstances returns the digest of the instance of srcList corresponding to each instance of destList,
+// which was created from srcList by copy.Image.
+// Instances are matched by digest; instances edited by copy.Image, e.g. by converting their format or compression,
+// are matched to the first unmatched source instance with the same platform, because copy.Image preserves the order of instances
+// (but it may have removed some of them from a sparse list). Instances without a match are not included.
+func sourceInstances(srcList, destList manifest.List) (map[digest.Digest]digest.Digest, error) {
+	srcDigests := srcList.Instances()
+	matched := make([]bool, len(srcDigests))
+	res := map[digest.Digest]digest.Digest{}
+	destDigests := destList.Instances()
+	for _, d := range destDigests {
+		if i := slices.Index(srcDigests, d); i != -1 && !matched[i] {
+			matched[i] = true
+			res[d] = d
+		}
+	}
+	for _, d := range destDigests {
+		if _, ok := res[d]; ok {
+			continue
+		}
+		destInstance, err := destList.Instance(d)
+		if err != nil {
+			return nil, err
+		}
+		for i, srcDigest := range srcDigests {
+			if matched[i] {
+				continue
+			}
+			srcInstance, err := srcList.Instance(srcDigest)
+			if err != nil {
+				return nil, err
+			}
+			if samePlatform(srcInstance.ReadOnly.Platform, destInstance.ReadOnly.Platform) {
+				matched[i] = true
+				res[d] = srcDigest
+				break
+			}
+		}
+	}
+	return res, nil
+}
+
+// samePlatform returns true if a and b describe the same platform.
+func samePlatform(a, b *imgspecv1.Platform) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.OS == b.OS && a.Architecture == b.Architecture && a.Variant == b.Variant &&
+		a.OSVersion == b.OSVersion && slices.Equal(a.OSFeatures, b.OSFeatures)
+}
+
+// copyReferrersOf copies referrers of subject, and, recursively, their referrers.
+func (c *referrersCopier) copyReferrersOf(ctx context.Context, subject referrerSubject) error {
+	descriptors, err := c.listSourceReferrers(ctx, subject.source)
+	if err != nil {
+		return fmt.Errorf("listing referrers of %s: %w", subject.source, err)
+	}
+	var copied []imgspecv1.Descriptor
+	for _, desc := range descriptors {
+		if _, ok := c.visited[desc.Digest]; ok {
+			continue
+		}
+		c.visited[desc.Digest] = struct{}{}
+
+		manifestBytes, err := c.getManifest(ctx, desc.Digest)
+		if err != nil {
+			return err
+		}
+		var m referrerManifest
+		if err := json.Unmarshal(manifestBytes, &m); err != nil {
+			return fmt.Errorf("parsing referrer %s: %w", desc.Digest, err)
+		}
+		if m.Subject == nil || m.Subject.Digest != subject.source {
+			// Entries in a referrers tag index can be stale; don’t trust them.
+			logrus.Debugf("Ignoring %s, which does not refer to %s", desc.Digest, subject.source)
+			continue
+		}
+		if len(c.artifactTypes) > 0 && !slices.Contains(c.artifactTypes, m.artifactType()) {
+			logrus.Debugf("Skipping referrer %s with artifact type %q", desc.Digest, m.artifactType())
+			continue
+		}
+
+		destDesc, err := c.copyReferrer(ctx, manifestBytes, &m, subject.destination)
+		if err != nil {
+			return fmt.Errorf("copying referrer %s: %w", desc.Digest, err)
+		}
+		copied = append(copied, destDesc)
+		// Referrers can have referrers of their own, e.g. a signature of an SBOM.
+		if err := c.copyReferrersOf(ctx, referrerSubject{source: desc.Digest, destination: destDesc}); err != nil {
+			return err
+		}
+	}
+	if len(copied) == 0 {
+		return nil
+	}
+	return c.updateDestinationFallbackTag(ctx, subject.destination.Digest, copied)
+}
+
+// listSourceReferrers returns descriptors of referrers of subject at the source.
+// The returned list may contain unrelated manifests; callers must check the subject of each one.
+func (c *referrersCopier) listSourceReferrers(ctx context.Context, subject digest.Digest) ([]imgspecv1.Descriptor, error) {
+	if c.srcRef.Transport().Name() == ocilayout.Transport.Name() {
+		if c.ociReferrers == nil {
+			if err := c.loadOCIReferrers(ctx); err != nil {
+				return nil, err
+			}
+		}
+		return c.ociReferrers[subject], nil
+	}
+
+	repo := reference.TrimNamed(c.srcRef.DockerReference())
+	if c.srcClient == nil {
+		client, err := newRegistryClient(c.sourceCtx, repo)
+		if err != nil {
+			return nil, err
+		}
+		c.srcClient = client
+	}
+	descriptors, supported, err := c.srcClient.fetchReferrersFromAPI(ctx, repo, subject)
+	if err != nil {
+		return nil, err
+	}
+	if supported {
+		return descriptors, nil
+	}
+	index, err := readReferrersTagIndex(ctx, c.sourceCtx, repo, subject)
+	if err != nil || index == nil {
+		return nil, err
+	}
+	return index.Manifests, nil
+}
+
+// loadOCIReferrers finds all manifests with a subject in the index.json of an oci: source.
+func (c *referrersCopier) loadOCIReferrers(ctx context.Context) error {
+	entries, err := ocilayout.List(ociLayoutDir(c.srcRef))
+	if err != nil {
+		return err
+	}
+	c.ociReferrers = map[digest.Digest][]imgspecv1.Descriptor{}
+	seen := map[digest.Digest]struct{}{}
+	for _, entry := range entries {
+		desc := entry.ManifestDescriptor
+		if _, ok := seen[desc.Digest]; ok {
+			continue
+		}
+		seen[desc.Digest] = struct{}{}
+		if desc.MediaType != imgspecv1.MediaTypeImageManifest && desc.MediaType != imgspecv1.MediaTypeImageIndex {
+			continue
+		}
+		manifestBytes, err := c.getManifest(ctx, desc.Digest)
+		if err != nil {
+			return err
+		}
+		var m referrerManifest
+		if err := json.Unmarshal(manifestBytes, &m); err != nil {
+			return fmt.Errorf("parsing manifest %s: %w", desc.Digest, err)
+		}
+		if m.Subject != nil {
+			c.ociReferrers[m.Subject.Digest] = append(c.ociReferrers[m.Subject.Digest], desc)
+		}
+	}
+	return nil
+}
+
+// getManifest returns the manifest with digest d from the source.
+func (c *referrersCopier) getManifest(ctx context.Context, d digest.Digest) ([]byte, error) {
+	manifestBytes, _, err := c.src.GetManifest(ctx, &d)
+	if err != nil {
+		return nil, fmt.Errorf("reading manifest %s: %w", d, err)
+	}
+	matches, err := manifest.MatchesDigest(manifestBytes, d)
+	if err != nil {
+		return nil, err
+	}
+	if !matches {
+		return nil, fmt.Errorf("manifest %s does not match its digest", d)
+	}
+	return manifestBytes, nil
+}
+
+// copyReferrer copies the referrer manifestBytes (parsed as m) and its contents, with subject as its subject
+// at the destination, and returns a descriptor of the copied referrer.
+func (c *referrersCopier) copyReferrer(ctx context.Context, manifestBytes []byte, m *referrerManifest, subject imgspecv1.Descriptor) (imgspecv1.Descriptor, error) {
+	if m.Subject.Digest != subject.Digest {
+		rewritten, err := rewriteSubject(manifestBytes, subject)
+		if err != nil {
+			return imgspecv1.Descriptor{}, err
+		}
+		manifestBytes = rewritten
+	}
+	mediaType := m.MediaType
+	if mediaType == "" {
+		mediaType = manifest.GuessMIMEType(manifestBytes)
+	}
+	desc := imgspecv1.Descriptor{
+		MediaType:    mediaType,
+		Digest:       digest.FromBytes(manifestBytes),
+		Size:         int64(len(manifestBytes)),
+		ArtifactType: m.artifactType(),
+		Annotations:  m.Annotations,
+	}
+	if c.reportWriter != nil {
+		fmt.Fprintf(c.reportWriter, "Copying referrer %s (%s) of %s\n", desc.Digest, desc.ArtifactType, subject.Digest)
+	}
+
+	if err := c.copyContents(ctx, m); err != nil {
+		return imgspecv1.Descriptor{}, err
+	}
+	// In an OCI layout, a referrer must be listed in index.json to be discoverable.
+	var instanceDigest *digest.Digest
+	if c.destRef.Transport().Name() != ocilayout.Transport.Name() {
+		instanceDigest = &desc.Digest
+	}
+	if err := c.dest.PutManifest(ctx, manifestBytes, instanceDigest); err != nil {
+		return imgspecv1.Descriptor{}, err
+	}
+	c.copied++
+	return desc, nil
+}
+
+// copyContents copies the blobs and child manifests referenced by m.
+func (c *referrersCopier) copyContents(ctx context.Context, m *referrerManifest) error {
+	if m.Config != nil {
+		if err := c.copyBlob(ctx, *m.Config, true); err != nil {
+			return err
+		}
+	}
+	for _, layer := range m.Layers {
+		if err := c.copyBlob(ctx, layer, false); err != nil {
+			return err
+		}
+	}
+	for _, child := range m.Manifests {
+		childBytes, err := c.getManifest(ctx, child.Digest)
+		if err != nil {
+			return err
+		}
+		var childManifest referrerManifest
+		if err := json.Unmarshal(childBytes, &childManifest); err != nil {
+			return fmt.Errorf("parsing manifest %s: %w", child.Digest, err)
+		}
+		if err := c.copyContents(ctx, &childManifest); err != nil {
+			return err
+		}
+		if err := c.dest.PutManifest(ctx, childBytes, &child.Digest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyBlob copies a single blob described by desc, unless it already exists at the destination.
+func (c *referrersCopier) copyBlob(ctx context.Context, desc imgspecv1.Descriptor, isConfig bool) (retErr error) {
+	info := types.BlobInfo{Digest: desc.Digest, Size: desc.Size, MediaType: desc.MediaType}
+	reused, _, err := c.dest.TryReusingBlob(ctx, info, c.cache, false)
+	if err != nil {
+		return fmt.Errorf("checking for blob %s at the destination: %w", desc.Digest, err)
+	}
+	if reused {
+		return nil
+	}
+
+	r, _, err := c.src.GetBlob(ctx, info, c.cache)
+	if err != nil {
+		return fmt.Errorf("reading blob %s: %w", desc.Digest, err)
+	}
+	defer func() {
+		if err := r.Close(); err != nil {
+			retErr = noteCloseFailure(retErr, fmt.Sprintf("closing blob %q", desc.Digest.String()), err)
+		}
+	}()
+	verifier := desc.Digest.Verifier()
+	tr := io.TeeReader(r, verifier)
+	if _, err := c.dest.PutBlob(ctx, tr, info, c.cache, isConfig); err != nil {
+		return fmt.Errorf("writing blob %s: %w", desc.Digest, err)
+	}
+	if _, err := io.Copy(io.Discard, tr); err != nil { // Ensure we process all of tr, so that we can validate the digest.
+		return err
+	}
+	if !verifier.Verified() {
+		return fmt.Errorf("corrupt blob %q", desc.Digest.String())
+	}
+	return nil
+}
+
+// updateDestinationFallbackTag adds copied to the referrers tag index of subject at the destination,
+// if the destination is a registry which does not support the referrers API.
+func (c *referrersCopier) updateDestinationFallbackTag(ctx context.Context, subject digest.Digest, copied []imgspecv1.Descriptor) (retErr error) {
+	if c.destRef.Transport().Name() != docker.Transport.Name() {
+		return nil
+	}
+	repo := reference.TrimNamed(c.destRef.DockerReference())
+	if c.destAPISupported == nil {
+		client, err := newRegistryClient(c.destinationCtx, repo)
+		if err != nil {
+			return err
+		}
+		_, supported, err := client.fetchReferrersFromAPI(ctx, repo, subject)
+		if err != nil {
+			return fmt.Errorf("checking for referrers API support at the destination: %w", err)
+		}
+		c.destAPISupported = &supported
+	}
+	if *c.destAPISupported {
+		return nil // The registry tracks referrers on its own.
+	}
+
+	index, err := readReferrersTagIndex(ctx, c.destinationCtx, repo, subject)
+	if err != nil {
+		return err
+	}
+	if index == nil {
+		index = &imgspecv1.Index{
+			Versioned: imgspecs.Versioned{SchemaVersion: 2},
+			MediaType: imgspecv1.MediaTypeImageIndex,
+		}
+	}
+	changed := false
+	for _, desc := range copied {
+		if !slices.ContainsFunc(index.Manifests, func(d imgspecv1.Descriptor) bool { return d.Digest == desc.Digest }) {
+			index.Manifests = append(index.Manifests, desc)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	indexBytes, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+
+	tagRef, err := referrersTagReference(repo, subject)
+	if err != nil {
+		return err
+	}
+	dest, err := tagRef.NewImageDestination(ctx, c.destinationCtx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := dest.Close(); err != nil {
+			retErr = noteCloseFailure(retErr, "closing referrers tag destination", err)
+		}
+	}()
+	if err := dest.PutManifest(ctx, indexBytes, nil); err != nil {
+		return fmt.Errorf("updating referrers tag %s: %w", referrersFallbackTag(subject), err)
+	}
+	return dest.Commit(ctx, image.UnparsedInstance(c.src, nil))
+}
+
+// referrersTagReference returns a docker: reference to the referrers tag index of subject in repo.
+func referrersTagReference(repo reference.Named, subject digest.Digest) (types.ImageReference, error) {
+	tagged, err := reference.WithTag(repo, referrersFallbackTag(subject))
+	if err != nil {
+		return nil, err
+	}
+	return docker.NewReference(tagged)
+}
+
+// readReferrersTagIndex returns the referrers tag index of subject in repo, or nil if it does not exist.
+func readReferrersTagIndex(ctx context.Context, sys *types.SystemContext, repo reference.Named, subject digest.Digest) (_ *imgspecv1.Index, retErr error) {
+	ref, err := referrersTagReference(repo, subject)
+	if err != nil {
+		return nil, err
+	}
+	src, err := ref.NewImageSource(ctx, sys)
+	if err != nil {
+		if isNotFoundImageError(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("reading referrers tag %s: %w", referrersFallbackTag(subject), err)
+	}
+	defer func() {
+		if err := src.Close(); err != nil {
+			retErr = noteCloseFailure(retErr, "closing referrers tag source", err)
+		}
+	}()
+	indexBytes, _, err := src.GetManifest(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	var index imgspecv1.Index
+	if err := json.Unmarshal(indexBytes, &index); err != nil {
+		return nil, fmt.Errorf("parsing referrers tag %s: %w", referrersFallbackTag(subject), err)
+	}
+	return &index, nil
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"go.podman.io/image/v5/docker/reference"
+	"go.podman.io/image/v5/pkg/docker/config"
+	"go.podman.io/image/v5/pkg/sysregistriesv2"
+	"go.podman.io/image/v5/pkg/tlsclientconfig"
+	"go.podman.io/image/v5/types"
+)
+
+// c/image does not implement the OCI distribution spec referrers API, or the catalog API, so this file contains
+// a minimal registry client for those endpoints. It uses the same credentials, certificates and TLS settings
+// as c/image would, but it does not support mirrors or location rewrites in registries.conf, which it refuses
+// instead of ignoring, nor registry-specific OAuth flows beyond the usual bearer token exchange.
+// Tokens are not cached beyond the lifetime of a registryClient.
+
+// maxReferrersIndexSize is the maximum size of a single referrers API response we are willing to read.
+const maxReferrersIndexSize = 4 * 1024 * 1024
+
+// registryClient performs authenticated GET requests against a registry, with a single token scope.
+type registryClient struct {
+	scope         string   // Token scope to request, e.g. repository:ns/repo:pull
+	host          string   // host[:port] to connect to
+	schemes       []string // URL schemes to try, in order
+	client        *http.Client
+	auth          types.DockerAuthConfig
+	bearerToken   string // Set if the user provided a bearer token directly
+	userAgent     string
+	authorization string // Value of the Authorization header to use, once known
+}
+
+// newRegistryClient returns a registryClient for repo, configured per sys.
+func newRegistryClient(sys *types.SystemContext, repo reference.Named) (*registryClient, error) {
+	var auth types.DockerAuthConfig
+	if sys != nil && sys.DockerAuthConfig != nil {
+		auth = *sys.DockerAuthConfig
+	} else {
+		a, err := config.GetCredentialsForRef(sys, repo)
+		if err != nil {
+			return nil, fmt.Errorf("getting credentials for %s: %w", reference.FamiliarName(repo), err)
+		}
+		auth = a
+	}
+	return newRegistryHostClient(sys, reference.Domain(repo), repo.Name(), auth, fmt.Sprintf("repository:%s:pull", reference.Path(repo)))
+}
+
+// newRegistryHostClient returns a registryClient for registry, configured per sys and the registries.conf entry for configName,
+// using auth and requesting tokens for scope.
+func newRegistryHostClient(sys *types.SystemContext, registry, configName string, auth types.DockerAuthConfig, scope string) (*registryClient, error) {
+	host := registry
+	if host == "docker.io" {
+		host = "registry-1.docker.io"
+	}
+
+	reg, err := sysregistriesv2.FindRegistry(sys, configName)
+	if err != nil {
+		return nil, fmt.Errorf("loading registries configuration: %w", err)
+	}
+	if reg != nil {
+		// c/image would use a different registry, or none at all; don't silently contact the configured one instead.
+		switch {
+		case reg.Blocked:
+			return nil, fmt.Errorf("registry %s is blocked in registries.conf", configName)
+		case len(reg.Mirrors) > 0:
+			return nil, fmt.Errorf("registry %s has mirrors configured in registries.conf, which are not supported", configName)
+		case reg.Location != "" && reg.Location != reg.Prefix:
+			return nil, fmt.Errorf("registry %s is redirected to %s in registries.conf, which is not supported", configName, reg.Location)
+		}
+	}
+	insecure := reg != nil && reg.Insecure
+	if sys != nil && sys.DockerInsecureSkipTLSVerify != types.OptionalBoolUndefined {
+		insecure = sys.DockerInsecureSkipTLSVerify == types.OptionalBoolTrue
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: insecure} //nolint:gosec // The user has explicitly requested this
+	var certDirs []string
+	switch {
+	case sys != nil && sys.DockerCertPath != "":
+		certDirs = []string{sys.DockerCertPath}
+	case sys != nil && sys.DockerPerHostCertDirPath != "":
+		certDirs = []string{filepath.Join(sys.DockerPerHostCertDirPath, registry)}
+	default:
+		certDirs = []string{filepath.Join("/etc/containers/certs.d", registry), filepath.Join("/etc/docker/certs.d", registry)}
+	}
+	for _, dir := range certDirs {
+		if err := tlsclientconfig.SetupCertificates(dir, tlsConfig); err != nil {
+			return nil, err
+		}
+	}
+	transport := tlsclientconfig.NewTransport()
+	transport.TLSClientConfig = tlsConfig
+
+	c := &registryClient{
+		scope:   scope,
+		host:    host,
+		schemes: []string{"https"},
+		client:  &http.Client{Transport: transport},
+		auth:    auth,
+	}
+	if insecure {
+		c.schemes = append(c.schemes, "http")
+	}
+	if sys != nil {
+		c.userAgent = sys.DockerRegistryUserAgent
+		c.bearerToken = sys.DockerBearerRegistryToken
+	}
+	return c, nil
+}
+
+// get performs a GET request for path, authenticating as necessary.
+// path may also be an absolute URL, e.g. from a Link header, but only on the registry host:
+// the request may include the user's credentials, so they must never be sent to another server.
+// The caller must close the response body.
+func (c *registryClient) get(ctx context.Context, path string) (*http.Response, error) {
+	u, err := url.Parse(path)
+	if err != nil {
+		return nil, err
+	}
+	if u.IsAbs() || u.Host != "" {
+		if u.Host != c.host || (u.Scheme != "" && !slices.Contains(c.schemes, u.Scheme)) {
+			return nil, fmt.Errorf("refusing to follow %s, which is not on registry %s", u.Redacted(), c.host)
+		}
+		u = &url.URL{Path: u.Path, RawPath: u.RawPath, RawQuery: u.RawQuery}
+	}
+	var lastErr error
+	for _, scheme := range c.schemes {
+		u.Scheme = scheme
+		u.Host = c.host
+		res, err := c.doGet(ctx, u.String())
+		if err == nil {
+			return res, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// doGet performs a GET request for u, authenticating if the registry asks for it.
+func (c *registryClient) doGet(ctx context.Context, u string) (*http.Response, error) {
+	res, err := c.request(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusUnauthorized || c.authorization != "" {
+		return res, nil
+	}
+	challenge := res.Header.Get("WWW-Authenticate")
+	res.Body.Close()
+	authorization, err := c.authorize(ctx, challenge)
+	if err != nil {
+		return nil, err
+	}
+	c.authorization = authorization
+	return c.request(ctx, u)
+}
+
+// request performs a single GET request for u.
+func (c *registryClient) request(ctx context.Context, u string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", imgspecv1.MediaTypeImageIndex)
+	if c.userAgent != "" {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+	if c.authorization != "" {
+		req.Header.Set("Authorization", c.authorization)
+	}
+	return c.client.Do(req)
+}
+
+// authChallengeParamRegexp matches a single auth-param of a WWW-Authenticate header.
+var authChallengeParamRegexp = regexp.MustCompile(`([A-Za-z0-9_-]+)="([^"]*)"`)
+
+// parseAuthChallenge parses a WWW-Authenticate header value with a single challenge.
+func parseAuthChallenge(header string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(header), " ")
+	params := map[string]string{}
+	for _, match := range authChallengeParamRegexp.FindAllStringSubmatch(rest, -1) {
+		params[strings.ToLower(match[1])] = match[2]
+	}
+	return strings.ToLower(scheme), params
+}
+
+// authorize returns an Authorization header value satisfying challenge.
+func (c *registryClient) authorize(ctx context.Context, challenge string) (string, error) {
+	scheme, params := parseAuthChallenge(challenge)
+	switch scheme {
+	case "basic":
+		if c.auth.Username == "" {
+			return "", fmt.Errorf("registry %s requires authentication", c.host)
+		}
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(c.auth.Username+":"+c.auth.Password)), nil
+	case "bearer":
+		if c.bearerToken != "" {
+			return "Bearer " + c.bearerToken, nil
+		}
+		token, err := c.fetchBearerToken(ctx, params)
+		if err != nil {
+			return "", err
+		}
+		return "Bearer " + token, nil
+	default:
+		return "", fmt.Errorf("registry %s requires unsupported authentication %q", c.host, challenge)
+	}
+}
+
+// fetchBearerToken obtains a token for c.scope from the token server described by params.
+// With an identity token, it is used as an OAuth2 refresh token, like c/image does.
+func (c *registryClient) fetchBearerToken(ctx context.Context, params map[string]string) (string, error) {
+	realm, ok := params["realm"]
+	if !ok {
+		return "", errors.New("missing realm in bearer auth challenge")
+	}
+	u, err := url.Parse(realm)
+	if err != nil {
+		return "", fmt.Errorf("invalid bearer auth realm %q: %w", realm, err)
+	}
+	q := u.Query()
+	if service, ok := params["service"]; ok {
+		q.Set("service", service)
+	}
+	q.Set("scope", c.scope)
+
+	var req *http.Request
+	if c.auth.IdentityToken != "" {
+		q.Set("grant_type", "refresh_token")
+		q.Set("refresh_token", c.auth.IdentityToken)
+		q.Set("client_id", "containers/image")
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, u.String(), strings.NewReader(q.Encode()))
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		u.RawQuery = q.Encode()
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+		if err != nil {
+			return "", err
+		}
+		if c.auth.Username != "" {
+			req.SetBasicAuth(c.auth.Username, c.auth.Password)
+		}
+	}
+	if c.userAgent != "" {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+	res, err := c.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("fetching bearer token from %s: HTTP status %s", u.Redacted(), res.Status)
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(io.LimitReader(res.Body, maxReferrersIndexSize)).Decode(&token); err != nil {
+		return "", fmt.Errorf("decoding bearer token: %w", err)
+	}
+	if token.Token != "" {
+		return token.Token, nil
+	}
+	if token.AccessToken != "" {
+		return token.AccessToken, nil
+	}
+	return "", errors.New("bearer token server returned no token")
+}
+
+// linkNextRegexp matches a Link header value with rel="next".
+var linkNextRegexp = regexp.MustCompile(`<([^>]*)>\s*;\s*rel="?next"?`)
+
+// fetchReferrersFromAPI returns descriptors of referrers of subject in repo, the repository of c, using the referrers API.
+// It returns (nil, false, nil) if the registry does not support the referrers API.
+func (c *registryClient) fetchReferrersFromAPI(ctx context.Context, repo reference.Named, subject digest.Digest) ([]imgspecv1.Descriptor, bool, error) {
+	var res []imgspecv1.Descriptor
+	path := fmt.Sprintf("/v2/%s/referrers/%s", reference.Path(repo), subject.String())
+	for page := 0; path != ""; page++ {
+		descriptors, next, supported, err := c.fetchReferrersPage(ctx, path)
+		if err != nil {
+			return nil, false, err
+		}
+		if !supported {
+			if page == 0 {
+				return nil, false, nil
+			}
+			return nil, false, fmt.Errorf("fetching referrers of %s: unexpected missing page %q", subject, path)
+		}
+		res = append(res, descriptors...)
+		path = next
+	}
+	return res, true, nil
+}
+
+// fetchReferrersPage fetches a single page of a referrers API response at path.
+// It returns the descriptors, the path of the next page (or "") and whether the registry supports the referrers API.
+func (c *registryClient) fetchReferrersPage(ctx context.Context, path string) ([]imgspecv1.Descriptor, string, bool, error) {
+	res, err := c.get(ctx, path)
+	if err != nil {
+		return nil, "", false, err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, "", false, nil
+	default:
+		return nil, "", false, fmt.Errorf("fetching referrers from %s: HTTP status %s", c.host, res.Status)
+	}
+	var index imgspecv1.Index
+	if err := json.NewDecoder(io.LimitReader(res.Body, maxReferrersIndexSize)).Decode(&index); err != nil {
+		return nil, "", false, fmt.Errorf("decoding referrers from %s: %w", c.host, err)
+	}
+	next := ""
+	if match := linkNextRegexp.FindStringSubmatch(res.Header.Get("Link")); match != nil {
+		next = match[1]
+	}
+	return index.Manifests, next, true, nil
+}
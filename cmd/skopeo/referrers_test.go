@@ -0,0 +1,440 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	imgspecs "github.com/opencontainers/image-spec/specs-go"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.podman.io/image/v5/docker/reference"
+	"go.podman.io/image/v5/manifest"
+	"go.podman.io/image/v5/types"
+)
+
+func TestCheckReferrersTransports(t *testing.T) {
+	assert.NoError(t, checkReferrersTransports("docker", "docker"))
+	assert.NoError(t, checkReferrersTransports("oci", "docker"))
+	assert.NoError(t, checkReferrersTransports("docker", "oci"))
+	assert.Error(t, checkReferrersTransports("dir", "docker"))
+	assert.Error(t, checkReferrersTransports("docker", "containers-storage"))
+}
+
+func TestReferrersFallbackTag(t *testing.T) {
+	assert.Equal(t, "sha256-0000000000000000000000000000000000000000000000000000000000000001",
+		referrersFallbackTag(digest.Digest("sha256:0000000000000000000000000000000000000000000000000000000000000001")))
+}
+
+func TestRewriteSubject(t *testing.T) {
+	subject := imgspecv1.Descriptor{
+		MediaType: imgspecv1.MediaTypeImageManifest,
+		Digest:    digest.Digest("sha256:0000000000000000000000000000000000000000000000000000000000000002"),
+		Size:      1234,
+		Platform:  &imgspecv1.Platform{OS: "linux", Architecture: "amd64"}, // Not included in the output
+	}
+	res, err := rewriteSubject([]byte(`{"schemaVersion":2,"artifactType":"application/spdx+json","unknownField":[1,2],`+
+		`"subject":{"mediaType":"application/vnd.oci.image.manifest.v1+json","digest":"sha256:0000000000000000000000000000000000000000000000000000000000000001","size":1}}`), subject)
+	require.NoError(t, err)
+	var parsed map[string]any
+	err = json.Unmarshal(res, &parsed)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{
+		"schemaVersion": float64(2),
+		"artifactType":  "application/spdx+json",
+		"unknownField":  []any{float64(1), float64(2)},
+		"subject": map[string]any{
+			"mediaType": imgspecv1.MediaTypeImageManifest,
+			"digest":    subject.Digest.String(),
+			"size":      float64(1234),
+		},
+	}, parsed)
+
+	_, err = rewriteSubject([]byte("this is invalid"), subject)
+	assert.Error(t, err)
+}
+
+func TestSourceInstances(t *testing.T) {
+	list := func(instances ...imgspecv1.Descriptor) manifest.List {
+		index := imgspecv1.Index{Versioned: imgspecs.Versioned{SchemaVersion: 2}, MediaType: imgspecv1.MediaTypeImageIndex, Manifests: instances}
+		blob, err := json.Marshal(index)
+		require.NoError(t, err)
+		res, err := manifest.ListFromBlob(blob, imgspecv1.MediaTypeImageIndex)
+		require.NoError(t, err)
+		return res
+	}
+	instance := func(name string, platform *imgspecv1.Platform) imgspecv1.Descriptor {
+		return imgspecv1.Descriptor{MediaType: imgspecv1.MediaTypeImageManifest, Digest: digest.FromString(name), Size: 1, Platform: platform}
+	}
+	amd64 := &imgspecv1.Platform{OS: "linux", Architecture: "amd64"}
+	arm64 := &imgspecv1.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}
+	s390x := &imgspecv1.Platform{OS: "linux", Architecture: "s390x"}
+
+	srcList := list(instance("amd64", amd64), instance("arm64", arm64), instance("arm64-zstd", arm64),
+		instance("s390x", s390x), instance("no-platform", nil))
+	// amd64 is unchanged, both arm64 instances were converted, s390x was removed from a sparse list,
+	// and an instance without a source was added.
+	destList := list(instance("amd64", amd64), instance("converted-arm64", arm64), instance("converted-arm64-zstd", arm64),
+		instance("converted-no-platform", nil), instance("added", &imgspecv1.Platform{OS: "linux", Architecture: "ppc64le"}))
+	res, err := sourceInstances(srcList, destList)
+	require.NoError(t, err)
+	assert.Equal(t, map[digest.Digest]digest.Digest{
+		digest.FromString("amd64"):                 digest.FromString("amd64"),
+		digest.FromString("converted-arm64"):       digest.FromString("arm64"),
+		digest.FromString("converted-arm64-zstd"):  digest.FromString("arm64-zstd"),
+		digest.FromString("converted-no-platform"): digest.FromString("no-platform"),
+	}, res)
+}
+
+func TestReferrerManifestArtifactType(t *testing.T) {
+	m := referrerManifest{}
+	assert.Equal(t, "", m.artifactType())
+	m.Config = &imgspecv1.Descriptor{MediaType: "application/vnd.example.config"}
+	assert.Equal(t, "application/vnd.example.config", m.artifactType())
+	m.ArtifactType = "application/spdx+json"
+	assert.Equal(t, "application/spdx+json", m.artifactType())
+}
+
+func TestParseAuthChallenge(t *testing.T) {
+	scheme, params := parseAuthChallenge(`Bearer realm="https://auth.example.com/token",service="registry.example.com",scope="repository:a/b:pull"`)
+	assert.Equal(t, "bearer", scheme)
+	assert.Equal(t, map[string]string{
+		"realm":   "https://auth.example.com/token",
+		"service": "registry.example.com",
+		"scope":   "repository:a/b:pull",
+	}, params)
+
+	scheme, params = parseAuthChallenge(`Basic realm="Registry"`)
+	assert.Equal(t, "basic", scheme)
+	assert.Equal(t, map[string]string{"realm": "Registry"}, params)
+}
+
+func TestNewRegistryHostClient(t *testing.T) {
+	dir := t.TempDir()
+	registriesConf := filepath.Join(dir, "registries.conf")
+	err := os.WriteFile(registriesConf, []byte(`
+[[registry]]
+location = "plain.example.com"
+insecure = true
+
+[[registry]]
+location = "blocked.example.com"
+blocked = true
+
+[[registry]]
+location = "mirrored.example.com"
+mirror = [{ location = "mirror.example.com" }]
+
+[[registry]]
+prefix = "redirected.example.com/foo"
+location = "other.example.com/bar"
+`), 0o644)
+	require.NoError(t, err)
+	sys := &types.SystemContext{SystemRegistriesConfPath: registriesConf, SystemRegistriesConfDirPath: filepath.Join(dir, "registries.conf.d")}
+
+	c, err := newRegistryHostClient(sys, "plain.example.com", "plain.example.com/repo", types.DockerAuthConfig{}, "repository:repo:pull")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"https", "http"}, c.schemes)
+	c, err = newRegistryHostClient(sys, "unconfigured.example.com", "unconfigured.example.com", types.DockerAuthConfig{}, "registry:catalog:*")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"https"}, c.schemes)
+
+	for _, c := range []struct{ registry, configName, expected string }{
+		{"blocked.example.com", "blocked.example.com/repo", "is blocked"},
+		{"mirrored.example.com", "mirrored.example.com", "has mirrors"},
+		{"redirected.example.com", "redirected.example.com/foo/repo", "is redirected to other.example.com/bar"},
+	} {
+		_, err := newRegistryHostClient(sys, c.registry, c.configName, types.DockerAuthConfig{}, "registry:catalog:*")
+		assert.ErrorContains(t, err, c.expected, c.configName)
+	}
+	// Redirection of other repositories does not matter
+	_, err = newRegistryHostClient(sys, "redirected.example.com", "redirected.example.com/other", types.DockerAuthConfig{}, "repository:other:pull")
+	assert.NoError(t, err)
+}
+
+func TestFetchReferrersFromAPI(t *testing.T) {
+	subject := digest.Digest("sha256:0000000000000000000000000000000000000000000000000000000000000001")
+	referrers := []imgspecv1.Descriptor{
+		{MediaType: imgspecv1.MediaTypeImageManifest, Digest: digest.FromString("1"), Size: 1, ArtifactType: "application/spdx+json"},
+		{MediaType: imgspecv1.MediaTypeImageManifest, Digest: digest.FromString("2"), Size: 2, ArtifactType: "application/vnd.example"},
+	}
+	const token = "the-token"
+
+	// Another server, which must never receive the credentials
+	otherServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("Unexpected request to another host: %s, Authorization %q", r.URL, r.Header.Get("Authorization"))
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer otherServer.Close()
+
+	mux := http.NewServeMux()
+	server := httptest.NewTLSServer(mux)
+	defer server.Close()
+	tokenRequests := 0
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		tokenRequests++
+		user, password, ok := r.BasicAuth()
+		if !ok || user != "user" || password != "pass" || !slices.Contains([]string{"repository:with-api:pull", "repository:cross-host:pull"}, r.URL.Query().Get("scope")) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprintf(w, `{"token":%q}`, token)
+	})
+	mux.HandleFunc("/v2/with-api/referrers/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+token {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test"`, server.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		assert.Equal(t, "/v2/with-api/referrers/"+subject.String(), r.URL.Path)
+		page := referrers[:1]
+		if r.URL.Query().Get("page") == "2" {
+			page = referrers[1:]
+		} else {
+			// An absolute URL on the same registry is allowed
+			w.Header().Set("Link", fmt.Sprintf(`<%s/v2/with-api/referrers/%s?page=2>; rel="next"`, server.URL, subject.String()))
+		}
+		err := json.NewEncoder(w).Encode(imgspecv1.Index{
+			Versioned: imgspecs.Versioned{SchemaVersion: 2},
+			MediaType: imgspecv1.MediaTypeImageIndex,
+			Manifests: page,
+		})
+		assert.NoError(t, err)
+	})
+	mux.HandleFunc("/v2/cross-host/referrers/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+token {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test"`, server.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Link", fmt.Sprintf(`<%s/v2/cross-host/referrers/%s?page=2>; rel="next"`, otherServer.URL, subject.String()))
+		err := json.NewEncoder(w).Encode(imgspecv1.Index{
+			Versioned: imgspecs.Versioned{SchemaVersion: 2},
+			MediaType: imgspecv1.MediaTypeImageIndex,
+			Manifests: referrers[:1],
+		})
+		assert.NoError(t, err)
+	})
+
+	host := strings.TrimPrefix(server.URL, "https://")
+	sys := &types.SystemContext{
+		DockerInsecureSkipTLSVerify: types.OptionalBoolTrue,
+		DockerAuthConfig:            &types.DockerAuthConfig{Username: "user", Password: "pass"},
+	}
+
+	repo, err := reference.ParseNormalizedNamed(host + "/with-api")
+	require.NoError(t, err)
+	c, err := newRegistryClient(sys, repo)
+	require.NoError(t, err)
+	for range 2 {
+		res, supported, err := c.fetchReferrersFromAPI(t.Context(), repo, subject)
+		require.NoError(t, err)
+		assert.True(t, supported)
+		assert.Equal(t, referrers, res)
+	}
+	assert.Equal(t, 1, tokenRequests) // The token is reused
+
+	// A Link to another host is not followed
+	repo, err = reference.ParseNormalizedNamed(host + "/cross-host")
+	require.NoError(t, err)
+	c, err = newRegistryClient(sys, repo)
+	require.NoError(t, err)
+	_, _, err = c.fetchReferrersFromAPI(t.Context(), repo, subject)
+	assert.ErrorContains(t, err, "refusing to follow")
+
+	// The mux returns 404 for unknown paths, i.e. the referrers API is not supported.
+	repo, err = reference.ParseNormalizedNamed(host + "/without-api")
+	require.NoError(t, err)
+	c, err = newRegistryClient(sys, repo)
+	require.NoError(t, err)
+	res, supported, err := c.fetchReferrersFromAPI(t.Context(), repo, subject)
+	require.NoError(t, err)
+	assert.False(t, supported)
+	assert.Nil(t, res)
+
+	// Invalid credentials
+	sys.DockerAuthConfig = &types.DockerAuthConfig{Username: "user", Password: "wrong"}
+	repo, err = reference.ParseNormalizedNamed(host + "/with-api")
+	require.NoError(t, err)
+	c, err = newRegistryClient(sys, repo)
+	require.NoError(t, err)
+	_, _, err = c.fetchReferrersFromAPI(t.Context(), repo, subject)
+	assert.Error(t, err)
+}
+
+// addOCIReferrer adds an artifact with artifactType, referring to subject, to the OCI layout in dir,
+// and returns a descriptor of the artifact manifest.
+func addOCIReferrer(t *testing.T, dir string, subject imgspecv1.Descriptor, artifactType string) imgspecv1.Descriptor {
+	putBlob := func(data []byte) imgspecv1.Descriptor {
+		d := digest.FromBytes(data)
+		err := os.WriteFile(filepath.Join(dir, "blobs", d.Algorithm().String(), d.Encoded()), data, 0o644)
+		require.NoError(t, err)
+		return imgspecv1.Descriptor{Digest: d, Size: int64(len(data))}
+	}
+	config := putBlob([]byte("{}"))
+	config.MediaType = imgspecv1.MediaTypeEmptyJSON
+	layer := putBlob([]byte("contents of " + artifactType))
+	layer.MediaType = "text/plain"
+	manifestBytes, err := json.Marshal(imgspecv1.Manifest{
+		Versioned:    imgspecs.Versioned{SchemaVersion: 2},
+		MediaType:    imgspecv1.MediaTypeImageManifest,
+		ArtifactType: artifactType,
+		Config:       config,
+		Layers:       []imgspecv1.Descriptor{layer},
+		Subject:      &imgspecv1.Descriptor{MediaType: subject.MediaType, Digest: subject.Digest, Size: subject.Size},
+	})
+	require.NoError(t, err)
+	desc := putBlob(manifestBytes)
+	desc.MediaType = imgspecv1.MediaTypeImageManifest
+
+	index := readOCIIndex(t, dir)
+	index.Manifests = append(index.Manifests, desc)
+	indexBytes, err := json.Marshal(index)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(dir, "index.json"), indexBytes, 0o644)
+	require.NoError(t, err)
+	return desc
+}
+
+// readOCIIndex returns the index.json of the OCI layout in dir.
+func readOCIIndex(t *testing.T, dir string) imgspecv1.Index {
+	indexBytes, err := os.ReadFile(filepath.Join(dir, "index.json"))
+	require.NoError(t, err)
+	var index imgspecv1.Index
+	err = json.Unmarshal(indexBytes, &index)
+	require.NoError(t, err)
+	return index
+}
+
+// ociIndexDigests returns digests of all entries of the index.json of the OCI layout in dir.
+func ociIndexDigests(t *testing.T, dir string) []digest.Digest {
+	var res []digest.Digest
+	for _, desc := range readOCIIndex(t, dir).Manifests {
+		res = append(res, desc.Digest)
+	}
+	return res
+}
+
+func TestCopyReferrers(t *testing.T) {
+	const fixture = "../../integration/fixtures/uncompressed-image-s2"
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	_, err := runSkopeo("--insecure-policy", "copy", "-q", "dir:"+fixture, "oci:"+src+":latest")
+	require.NoError(t, err)
+	index := readOCIIndex(t, src)
+	require.Len(t, index.Manifests, 1)
+	image := index.Manifests[0]
+
+	sbom := addOCIReferrer(t, src, image, "application/spdx+json")
+	sbomSignature := addOCIReferrer(t, src, sbom, "application/vnd.dev.sigstore.bundle+json")
+	other := addOCIReferrer(t, src, image, "application/vnd.example")
+	// An artifact which does not refer to the copied image
+	unrelated := addOCIReferrer(t, src, imgspecv1.Descriptor{
+		MediaType: imgspecv1.MediaTypeImageManifest,
+		Digest:    digest.FromString("unrelated"),
+		Size:      9,
+	}, "application/spdx+json")
+
+	// Without --referrers, only the image is copied
+	dest := filepath.Join(dir, "no-referrers")
+	_, err = runSkopeo("--insecure-policy", "copy", "-q", "oci:"+src+":latest", "oci:"+dest+":latest")
+	require.NoError(t, err)
+	assert.Equal(t, []digest.Digest{image.Digest}, ociIndexDigests(t, dest))
+
+	dest = filepath.Join(dir, "all")
+	_, err = runSkopeo("--insecure-policy", "copy", "-q", "--referrers", "oci:"+src+":latest", "oci:"+dest+":latest")
+	require.NoError(t, err)
+	digests := ociIndexDigests(t, dest)
+	assert.ElementsMatch(t, []digest.Digest{image.Digest, sbom.Digest, sbomSignature.Digest, other.Digest}, digests)
+	assert.NotContains(t, digests, unrelated.Digest)
+	_, err = os.Stat(filepath.Join(dest, "blobs", "sha256", sbomSignature.Digest.Encoded()))
+	assert.NoError(t, err)
+
+	dest = filepath.Join(dir, "filtered")
+	_, err = runSkopeo("--insecure-policy", "copy", "-q", "--referrers", "--referrers-artifact-type", "application/spdx+json",
+		"oci:"+src+":latest", "oci:"+dest+":latest")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []digest.Digest{image.Digest, sbom.Digest}, ociIndexDigests(t, dest))
+
+	// Unsupported transports
+	_, err = runSkopeo("--insecure-policy", "copy", "--referrers", "oci:"+src+":latest", "dir:"+filepath.Join(dir, "dir"))
+	assert.ErrorContains(t, err, "--referrers is only supported")
+	// --referrers-artifact-type requires --referrers
+	_, err = runSkopeo("--insecure-policy", "copy", "--referrers-artifact-type", "application/spdx+json",
+		"oci:"+src+":latest", "oci:"+filepath.Join(dir, "invalid")+":latest")
+	assert.Error(t, err)
+}
+
+func TestCopyReferrersConvertedList(t *testing.T) {
+	const fixture = "../../integration/fixtures/uncompressed-image-s2"
+	src := filepath.Join(t.TempDir(), "src")
+	_, err := runSkopeo("--insecure-policy", "copy", "-q", "dir:"+fixture, "oci:"+src+":unused")
+	require.NoError(t, err)
+	// A schema2 list of the schema2 fixture, which is converted to OCI when copied to an OCI layout
+	putBlob := func(data []byte) digest.Digest {
+		d := digest.FromBytes(data)
+		err := os.WriteFile(filepath.Join(src, "blobs", d.Algorithm().String(), d.Encoded()), data, 0o644)
+		require.NoError(t, err)
+		return d
+	}
+	entries, err := os.ReadDir(fixture)
+	require.NoError(t, err)
+	for _, e := range entries {
+		data, err := os.ReadFile(filepath.Join(fixture, e.Name()))
+		require.NoError(t, err)
+		putBlob(data)
+	}
+	instanceBytes, err := os.ReadFile(filepath.Join(fixture, "manifest.json"))
+	require.NoError(t, err)
+	instance := imgspecv1.Descriptor{MediaType: manifest.DockerV2Schema2MediaType, Digest: digest.FromBytes(instanceBytes), Size: int64(len(instanceBytes))}
+	listBytes := fmt.Appendf(nil, `{"schemaVersion":2,"mediaType":%q,"manifests":[{"mediaType":%q,"digest":%q,"size":%d,"platform":{"os":"linux","architecture":"amd64"}}]}`,
+		manifest.DockerV2ListMediaType, instance.MediaType, instance.Digest, instance.Size)
+	list := imgspecv1.Descriptor{MediaType: manifest.DockerV2ListMediaType, Digest: putBlob(listBytes), Size: int64(len(listBytes)),
+		Annotations: map[string]string{imgspecv1.AnnotationRefName: "list"}}
+	index := readOCIIndex(t, src)
+	index.Manifests = append(index.Manifests, list)
+	indexBytes, err := json.Marshal(index)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(src, "index.json"), indexBytes, 0o644)
+	require.NoError(t, err)
+	addOCIReferrer(t, src, list, "application/vnd.example.list")
+	addOCIReferrer(t, src, instance, "application/vnd.example.instance")
+
+	dest := filepath.Join(t.TempDir(), "dest")
+	_, err = runSkopeo("--insecure-policy", "copy", "-q", "--all", "--format", "oci", "--referrers", "oci:"+src+":list", "oci:"+dest+":list")
+	require.NoError(t, err)
+	var destList imgspecv1.Index
+	var destListDigest digest.Digest
+	subjects := map[string]digest.Digest{} // Keyed by artifact type
+	for _, desc := range readOCIIndex(t, dest).Manifests {
+		data, err := os.ReadFile(filepath.Join(dest, "blobs", desc.Digest.Algorithm().String(), desc.Digest.Encoded()))
+		require.NoError(t, err)
+		if desc.Annotations[imgspecv1.AnnotationRefName] == "list" {
+			err = json.Unmarshal(data, &destList)
+			require.NoError(t, err)
+			assert.Equal(t, imgspecv1.MediaTypeImageIndex, desc.MediaType)
+			destListDigest = desc.Digest
+			continue
+		}
+		var m referrerManifest
+		err = json.Unmarshal(data, &m)
+		require.NoError(t, err)
+		if m.Subject != nil {
+			subjects[m.artifactType()] = m.Subject.Digest
+		}
+	}
+	require.Len(t, destList.Manifests, 1)
+	require.NotEqual(t, instance.Digest, destList.Manifests[0].Digest)
+	assert.Equal(t, map[string]digest.Digest{
+		"application/vnd.example.list":     destListDigest,
+		"application/vnd.example.instance": destList.Manifests[0].Digest,
+	}, subjects)
+}
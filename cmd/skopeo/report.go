@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"go.podman.io/image/v5/docker"
+	"go.podman.io/image/v5/docker/reference"
+	"go.podman.io/image/v5/manifest"
+	"go.podman.io/image/v5/transports"
+	"go.podman.io/image/v5/types"
+)
+
+// blobReport describes a single blob processed by a copy.
+type blobReport struct {
+	Digest    digest.Digest `json:"digest"`
+	MediaType string        `json:"mediaType,omitempty"`
+	Size      int64         `json:"size,omitempty"` // Omitted if not known
+}
+
+// blobStats collects information about blobs processed by a copy, from copy progress events.
+type blobStats struct {
+	Copied      []blobReport `json:"copied"`      // Blobs transferred to the destination
+	Reused      []blobReport `json:"reused"`      // Blobs which already existed at the destination
+	BytesCopied uint64       `json:"bytesCopied"` // Total number of bytes of Copied read from the source
+}
+
+// blobProgress updates s with p.
+// It is intended to be used as a listener for jsonProgressReporter.startImage.
+func (s *blobStats) blobProgress(p types.ProgressProperties) {
+	blob := blobReport{
+		Digest:    p.Artifact.Digest,
+		MediaType: p.Artifact.MediaType,
+	}
+	if p.Artifact.Size > 0 {
+		blob.Size = p.Artifact.Size
+	}
+	switch p.Event {
+	case types.ProgressEventDone:
+		s.Copied = append(s.Copied, blob)
+		s.BytesCopied += p.Offset
+	case types.ProgressEventSkipped:
+		s.Reused = append(s.Reused, blob)
+	}
+}
+
+// instanceReport describes a single instance of a copied multi-architecture image.
+type instanceReport struct {
+	Digest   digest.Digest       `json:"digest"`
+	Platform *imgspecv1.Platform `json:"platform,omitempty"`
+}
+
+// copyReport is the contents of the file written by (skopeo copy --report).
+type copyReport struct {
+	Source               string           `json:"source"`
+	ResolvedSource       string           `json:"resolvedSource,omitempty"`       // Source pinned by SourceManifestDigest; only set for docker: sources
+	SourceManifestDigest digest.Digest    `json:"sourceManifestDigest,omitempty"` // docker: sources are copied by this digest; read before copying other sources
+	Destination          string           `json:"destination"`
+	ResolvedDestination  string           `json:"resolvedDestination,omitempty"` // Only set by some transports, e.g. containers-storage:
+	ManifestDigest       digest.Digest    `json:"manifestDigest,omitempty"`
+	Instances            []instanceReport `json:"instances,omitempty"` // Only set if the copied image is a multi-architecture image
+	Blobs                blobStats        `json:"blobs"`               // Blobs of the last copy attempt
+	StartTime            time.Time        `json:"startTime"`
+	EndTime              time.Time        `json:"endTime"`
+	DurationSeconds      float64          `json:"durationSeconds"`
+	Error                string           `json:"error,omitempty"`
+}
+
+// newCopyReport returns a copyReport for a copy from src to dest, which starts now.
+func newCopyReport(src, dest types.ImageReference) *copyReport {
+	return &copyReport{
+		Source:      transports.ImageName(src),
+		Destination: transports.ImageName(dest),
+		StartTime:   time.Now().UTC(),
+	}
+}
+
+// resolveSource records the manifest digest of src, read before copying it, and returns the reference to copy the image from.
+// docker: sources are pinned by that digest, so that the copied image is the reported one even if the tag is changed meanwhile.
+func (r *copyReport) resolveSource(ctx context.Context, sys *types.SystemContext, src types.ImageReference) (types.ImageReference, error) {
+	named := src.DockerReference()
+	if digested, ok := named.(reference.Digested); ok {
+		r.SourceManifestDigest = digested.Digest()
+	} else {
+		d, err := manifestDigest(ctx, sys, src)
+		if err != nil {
+			return nil, err
+		}
+		r.SourceManifestDigest = d
+	}
+	if src.Transport() != docker.Transport {
+		return src, nil
+	}
+	pinned, err := reference.WithDigest(reference.TrimNamed(named), r.SourceManifestDigest)
+	if err != nil {
+		return nil, err
+	}
+	pinnedRef, err := docker.NewReference(pinned)
+	if err != nil {
+		return nil, err
+	}
+	r.ResolvedSource = transports.ImageName(pinnedRef)
+	return pinnedRef, nil
+}
+
+// finish records the outcome of the copy into r.
+// manifestBytes is the manifest returned by copy.Image, resolvedDest the value returned via copy.Options.ReportResolvedReference
+// (or nil), and copyErr the error of the copy, if any.
+func (r *copyReport) finish(manifestBytes []byte, resolvedDest types.ImageReference, copyErr error) error {
+	r.EndTime = time.Now().UTC()
+	r.DurationSeconds = r.EndTime.Sub(r.StartTime).Seconds()
+	// Write empty lists instead of null values, to simplify consumers.
+	if r.Blobs.Copied == nil {
+		r.Blobs.Copied = []blobReport{}
+	}
+	if r.Blobs.Reused == nil {
+		r.Blobs.Reused = []blobReport{}
+	}
+	if copyErr != nil {
+		r.Error = copyErr.Error()
+		return nil
+	}
+	if resolvedDest != nil {
+		r.ResolvedDestination = transports.ImageName(resolvedDest)
+	}
+	manifestDigest, err := manifest.Digest(manifestBytes)
+	if err != nil {
+		return err
+	}
+	r.ManifestDigest = manifestDigest
+	mimeType := manifest.GuessMIMEType(manifestBytes)
+	if manifest.MIMETypeIsMultiImage(mimeType) {
+		list, err := manifest.ListFromBlob(manifestBytes, mimeType)
+		if err != nil {
+			return fmt.Errorf("parsing manifest list: %w", err)
+		}
+		for _, d := range list.Instances() {
+			instance, err := list.Instance(d)
+			if err != nil {
+				return err
+			}
+			r.Instances = append(r.Instances, instanceReport{
+				Digest:   d,
+				Platform: instance.ReadOnly.Platform,
+			})
+		}
+	}
+	return nil
+}
+
+// write writes r as JSON to path.
+func (r *copyReport) write(path string) error {
+	data, err := json.MarshalIndent(r, "", "    ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("Failed to write report to file %q: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.podman.io/image/v5/directory"
+	"go.podman.io/image/v5/docker"
+	"go.podman.io/image/v5/transports"
+	"go.podman.io/image/v5/types"
+)
+
+func TestBlobStatsBlobProgress(t *testing.T) {
+	blob1 := types.BlobInfo{Digest: digest.FromString("1"), Size: 10, MediaType: imgspecv1.MediaTypeImageLayerGzip}
+	blob2 := types.BlobInfo{Digest: digest.FromString("2"), Size: -1}
+
+	stats := blobStats{}
+	for _, p := range []types.ProgressProperties{
+		{Event: types.ProgressEventNewArtifact, Artifact: blob1},
+		{Event: types.ProgressEventRead, Artifact: blob1, Offset: 5, OffsetUpdate: 5},
+		{Event: types.ProgressEventDone, Artifact: blob1, Offset: 10},
+		{Event: types.ProgressEventSkipped, Artifact: blob2},
+	} {
+		stats.blobProgress(p)
+	}
+	assert.Equal(t, blobStats{
+		Copied:      []blobReport{{Digest: blob1.Digest, MediaType: blob1.MediaType, Size: 10}},
+		Reused:      []blobReport{{Digest: blob2.Digest}},
+		BytesCopied: 10,
+	}, stats)
+}
+
+func TestCopyReport(t *testing.T) {
+	src, err := directory.Transport.ParseReference("/src")
+	require.NoError(t, err)
+	dest, err := directory.Transport.ParseReference("/dest")
+	require.NoError(t, err)
+
+	// A single image
+	manifestBytes, err := os.ReadFile("fixtures/image.manifest.json")
+	require.NoError(t, err)
+	report := newCopyReport(src, dest)
+	err = report.finish(manifestBytes, dest, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "dir:/src", report.Source)
+	assert.Equal(t, "dir:/dest", report.Destination)
+	assert.Equal(t, "dir:/dest", report.ResolvedDestination)
+	assert.Equal(t, digest.FromBytes(manifestBytes), report.ManifestDigest)
+	assert.Empty(t, report.Instances)
+	assert.False(t, report.EndTime.Before(report.StartTime))
+
+	// A multi-architecture image
+	instanceDigest := digest.FromString("instance")
+	index, err := json.Marshal(imgspecv1.Index{
+		MediaType: imgspecv1.MediaTypeImageIndex,
+		Manifests: []imgspecv1.Descriptor{{
+			MediaType: imgspecv1.MediaTypeImageManifest,
+			Digest:    instanceDigest,
+			Size:      1,
+			Platform:  &imgspecv1.Platform{OS: "linux", Architecture: "arm64"},
+		}},
+	})
+	require.NoError(t, err)
+	index = append([]byte(`{"schemaVersion":2,`), index[1:]...)
+	report = newCopyReport(src, dest)
+	err = report.finish(index, nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "", report.ResolvedDestination)
+	assert.Equal(t, []instanceReport{{Digest: instanceDigest, Platform: &imgspecv1.Platform{OS: "linux", Architecture: "arm64"}}}, report.Instances)
+
+	// A failed copy
+	report = newCopyReport(src, dest)
+	err = report.finish(nil, nil, errors.New("copy failed"))
+	require.NoError(t, err)
+	assert.Equal(t, "copy failed", report.Error)
+	assert.Equal(t, digest.Digest(""), report.ManifestDigest)
+
+	path := filepath.Join(t.TempDir(), "report.json")
+	err = report.write(path)
+	require.NoError(t, err)
+	contents, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var decoded copyReport
+	err = json.Unmarshal(contents, &decoded)
+	require.NoError(t, err)
+	assert.Equal(t, "copy failed", decoded.Error)
+}
+
+func TestCopyReportResolveSource(t *testing.T) {
+	dest, err := directory.Transport.ParseReference("/dest")
+	require.NoError(t, err)
+
+	// The digest is read from the source
+	src, err := directory.Transport.ParseReference(prepareSignedDirImage(t))
+	require.NoError(t, err)
+	report := newCopyReport(src, dest)
+	copySrc, err := report.resolveSource(t.Context(), nil, src)
+	require.NoError(t, err)
+	assert.Equal(t, src, copySrc)
+	assert.Equal(t, fixturesTestImageManifestDigest, report.SourceManifestDigest)
+	assert.Equal(t, "", report.ResolvedSource)
+
+	// docker: sources are pinned by digest; this one is already referenced by digest, so it is not contacted
+	const d = "sha256:0000000000000000000000000000000000000000000000000000000000000001"
+	src, err = docker.Transport.ParseReference("//registry.example.com/busybox@" + d)
+	require.NoError(t, err)
+	report = newCopyReport(src, dest)
+	copySrc, err = report.resolveSource(t.Context(), nil, src)
+	require.NoError(t, err)
+	assert.Equal(t, "docker://registry.example.com/busybox@"+d, transports.ImageName(copySrc))
+	assert.Equal(t, digest.Digest(d), report.SourceManifestDigest)
+	assert.Equal(t, "docker://registry.example.com/busybox@"+d, report.ResolvedSource)
+
+	_, err = report.resolveSource(t.Context(), nil, dest) // Does not exist
+	assert.Error(t, err)
+}
+
+func TestCopyReportMovedTag(t *testing.T) {
+	const fixture = "../../integration/fixtures/uncompressed-image-s2"
+	manifest1, err := os.ReadFile(filepath.Join(fixture, "manifest.json"))
+	require.NoError(t, err)
+	manifest2 := append(slices.Clone(manifest1), '\n') // The same image with a different manifest digest
+	digest1, digest2 := digest.FromBytes(manifest1), digest.FromBytes(manifest2)
+	manifests := map[digest.Digest][]byte{digest1: manifest1, digest2: manifest2}
+
+	// The tag is moved right after it is first resolved, i.e. between reading the source digest for the report and copying the image.
+	var tagLookups atomic.Int32
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v2/":
+		case strings.HasPrefix(r.URL.Path, "/v2/app/manifests/"):
+			ref := strings.TrimPrefix(r.URL.Path, "/v2/app/manifests/")
+			d := digest.Digest(ref)
+			if ref == "latest" {
+				d = digest1
+				if tagLookups.Add(1) > 1 {
+					d = digest2
+				}
+			}
+			data, ok := manifests[d]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", "application/vnd.docker.distribution.manifest.v2+json")
+			w.Header().Set("Docker-Content-Digest", d.String())
+			w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+			if r.Method != http.MethodHead {
+				_, err := w.Write(data)
+				assert.NoError(t, err)
+			}
+		case strings.HasPrefix(r.URL.Path, "/v2/app/blobs/sha256:"):
+			http.ServeFile(w, r, filepath.Join(fixture, strings.TrimPrefix(r.URL.Path, "/v2/app/blobs/sha256:")))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	registryName := strings.TrimPrefix(server.URL, "https://")
+
+	dir := t.TempDir()
+	reportFile := filepath.Join(dir, "report.json")
+	dest := filepath.Join(dir, "dest")
+	_, err = runSkopeo("--insecure-policy", "copy", "-q", "--src-tls-verify=false", "--report", reportFile,
+		"docker://"+registryName+"/app:latest", "dir:"+dest)
+	require.NoError(t, err)
+	contents, err := os.ReadFile(reportFile)
+	require.NoError(t, err)
+	var report copyReport
+	err = json.Unmarshal(contents, &report)
+	require.NoError(t, err)
+	assert.Equal(t, digest1, report.SourceManifestDigest)
+	assert.Equal(t, "docker://"+registryName+"/app@"+digest1.String(), report.ResolvedSource)
+	assert.Equal(t, digest1, report.ManifestDigest)
+	copied, err := os.ReadFile(filepath.Join(dest, "manifest.json"))
+	require.NoError(t, err)
+	assert.Equal(t, manifest1, copied)
+}
@@ -12,6 +12,9 @@ import (
 	"regexp"
 	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/Masterminds/semver/v3"
 	"github.com/opencontainers/go-digest"
@@ -21,13 +24,23 @@ import (
 	"go.podman.io/image/v5/copy"
 	"go.podman.io/image/v5/directory"
 	"go.podman.io/image/v5/docker"
+	dockerarchive "go.podman.io/image/v5/docker/archive"
 	"go.podman.io/image/v5/docker/reference"
 	"go.podman.io/image/v5/manifest"
+	ocilayout "go.podman.io/image/v5/oci/layout"
+	"go.podman.io/image/v5/signature"
+	"go.podman.io/image/v5/storage"
 	"go.podman.io/image/v5/transports"
+	"go.podman.io/image/v5/transports/alltransports"
 	"go.podman.io/image/v5/types"
+	"golang.org/x/sync/semaphore"
 	"gopkg.in/yaml.v3"
 )
 
+// defaultSyncBlobCopies is the limit of concurrent blob copies with --parallel-images, if --image-parallel-copies is not set;
+// it matches the containers/image default for a single image.
+const defaultSyncBlobCopies = 6
+
 // syncOptions contains information retrieved from the skopeo sync command line.
 type syncOptions struct {
 	global              *globalOptions // Global (not command dependent) skopeo options
@@ -36,21 +49,64 @@ type syncOptions struct {
 	destImage           *imageDestOptions // Destination image options
 	retryOpts           *retry.Options
 	copy                *sharedCopyOptions
-	source              string // Source repository name
-	destination         string // Destination registry name
-	digestFile          string // Write digest to this file
-	scoped              bool   // When true, namespace copied images at destination using the source repository name
-	all                 bool   // Copy all of the images if an image in the source is a list
-	dryRun              bool   // Don't actually copy anything, just output what it would have done
-	keepGoing           bool   // Whether or not to abort the sync if there are any errors during syncing the images
-	appendSuffix        string // Suffix to append to destination image tag
+	source              string        // Source repository name
+	destination         string        // Destination registry name
+	digestFile          string        // Write digest to this file
+	scoped              bool          // When true, namespace copied images at destination using the source repository name
+	all                 bool          // Copy all of the images if an image in the source is a list
+	dryRun              bool          // Don't actually copy anything, just output what it would have done
+	keepGoing           bool          // Whether or not to abort the sync if there are any errors during syncing the images
+	appendSuffix        string        // Suffix to append to destination image tag
+	parallelImages      uint          // Maximum number of images to copy simultaneously
+	imageParallelCopies uint          // Maximum number of parallel requests when copying images, in total for all images
+	prune               bool          // Delete destination tags which do not exist at the source
+	pruneMaxDeletions   uint          // Refuse to prune more than this number of tags (0 = unlimited)
+	stateFile           string        // Record synced images in this file, and skip unchanged images
+	validateConfig      string        // Only validate this YAML source configuration file
+	planOut             string        // With dryRun, write the plan of the sync to this file
+	planIn              string        // Execute the plan in this file
+	reportFile          string        // Write a report about the synced images to this file
+	reportFormat        string        // Format of reportFile
+	lockfileOut         string        // Copy the images by digest, and write their digests to this file
+	lockfile            string        // Copy exactly the images by digest in this file
+	onImageSynced       string        // Shell command to run after each copied image, and at the end of the sync
+	webhook             string        // URL to post an event to after each copied image, and at the end of the sync
+	ignoreHookFailures  bool          // Only log failures of onImageSynced and webhook
+	watch               bool          // Keep running, and sync again every watchInterval
+	watchInterval       time.Duration // Interval between the starts of syncs with watch
 }
 
 // repoDescriptor contains information of a single repository used as a sync source.
 type repoDescriptor struct {
-	DirBasePath string                 // base path when source is 'dir'
+	DirBasePath string                 // base path when source is 'dir' or 'oci'
 	ImageRefs   []types.ImageReference // List of tagged image found for the repository
 	Context     *types.SystemContext   // SystemContext for the sync command
+	Platforms   *syncPlatforms         // Platforms to copy from image lists, or nil to follow --all
+	Destination *destinationMapping    // Mapping of destination names, or nil to use the default names
+	Registry    string                 // The registry of a YAML source, or ""
+	Repository  string                 // The repository of a YAML source, relative to Registry, or ""
+	Planned     []*syncPlanImage       // When applying a plan, the planned copies of ImageRefs
+	Digests     []digest.Digest        // With --lockfile or --lockfile-out, the locked manifest digests of ImageRefs, which are copied by digest
+}
+
+// sourceReference returns the reference used to copy srcRepo.ImageRefs[i]; with locked digests, the image by digest.
+func (srcRepo repoDescriptor) sourceReference(i int) (types.ImageReference, error) {
+	ref := srcRepo.ImageRefs[i]
+	if srcRepo.Digests == nil {
+		return ref, nil
+	}
+	pinned, err := reference.WithDigest(reference.TrimNamed(ref.DockerReference()), srcRepo.Digests[i])
+	if err != nil {
+		return nil, err
+	}
+	return docker.NewReference(pinned)
+}
+
+// syncPlatforms is a set of platforms of images to copy from image lists, as returned by parseInstanceSelection.
+type syncPlatforms struct {
+	names    []string                      // The platforms as specified, OS/ARCH[/VARIANT]
+	filters  []copy.InstancePlatformFilter // Platforms without a variant, handled by copy.Image
+	variants []platformSelector            // Platforms with a variant, resolved to instance digests before copying
 }
 
 // tlsVerifyConfig is an implementation of the Unmarshaler interface, used to
@@ -62,12 +118,27 @@ type tlsVerifyConfig struct {
 // registrySyncConfig contains information about a single registry, read from
 // the source YAML file
 type registrySyncConfig struct {
-	Images           map[string][]string    // Images map images name to slices with the images' references (tags, digests)
-	ImagesByTagRegex map[string]string      `yaml:"images-by-tag-regex"` // Images map images name to regular expression with the images' tags
-	ImagesBySemver   map[string]string      `yaml:"images-by-semver"`    // ImagesBySemver maps a repository to a semver constraint (e.g. '>=3.14') to match images' tags to
-	Credentials      types.DockerAuthConfig // Username and password used to authenticate with the registry
-	TLSVerify        tlsVerifyConfig        `yaml:"tls-verify"` // TLS verification mode (enabled by default)
-	CertDir          string                 `yaml:"cert-dir"`   // Path to the TLS certificates of the registry
+	Images           map[string][]string // Images map images name to slices with the images' references (tags, digests)
+	ImagesByTagRegex map[string]string   `yaml:"images-by-tag-regex"` // Images map images name to regular expression with the images' tags
+	ImagesBySemver   map[string]string   `yaml:"images-by-semver"`    // ImagesBySemver maps a repository to a semver constraint (e.g. '>=3.14') to match images' tags to
+	Credentials      credentialsConfig   // Username and password used to authenticate with the registry, or a reference to them
+	TLSVerify        tlsVerifyConfig     `yaml:"tls-verify"` // TLS verification mode (enabled by default)
+	CertDir          string              `yaml:"cert-dir"`   // Path to the TLS certificates of the registry
+	Platforms        []string            // Platforms (OS/ARCH[/VARIANT]) of images to copy from image lists, for all repositories of the registry
+	ImagePlatforms   map[string][]string `yaml:"image-platforms"`             // ImagePlatforms maps repositories to platforms overriding Platforms
+	ExcludeTagRegex  map[string]string   `yaml:"images-by-tag-regex-exclude"` // ExcludeTagRegex maps a repository to a regular expression of listed tags not to copy
+	MaxTags          int                 `yaml:"max-tags"`                    // Copy at most this number of the newest images matching each images-by-semver constraint
+	NewerThan        string              `yaml:"newer-than"`                  // Only copy listed tags of images created after this date (or duration before now)
+	Destination      string              // Template of destination names, relative to the sync destination
+	// ImageDestinations maps repositories to templates overriding Destination
+	ImageDestinations   map[string]string          `yaml:"image-destinations"`
+	DestinationRewrites []destinationRewriteConfig `yaml:"destination-rewrites"` // Regular expression rewrites applied to destination names
+	// RepositoriesByRegex maps regular expressions of repository names, matched against the registry's catalog,
+	// to regular expressions with the images' tags
+	RepositoriesByRegex map[string]string `yaml:"repositories-by-regex"`
+	AuthFile            string            `yaml:"authfile"`       // Path of the authentication file used for the registry
+	RegistryToken       string            `yaml:"registry-token"` // Bearer token used to authenticate with the registry, or env:VAR or file:PATH
+	IdentityToken       string            `yaml:"identity-token"` // Identity (OAuth2 refresh) token used to authenticate with the registry, or env:VAR or file:PATH
 }
 
 // sourceConfig contains all registries information read from the source YAML file
@@ -95,8 +166,8 @@ func syncCmd(global *globalOptions) *cobra.Command {
 		Short: "Synchronize one or more images from one location to another",
 		Long: `Copy all the images from a SOURCE to a DESTINATION.
 
-Allowed SOURCE transports (specified with --src): docker, dir, yaml.
-Allowed DESTINATION transports (specified with --dest): docker, dir.
+Allowed SOURCE transports (specified with --src): docker, dir, oci, docker-archive, oci-archive, containers-storage, yaml.
+Allowed DESTINATION transports (specified with --dest): docker, dir, oci, docker-archive, oci-archive, containers-storage.
 
 See skopeo-sync(1) for details.
 `,
@@ -119,6 +190,23 @@ See skopeo-sync(1) for details.
 	flags.BoolVarP(&opts.all, "all", "a", false, "Copy all images if SOURCE-IMAGE is a list")
 	flags.BoolVar(&opts.dryRun, "dry-run", false, "Run without actually copying data")
 	flags.BoolVarP(&opts.keepGoing, "keep-going", "", false, "Do not abort the sync if any image copy fails")
+	flags.UintVar(&opts.parallelImages, "parallel-images", 1, "Maximum number of images to copy simultaneously")
+	flags.BoolVar(&opts.prune, "prune", false, "Delete tags in the synced DESTINATION repositories which do not exist in SOURCE")
+	flags.UintVar(&opts.pruneMaxDeletions, "prune-max-deletions", 100, "Refuse to prune more than this number of tags; 0 means no limit")
+	flags.StringVar(&opts.stateFile, "state-file", "", "Record the synced images in `PATH`, and skip images which are unchanged since they were recorded")
+	flags.StringVar(&opts.planOut, "plan-out", "", "With --dry-run, write the planned copies and prunes to `PATH`")
+	flags.StringVar(&opts.planIn, "plan-in", "", "Execute the plan at `PATH`, written by --plan-out")
+	flags.StringVar(&opts.lockfileOut, "lockfile-out", "", "Write the manifest digests of the images of a YAML source to `PATH`, and copy exactly those images")
+	flags.StringVar(&opts.lockfile, "lockfile", "", "Copy exactly the images recorded in `PATH` by --lockfile-out, by digest")
+	flags.StringVar(&opts.onImageSynced, "on-image-synced", "", "Run `COMMAND` using the shell after each copied image, and at the end of the sync")
+	flags.StringVar(&opts.webhook, "webhook", "", "POST a JSON event to `URL` after each copied image, and at the end of the sync")
+	flags.BoolVar(&opts.ignoreHookFailures, "ignore-hook-failures", false, "Log failures of --on-image-synced and --webhook instead of failing the sync")
+	flags.StringVar(&opts.reportFile, "report", "", "Write a report about the synced images to `FILE`")
+	flags.StringVar(&opts.reportFormat, "report-format", syncReportFormatJSON, "Format of the --report file: json or junit")
+	flags.BoolVar(&opts.watch, "watch", false, "Keep running, and sync again every --interval, until terminated")
+	flags.DurationVar(&opts.watchInterval, "interval", 15*time.Minute, "With --watch, the interval between the starts of syncs")
+	flags.StringVar(&opts.validateConfig, "validate-config", "", "Only validate the YAML source configuration at `PATH`, and exit")
+	flags.UintVar(&opts.imageParallelCopies, "image-parallel-copies", 0, "Maximum number of image layers to be copied (pulled/pushed) simultaneously, in total for all images. Not setting this field will fall back to containers/image defaults.")
 	return cmd
 }
 
@@ -136,19 +224,21 @@ func (tls *tlsVerifyConfig) UnmarshalYAML(value *yaml.Node) error {
 	return nil
 }
 
-// newSourceConfig unmarshals the provided YAML file path to the sourceConfig type.
-// It returns a new unmarshaled sourceConfig object and any error encountered.
+// newSourceConfig unmarshals the provided YAML file path to the sourceConfig type, and validates it.
+// It returns a new unmarshaled sourceConfig object and any error encountered; errors in the
+// contents of the file include their line and column.
 func newSourceConfig(yamlFile string) (sourceConfig, error) {
-	var cfg sourceConfig
 	source, err := os.ReadFile(yamlFile)
 	if err != nil {
-		return cfg, err
-	}
-	err = yaml.Unmarshal(source, &cfg)
-	if err != nil {
-		return cfg, fmt.Errorf("Failed to unmarshal %q: %w", yamlFile, err)
+		return nil, err
 	}
-	return cfg, nil
+	return parseSourceConfig(yamlFile, source)
+}
+
+// parseSourceConfig is newSourceConfig for the already read contents of yamlFile.
+func parseSourceConfig(yamlFile string, source []byte) (sourceConfig, error) {
+	p := sourceConfigParser{file: yamlFile}
+	return p.parse(source)
 }
 
 // parseRepositoryReference parses input into a reference.Named, and verifies that it names a repository, not an image.
@@ -173,16 +263,14 @@ func destinationReference(destination string, transport string) (types.ImageRefe
 	case docker.Transport.Name():
 		destination = fmt.Sprintf("//%s", destination)
 		imageTransport = docker.Transport
+	case storage.Transport.Name():
+		imageTransport = storage.Transport
 	case directory.Transport.Name():
-		_, err := os.Stat(destination)
-		if err == nil {
-			return nil, fmt.Errorf("Refusing to overwrite destination directory %q", destination)
-		}
-		if !os.IsNotExist(err) {
-			return nil, fmt.Errorf("Destination directory could not be used: %w", err)
+		if err := checkDirectoryDestination(destination); err != nil {
+			return nil, err
 		}
 		// the directory holding the image must be created here
-		if err = os.MkdirAll(destination, 0o755); err != nil {
+		if err := os.MkdirAll(destination, 0o755); err != nil {
 			return nil, fmt.Errorf("Error creating directory for image %s: %w", destination, err)
 		}
 		imageTransport = directory.Transport
@@ -199,6 +287,82 @@ func destinationReference(destination string, transport string) (types.ImageRefe
 	return destRef, nil
 }
 
+// checkDirectoryDestination returns an error if destination, the directory of a dir: image, can't be used.
+func checkDirectoryDestination(destination string) error {
+	_, err := os.Stat(destination)
+	if err == nil {
+		return fmt.Errorf("Refusing to overwrite destination directory %q", destination)
+	}
+	if !os.IsNotExist(err) {
+		return fmt.Errorf("Destination directory could not be used: %w", err)
+	}
+	return nil
+}
+
+// destinationName returns the transports.ImageName of the destination of an image with destSuffix in destination,
+// without checking or creating anything at the destination.
+func (opts *syncOptions) destinationName(destination, destSuffix string) (string, error) {
+	switch opts.destination {
+	case directory.Transport.Name():
+		// directory.NewReference requires the parent directory to exist, which is only created by destinationReference.
+		return directory.Transport.Name() + ":" + path.Join(destination, destSuffix) + opts.appendSuffix, nil
+	case ocilayout.Transport.Name():
+		refName := strings.TrimPrefix(destSuffix+opts.appendSuffix, "/")
+		if _, err := os.Stat(destination); errors.Is(err, fs.ErrNotExist) {
+			// ocilayout.NewReference requires the parent directory to exist, which is only created by ociDestinationReference.
+			return ocilayout.Transport.Name() + ":" + destination + ":" + refName, nil
+		}
+		ref, err := ociLayoutReference(destination, refName)
+		if err != nil {
+			return "", err
+		}
+		return transports.ImageName(ref), nil
+	default:
+		ref, err := destinationReference(path.Join(destination, destSuffix)+opts.appendSuffix, opts.destination)
+		if err != nil {
+			return "", err
+		}
+		return transports.ImageName(ref), nil
+	}
+}
+
+// ociDestinationReference creates an image reference for refName in the OCI layout at layoutDir,
+// which is shared by all images copied by the sync.
+// It returns a image reference to be used as destination of an image copy and
+// any error encountered.
+func ociDestinationReference(layoutDir string, refName string) (types.ImageReference, error) {
+	if err := os.MkdirAll(layoutDir, 0o755); err != nil {
+		return nil, fmt.Errorf("Error creating OCI layout directory %s: %w", layoutDir, err)
+	}
+	return ociLayoutReference(layoutDir, refName)
+}
+
+// ociLayoutReference returns a reference to refName in the OCI layout at layoutDir, without creating the layout.
+// The parent directory of layoutDir must exist.
+func ociLayoutReference(layoutDir string, refName string) (types.ImageReference, error) {
+	refName = strings.TrimPrefix(refName, "/")
+	logrus.Debugf("Destination for transport %q: %s:%s", ocilayout.Transport.Name(), layoutDir, refName)
+
+	destRef, err := ocilayout.NewReference(layoutDir, refName)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot obtain a valid image reference for transport %q and reference %q: %w", ocilayout.Transport.Name(), layoutDir+":"+refName, err)
+	}
+	return destRef, nil
+}
+
+// sameDirectory returns true if a and b refer to the same existing directory.
+func sameDirectory(a, b string) bool {
+	aInfo, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	bInfo, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(aInfo, bInfo)
+}
+
 // getImageTags lists all tags in a repository.
 // It returns a string slice of tags and any error encountered.
 func getImageTags(ctx context.Context, sysCtx *types.SystemContext, repoRef reference.Named) ([]string, error) {
@@ -278,27 +442,57 @@ func imagesToCopyFromDir(dirPath string) ([]types.ImageReference, error) {
 	return sourceReferences, nil
 }
 
+// imagesToCopyFromOCILayout builds a list of image references from the images
+// named by an org.opencontainers.image.ref.name annotation in the index.json of the OCI layout at layoutDir.
+// Unnamed manifests (e.g. referrers) are skipped.
+// It returns an image reference slice with as many elements as the named images found
+// and any error encountered.
+func imagesToCopyFromOCILayout(layoutDir string) ([]types.ImageReference, error) {
+	entries, err := ocilayout.List(layoutDir)
+	if err != nil {
+		return nil, fmt.Errorf("Error listing images in OCI layout %q: %w", layoutDir, err)
+	}
+	var sourceReferences []types.ImageReference
+	for _, entry := range entries {
+		if ociReferenceName(entry.Reference) == "" {
+			logrus.WithFields(logrus.Fields{
+				"layout": layoutDir,
+				"digest": entry.ManifestDescriptor.Digest.String(),
+			}).Debug("Skipping unnamed manifest")
+			continue
+		}
+		sourceReferences = append(sourceReferences, entry.Reference)
+	}
+	return sourceReferences, nil
+}
+
+// ociReferenceName returns the org.opencontainers.image.ref.name of ref, an OCI layout reference, or "" if it has none.
+func ociReferenceName(ref types.ImageReference) string {
+	_, refName, _ := strings.Cut(ref.StringWithinTransport(), ":")
+	if strings.HasPrefix(refName, "@") { // An unnamed manifest, referenced by its index
+		return ""
+	}
+	return refName
+}
+
 // imagesToCopyFromRegistry builds a list of repository descriptors from the images
 // in a registry configuration.
 // It returns a repository descriptors slice with as many elements as the images
 // found and any error encountered. Each element of the slice is a list of
 // image references, to be used as sync source.
 func imagesToCopyFromRegistry(registryName string, cfg registrySyncConfig, sourceCtx types.SystemContext) ([]repoDescriptor, error) {
-	serverCtx := &sourceCtx
-	// override ctx with per-registryName options
-	serverCtx.DockerCertPath = cfg.CertDir
-	serverCtx.DockerDaemonCertPath = cfg.CertDir
-	// Only override TLS verification if explicitly specified in YAML; otherwise, keep CLI/global settings.
-	if cfg.TLSVerify.skip != types.OptionalBoolUndefined {
-		serverCtx.DockerDaemonInsecureSkipTLSVerify = (cfg.TLSVerify.skip == types.OptionalBoolTrue)
-		serverCtx.DockerInsecureSkipTLSVerify = cfg.TLSVerify.skip
-	}
-	if cfg.Credentials != (types.DockerAuthConfig{}) {
-		serverCtx.DockerAuthConfig = &cfg.Credentials
+	serverCtx, newRepoDescriptor, err := cfg.repoDescriptorFunc(registryName, sourceCtx)
+	if err != nil {
+		return nil, err
 	}
 	var repoDescList []repoDescriptor
 
-	if len(cfg.Images) == 0 && len(cfg.ImagesByTagRegex) == 0 && len(cfg.ImagesBySemver) == 0 {
+	tagFilters, err := cfg.tagFilters()
+	if err != nil {
+		return nil, fmt.Errorf("registry %s: %w", registryName, err)
+	}
+
+	if len(cfg.Images) == 0 && len(cfg.ImagesByTagRegex) == 0 && len(cfg.ImagesBySemver) == 0 && len(cfg.RepositoriesByRegex) == 0 {
 		logrus.WithFields(logrus.Fields{
 			"registry": registryName,
 		}).Warn("No images specified for registry")
@@ -358,16 +552,15 @@ func imagesToCopyFromRegistry(registryName string, cfg registrySyncConfig, sourc
 				logrus.Error(err)
 				continue
 			}
+			sourceReferences = tagFilters.excluded(repoLogger, imageName, sourceReferences)
+			sourceReferences = tagFilters.newer(serverCtx, repoLogger, sourceReferences)
 		}
 
 		if len(sourceReferences) == 0 {
 			repoLogger.Warnf("No refs to sync found")
 			continue
 		}
-		repoDescList = append(repoDescList, repoDescriptor{
-			ImageRefs: sourceReferences,
-			Context:   serverCtx,
-		})
+		repoDescList = append(repoDescList, newRepoDescriptor(imageName, sourceReferences))
 	}
 
 	// include repository descriptors for cfg.ImagesByTagRegex
@@ -376,36 +569,258 @@ func imagesToCopyFromRegistry(registryName string, cfg registrySyncConfig, sourc
 		if err != nil {
 			logrus.Error(err)
 		} else {
-			additionalRepoDescList := filterSourceReferences(serverCtx, registryName, filterCollection)
+			additionalRepoDescList := filterSourceReferences(serverCtx, registryName, filterCollection, tagFilters, newRepoDescriptor)
 			repoDescList = append(repoDescList, additionalRepoDescList...)
 		}
 	}
 
 	// include repository descriptors for cfg.ImagesBySemver
 	{
-		filterCollection, err := semverFilterCollection(cfg.ImagesBySemver)
+		filterCollection, err := semverFilterCollection(cfg.ImagesBySemver, cfg.MaxTags)
 		if err != nil {
 			logrus.Error(err)
 		} else {
-			additionalRepoDescList := filterSourceReferences(serverCtx, registryName, filterCollection)
+			additionalRepoDescList := filterSourceReferences(serverCtx, registryName, filterCollection, tagFilters, newRepoDescriptor)
 			repoDescList = append(repoDescList, additionalRepoDescList...)
 		}
 	}
 
+	// include repository descriptors for cfg.RepositoriesByRegex
+	if len(cfg.RepositoriesByRegex) != 0 {
+		filterCollection, err := cfg.catalogFilterCollection(context.Background(), serverCtx, registryName)
+		if err != nil {
+			return nil, err
+		}
+		additionalRepoDescList := filterSourceReferences(serverCtx, registryName, filterCollection, tagFilters, newRepoDescriptor)
+		repoDescList = append(repoDescList, additionalRepoDescList...)
+	}
+
 	return repoDescList, nil
 }
 
+// repoDescriptorFunc returns the SystemContext used to access registryName, configured by cfg,
+// and a function which returns a repoDescriptor for refs in repoName, a repository of the registry.
+func (cfg *registrySyncConfig) repoDescriptorFunc(registryName string, sourceCtx types.SystemContext) (
+	*types.SystemContext, func(repoName string, refs []types.ImageReference) repoDescriptor, error,
+) {
+	serverCtx, err := cfg.systemContext(registryName, sourceCtx)
+	if err != nil {
+		return nil, nil, err
+	}
+	repoPlatforms, err := cfg.repoPlatforms(registryName)
+	if err != nil {
+		return nil, nil, err
+	}
+	repoDestinations, err := cfg.repoDestinations(registryName)
+	if err != nil {
+		return nil, nil, err
+	}
+	return serverCtx, func(repoName string, refs []types.ImageReference) repoDescriptor {
+		return repoDescriptor{
+			ImageRefs:   refs,
+			Context:     serverCtx,
+			Platforms:   repoPlatforms(repoName),
+			Destination: repoDestinations(repoName),
+			Registry:    registryName,
+			Repository:  repoName,
+		}
+	}, nil
+}
+
+// systemContext returns a copy of sourceCtx, with the options of cfg, the configuration of registryName, applied.
+func (cfg *registrySyncConfig) systemContext(registryName string, sourceCtx types.SystemContext) (*types.SystemContext, error) {
+	serverCtx := &sourceCtx
+	// override ctx with per-registryName options
+	serverCtx.DockerCertPath = cfg.CertDir
+	serverCtx.DockerDaemonCertPath = cfg.CertDir
+	// Only override TLS verification if explicitly specified in YAML; otherwise, keep CLI/global settings.
+	if cfg.TLSVerify.skip != types.OptionalBoolUndefined {
+		serverCtx.DockerDaemonInsecureSkipTLSVerify = (cfg.TLSVerify.skip == types.OptionalBoolTrue)
+		serverCtx.DockerInsecureSkipTLSVerify = cfg.TLSVerify.skip
+	}
+	if err := cfg.applyAuthentication(registryName, serverCtx); err != nil {
+		return nil, err
+	}
+	return serverCtx, nil
+}
+
+// parseSyncPlatforms parses platforms, a list of OS/ARCH[/VARIANT] values, or returns nil if it is empty.
+func parseSyncPlatforms(platforms []string) (*syncPlatforms, error) {
+	if len(platforms) == 0 {
+		return nil, nil
+	}
+	_, filters, variants, err := parseInstanceSelection(nil, platforms)
+	if err != nil {
+		return nil, err
+	}
+	return &syncPlatforms{names: platforms, filters: filters, variants: variants}, nil
+}
+
+// repoPlatforms validates the platforms and image-platforms keys of cfg, and returns a function
+// which returns the platforms to copy for a repository, or nil if none were configured.
+func (cfg *registrySyncConfig) repoPlatforms(registryName string) (func(repoName string) *syncPlatforms, error) {
+	registryPlatforms, err := parseSyncPlatforms(cfg.Platforms)
+	if err != nil {
+		return nil, fmt.Errorf("registry %s: platforms: %w", registryName, err)
+	}
+	imagePlatforms := map[string]*syncPlatforms{}
+	for repoName, platforms := range cfg.ImagePlatforms {
+		// Repositories matching repositories-by-regex are only known after querying the catalog.
+		if !cfg.configuresRepository(repoName) && len(cfg.RepositoriesByRegex) == 0 {
+			logrus.WithFields(logrus.Fields{
+				"repo":     repoName,
+				"registry": registryName,
+			}).Warn("image-platforms specified for a repository which is not synced")
+		}
+		if len(platforms) == 0 {
+			return nil, fmt.Errorf("registry %s: image-platforms: no platforms specified for %s", registryName, repoName)
+		}
+		p, err := parseSyncPlatforms(platforms)
+		if err != nil {
+			return nil, fmt.Errorf("registry %s: image-platforms: %s: %w", registryName, repoName, err)
+		}
+		imagePlatforms[repoName] = p
+	}
+	return func(repoName string) *syncPlatforms {
+		if p, ok := imagePlatforms[repoName]; ok {
+			return p
+		}
+		return registryPlatforms
+	}, nil
+}
+
+// tagFilters are the filters of a registrySyncConfig which apply to all tags listed from the registry.
+type tagFilters struct {
+	exclude   map[string]*regexp.Regexp // Maps repository names to patterns of tags to exclude
+	newerThan time.Time                 // Only images created after this time are copied; zero to copy all images
+}
+
+// tagFilters parses the filters of cfg which apply to all tags listed from the registry.
+func (cfg *registrySyncConfig) tagFilters() (*tagFilters, error) {
+	res := tagFilters{exclude: map[string]*regexp.Regexp{}}
+	for repoName, tagRegex := range cfg.ExcludeTagRegex {
+		pattern, err := regexp.Compile(tagRegex)
+		if err != nil {
+			return nil, fmt.Errorf("images-by-tag-regex-exclude: %s: %w", repoName, err)
+		}
+		res.exclude[repoName] = pattern
+	}
+	if cfg.MaxTags < 0 {
+		return nil, fmt.Errorf("max-tags: invalid value %d", cfg.MaxTags)
+	}
+	if cfg.NewerThan != "" {
+		t, err := parseNewerThan(cfg.NewerThan, time.Now())
+		if err != nil {
+			return nil, fmt.Errorf("newer-than: %w", err)
+		}
+		res.newerThan = t
+	}
+	return &res, nil
+}
+
+// parseNewerThan parses a newer-than value, either a date (2006-01-02), an RFC 3339 timestamp,
+// or a duration before now (e.g. 720h).
+func parseNewerThan(value string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid value %q, expected a date (YYYY-MM-DD), an RFC 3339 timestamp, or a duration", value)
+}
+
+// referenceTag returns the tag of sourceReference, or false if it does not have one.
+func referenceTag(logger *logrus.Entry, sourceReference types.ImageReference) (string, bool) {
+	tagged, isTagged := sourceReference.DockerReference().(reference.Tagged)
+	if !isTagged {
+		logger.Errorf("Internal error, reference %s does not have a tag, skipping", sourceReference.DockerReference())
+		return "", false
+	}
+	return tagged.Tag(), true
+}
+
+// excluded returns refs, tags listed from repoName, without the tags matching the images-by-tag-regex-exclude pattern.
+func (f *tagFilters) excluded(logger *logrus.Entry, repoName string, refs []types.ImageReference) []types.ImageReference {
+	pattern, ok := f.exclude[repoName]
+	if !ok {
+		return refs
+	}
+	var res []types.ImageReference
+	for _, ref := range refs {
+		tag, ok := referenceTag(logger, ref)
+		if !ok {
+			continue
+		}
+		if pattern.MatchString(tag) {
+			logger.Debugf("Tag %q is excluded", tag)
+			continue
+		}
+		res = append(res, ref)
+	}
+	return res
+}
+
+// newer returns refs without the images created before the newer-than time.
+// This requires reading the config of every image.
+func (f *tagFilters) newer(sys *types.SystemContext, logger *logrus.Entry, refs []types.ImageReference) []types.ImageReference {
+	if f.newerThan.IsZero() {
+		return refs
+	}
+	var res []types.ImageReference
+	for _, ref := range refs {
+		created, err := imageCreated(context.Background(), sys, ref)
+		switch {
+		case err != nil:
+			// Don't skip the image, which would also allow --prune to delete it at the destination.
+			logger.WithError(err).Errorf("Error reading creation time of %s, copying it", ref.DockerReference())
+		case created == nil:
+			logger.Warnf("Image %s has no creation time, copying it", ref.DockerReference())
+		case created.Before(f.newerThan):
+			logger.Debugf("Image %s was created at %s, skipping", ref.DockerReference(), created.Format(time.RFC3339))
+			continue
+		}
+		res = append(res, ref)
+	}
+	return res
+}
+
+// imageCreated returns the creation time of the image at ref, or nil if it is not known.
+// For image lists, this is the creation time of the image for the platform of sys.
+func imageCreated(ctx context.Context, sys *types.SystemContext, ref types.ImageReference) (_ *time.Time, retErr error) {
+	img, err := ref.NewImage(ctx, sys)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := img.Close(); err != nil {
+			retErr = noteCloseFailure(retErr, "closing image", err)
+		}
+	}()
+	info, err := img.Inspect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return info.Created, nil
+}
+
 // filterFunc is a function used to limit the initial set of image references
 // using tags, patterns, semver, etc.
-type filterFunc func(*logrus.Entry, types.ImageReference) bool
+type filterFunc func(*logrus.Entry, []types.ImageReference) []types.ImageReference
 
 // filterCollection is a map of repository names to filter functions.
 type filterCollection map[string]filterFunc
 
 // filterSourceReferences lists tags for images specified in the collection and
-// filters them using assigned filter functions.
-// It returns a list of repoDescriptors.
-func filterSourceReferences(sys *types.SystemContext, registryName string, collection filterCollection) []repoDescriptor {
+// filters them using assigned filter functions, after removing tags excluded by tagFilters,
+// and before removing old images using tagFilters.
+// It returns a list of repoDescriptors created by newRepoDescriptor.
+func filterSourceReferences(sys *types.SystemContext, registryName string, collection filterCollection, tagFilters *tagFilters,
+	newRepoDescriptor func(repoName string, refs []types.ImageReference) repoDescriptor,
+) []repoDescriptor {
 	var repoDescList []repoDescriptor
 	for repoName, filter := range collection {
 		logger := logrus.WithFields(logrus.Fields{
@@ -432,22 +847,16 @@ func filterSourceReferences(sys *types.SystemContext, registryName string, colle
 			continue
 		}
 
-		var filteredSourceReferences []types.ImageReference
-		for _, ref := range sourceReferences {
-			if filter(logger, ref) {
-				filteredSourceReferences = append(filteredSourceReferences, ref)
-			}
-		}
+		filteredSourceReferences := tagFilters.excluded(logger, repoName, sourceReferences)
+		filteredSourceReferences = filter(logger, filteredSourceReferences)
+		filteredSourceReferences = tagFilters.newer(sys, logger, filteredSourceReferences)
 
 		if len(filteredSourceReferences) == 0 {
 			logger.Warnf("No refs to sync found")
 			continue
 		}
 
-		repoDescList = append(repoDescList, repoDescriptor{
-			ImageRefs: filteredSourceReferences,
-			Context:   sys,
-		})
+		repoDescList = append(repoDescList, newRepoDescriptor(repoName, filteredSourceReferences))
 	}
 	return repoDescList
 }
@@ -464,13 +873,14 @@ func tagRegexFilterCollection(collection map[string]string) (filterCollection, e
 			return nil, err
 		}
 
-		f := func(logger *logrus.Entry, sourceReference types.ImageReference) bool {
-			tagged, isTagged := sourceReference.DockerReference().(reference.Tagged)
-			if !isTagged {
-				logger.Errorf("Internal error, reference %s does not have a tag, skipping", sourceReference.DockerReference())
-				return false
+		f := func(logger *logrus.Entry, sourceReferences []types.ImageReference) []types.ImageReference {
+			var res []types.ImageReference
+			for _, sourceReference := range sourceReferences {
+				if tag, ok := referenceTag(logger, sourceReference); ok && pattern.MatchString(tag) {
+					res = append(res, sourceReference)
+				}
 			}
-			return pattern.MatchString(tagged.Tag())
+			return res
 		}
 		filters[repoName] = f
 	}
@@ -481,7 +891,8 @@ func tagRegexFilterCollection(collection map[string]string) (filterCollection, e
 // semverFilterCollection converts a map of (repository name, array of semver constraints) pairs
 // into a filterCollection, which is a map of (repository name, filter function)
 // pairs.
-func semverFilterCollection(collection map[string]string) (filterCollection, error) {
+// If maxTags is not 0, the filter functions return only the maxTags images with the highest versions.
+func semverFilterCollection(collection map[string]string, maxTags int) (filterCollection, error) {
 	filters := filterCollection{}
 
 	for repoName, constraintString := range collection {
@@ -490,18 +901,44 @@ func semverFilterCollection(collection map[string]string) (filterCollection, err
 			return nil, err
 		}
 
-		f := func(logger *logrus.Entry, sourceReference types.ImageReference) bool {
-			tagged, isTagged := sourceReference.DockerReference().(reference.Tagged)
-			if !isTagged {
-				logger.Errorf("Internal error, reference %s does not have a tag, skipping", sourceReference.DockerReference())
-				return false
+		f := func(logger *logrus.Entry, sourceReferences []types.ImageReference) []types.ImageReference {
+			type match struct {
+				index   int // In sourceReferences
+				version *semver.Version
 			}
-			tagVersion, err := semver.NewVersion(tagged.Tag())
-			if err != nil {
-				logger.Tracef("Tag %q cannot be parsed as semver, skipping", tagged.Tag())
-				return false
+			var matches []match
+			for i, sourceReference := range sourceReferences {
+				tag, ok := referenceTag(logger, sourceReference)
+				if !ok {
+					continue
+				}
+				tagVersion, err := semver.NewVersion(tag)
+				if err != nil {
+					logger.Tracef("Tag %q cannot be parsed as semver, skipping", tag)
+					continue
+				}
+				if constraint.Check(tagVersion) {
+					matches = append(matches, match{index: i, version: tagVersion})
+				}
+			}
+			if maxTags != 0 && len(matches) > maxTags {
+				slices.SortStableFunc(matches, func(a, b match) int {
+					return b.version.Compare(a.version)
+				})
+				for _, m := range matches[maxTags:] {
+					logger.Debugf("Tag %q is not one of the newest %d matches, skipping", sourceReferences[m.index].DockerReference(), maxTags)
+				}
+				matches = matches[:maxTags]
+				// Preserve the order of sourceReferences
+				slices.SortFunc(matches, func(a, b match) int {
+					return a.index - b.index
+				})
+			}
+			res := make([]types.ImageReference, 0, len(matches))
+			for _, m := range matches {
+				res = append(res, sourceReferences[m.index])
 			}
-			return constraint.Check(tagVersion)
+			return res
 		}
 
 		filters[repoName] = f
@@ -568,60 +1005,260 @@ func imagesToCopy(source string, transport string, sourceCtx *types.SystemContex
 		}
 		descriptors = append(descriptors, desc)
 
+	case ocilayout.Transport.Name():
+		desc := repoDescriptor{
+			Context: sourceCtx,
+		}
+
+		if _, err := os.Stat(source); err != nil {
+			return descriptors, fmt.Errorf("Invalid source OCI layout specified: %w", err)
+		}
+		desc.DirBasePath = source
+		var err error
+		desc.ImageRefs, err = imagesToCopyFromOCILayout(source)
+		if err != nil {
+			return descriptors, err
+		}
+		if len(desc.ImageRefs) == 0 {
+			return descriptors, fmt.Errorf("No images to sync found in %q", source)
+		}
+		descriptors = append(descriptors, desc)
+
+	case storage.Transport.Name():
+		desc := repoDescriptor{
+			Context: sourceCtx,
+		}
+		var err error
+		desc.ImageRefs, err = imagesToCopyFromStorage(source)
+		if err != nil {
+			return descriptors, err
+		}
+		if len(desc.ImageRefs) == 0 {
+			return descriptors, fmt.Errorf("No images to sync found in %q", source)
+		}
+		descriptors = append(descriptors, desc)
+
 	case "yaml":
 		cfg, err := newSourceConfig(source)
 		if err != nil {
 			return descriptors, err
 		}
-		for registryName, registryConfig := range cfg {
-			descs, err := imagesToCopyFromRegistry(registryName, registryConfig, *sourceCtx)
-			if err != nil {
-				return descriptors, fmt.Errorf("Failed to retrieve list of images from registry %q: %w", registryName, err)
-			}
-			descriptors = append(descriptors, descs...)
-		}
+		return imagesToCopyFromSourceConfig(cfg, sourceCtx)
 	}
 
 	return descriptors, nil
 }
 
+// imagesToCopyFromSourceConfig retrieves all the images to copy from the registries in cfg.
+func imagesToCopyFromSourceConfig(cfg sourceConfig, sourceCtx *types.SystemContext) ([]repoDescriptor, error) {
+	var descriptors []repoDescriptor
+	for registryName, registryConfig := range cfg {
+		descs, err := imagesToCopyFromRegistry(registryName, registryConfig, *sourceCtx)
+		if err != nil {
+			return descriptors, fmt.Errorf("Failed to retrieve list of images from registry %q: %w", registryName, err)
+		}
+		descriptors = append(descriptors, descs...)
+	}
+	return descriptors, nil
+}
+
+// destinationSuffix returns the name of ref, an image of srcRepo, relative to the destination.
+func (opts *syncOptions) destinationSuffix(srcRepo repoDescriptor, ref types.ImageReference) (string, error) {
+	var destSuffix string
+	switch ref.Transport().Name() {
+	case docker.Transport.Name(), dockerarchive.Transport.Name(), storage.Transport.Name():
+		// docker -> dir or docker -> docker
+		destSuffix = ref.DockerReference().String()
+	case directory.Transport.Name():
+		// dir -> docker or dir -> oci (we don't allow `dir` -> `dir` sync operations)
+		destSuffix = strings.TrimPrefix(ref.StringWithinTransport(), srcRepo.DirBasePath)
+		if destSuffix == "" {
+			// if source is a full path to an image, have destPath scoped to repo:tag
+			destSuffix = path.Base(srcRepo.DirBasePath)
+		}
+	case ocilayout.Transport.Name():
+		// oci -> docker, oci -> dir or oci -> oci
+		destSuffix = ociReferenceName(ref)
+		if !strings.ContainsAny(destSuffix, "/:@") {
+			// The name is just a tag, use the layout directory name as the repository
+			destSuffix = path.Base(filepath.ToSlash(filepath.Clean(srcRepo.DirBasePath))) + ":" + destSuffix
+		}
+	}
+
+	if !opts.scoped {
+		destSuffix = path.Base(destSuffix)
+	}
+	if srcRepo.Destination != nil {
+		return srcRepo.Destination.destinationName(ref, destSuffix)
+	}
+	return destSuffix, nil
+}
+
 func (opts *syncOptions) run(args []string, stdout io.Writer) (retErr error) {
+	if opts.validateConfig != "" {
+		if len(args) != 0 {
+			return errorShouldDisplayUsage{errors.New("No arguments expected with --validate-config")}
+		}
+		if _, err := newSourceConfig(opts.validateConfig); err != nil {
+			var configErr *sourceConfigError
+			if !errors.As(err, &configErr) {
+				return err
+			}
+			for _, err := range configErr.errs {
+				fmt.Fprintln(stdout, err)
+			}
+			return fmt.Errorf("%s is not valid: %d errors found", opts.validateConfig, len(configErr.errs))
+		}
+		fmt.Fprintf(stdout, "%s is valid\n", opts.validateConfig)
+		return nil
+	}
+	var plan *syncPlan
+	if opts.planIn != "" {
+		if len(args) != 0 {
+			return errorShouldDisplayUsage{errors.New("No arguments expected with --plan-in")}
+		}
+		if opts.source != "" || opts.destination != "" || opts.scoped || opts.appendSuffix != "" {
+			return errors.New("--src, --dest, --scoped and --append-suffix cannot be used with --plan-in, they are read from the plan")
+		}
+		if opts.dryRun || opts.planOut != "" || opts.prune {
+			return errors.New("--dry-run, --plan-out and --prune cannot be used with --plan-in")
+		}
+		var err error
+		plan, err = loadSyncPlan(opts.planIn)
+		if err != nil {
+			return err
+		}
+		opts.source, opts.destination = plan.Source.Transport, plan.Target.Transport
+		opts.scoped, opts.appendSuffix = plan.Target.Scoped, plan.Target.AppendSuffix
+		args = []string{plan.Source.Source, plan.Target.Destination}
+	}
 	if len(args) != 2 {
 		return errorShouldDisplayUsage{errors.New("Exactly two arguments expected")}
 	}
+	if opts.planOut != "" && !opts.dryRun {
+		return errors.New("--plan-out can only be used with --dry-run")
+	}
+	if opts.reportFormat != syncReportFormatJSON && opts.reportFormat != syncReportFormatJUnit {
+		return fmt.Errorf("unknown --report-format %q, expected %q or %q", opts.reportFormat, syncReportFormatJSON, syncReportFormatJUnit)
+	}
+	if opts.reportFile != "" && opts.dryRun {
+		return errors.New("--report cannot be used with --dry-run")
+	}
+	if opts.lockfile != "" || opts.lockfileOut != "" {
+		if opts.lockfile != "" && opts.lockfileOut != "" {
+			return errors.New("--lockfile and --lockfile-out cannot be used together")
+		}
+		if plan != nil {
+			return errors.New("--lockfile and --lockfile-out cannot be used with --plan-in")
+		}
+		if opts.source != "yaml" {
+			return errors.New("--lockfile and --lockfile-out can only be used with --src yaml")
+		}
+	}
+	if opts.watch {
+		if opts.dryRun || opts.planOut != "" || plan != nil {
+			return errors.New("--dry-run, --plan-out and --plan-in cannot be used with --watch")
+		}
+		if opts.watchInterval <= 0 {
+			return errors.New("--interval must be positive")
+		}
+	}
+	if opts.parallelImages == 0 {
+		return errors.New("--parallel-images must be at least 1")
+	}
+	if err := opts.validateSyncHooks(); err != nil {
+		return err
+	}
 	opts.deprecatedTLSVerify.warnIfUsed([]string{"--src-tls-verify", "--dest-tls-verify"})
 
-	policyContext, err := opts.global.getPolicyContext()
+	policy, err := opts.global.getPolicy()
 	if err != nil {
 		return fmt.Errorf("Error loading trust policy: %w", err)
 	}
-	defer func() {
-		if err := policyContext.Destroy(); err != nil {
-			retErr = noteCloseFailure(retErr, "tearing down policy context", err)
-		}
-	}()
 
 	// validate source and destination options
 	if len(opts.source) == 0 {
 		return errors.New("A source transport must be specified")
 	}
-	if !slices.Contains([]string{docker.Transport.Name(), directory.Transport.Name(), "yaml"}, opts.source) {
+	if !slices.Contains([]string{docker.Transport.Name(), directory.Transport.Name(), ocilayout.Transport.Name(), storage.Transport.Name(), "yaml"}, opts.source) &&
+		!isSyncArchiveTransport(opts.source) {
 		return fmt.Errorf("%q is not a valid source transport", opts.source)
 	}
 
 	if len(opts.destination) == 0 {
 		return errors.New("A destination transport must be specified")
 	}
-	if !slices.Contains([]string{docker.Transport.Name(), directory.Transport.Name()}, opts.destination) {
+	if !slices.Contains([]string{docker.Transport.Name(), directory.Transport.Name(), ocilayout.Transport.Name(), storage.Transport.Name()}, opts.destination) &&
+		!isSyncArchiveTransport(opts.destination) {
 		return fmt.Errorf("%q is not a valid destination transport", opts.destination)
 	}
 
 	if opts.source == opts.destination && opts.source == directory.Transport.Name() {
 		return errors.New("sync from 'dir' to 'dir' not implemented, consider using rsync instead")
 	}
+	if opts.source == opts.destination && opts.source == ocilayout.Transport.Name() && sameDirectory(args[0], args[1]) {
+		return errors.New("sync from an OCI layout to the same OCI layout is not supported")
+	}
+	if opts.source == opts.destination && opts.source == storage.Transport.Name() {
+		return errors.New("sync from 'containers-storage' to 'containers-storage' is not supported")
+	}
+
+	if err := reexecIfNecessaryForImages(opts.source+":"+args[0], opts.destination+":"+args[1]); err != nil {
+		return err
+	}
+
+	if opts.prune && opts.destination != docker.Transport.Name() && opts.destination != ocilayout.Transport.Name() {
+		return fmt.Errorf("--prune is not supported with --dest %s", opts.destination)
+	}
+	if (opts.stateFile != "" || opts.planOut != "" || plan != nil) && (isSyncArchiveTransport(opts.source) || isSyncArchiveTransport(opts.destination)) {
+		return errors.New("--state-file, --plan-out and --plan-in are not supported with the docker-archive and oci-archive transports")
+	}
+	if opts.watch && isSyncArchiveTransport(opts.destination) {
+		return fmt.Errorf("--watch is not supported with --dest %s", opts.destination)
+	}
+
+	if opts.copy.referrers {
+		srcTransport := opts.source
+		if srcTransport == "yaml" {
+			srcTransport = docker.Transport.Name()
+		}
+		if err := checkReferrersTransports(srcTransport, opts.destination); err != nil {
+			return err
+		}
+	}
 
 	opts.destImage.warnAboutIneffectiveOptions(transports.Get(opts.destination))
 
+	target := syncTarget{
+		Transport:    opts.destination,
+		Destination:  args[1],
+		Scoped:       opts.scoped,
+		AppendSuffix: opts.appendSuffix,
+	}
+	var state *syncState
+	if opts.stateFile != "" || opts.watch {
+		// With --watch, images unchanged since an earlier sync of this process are skipped even without --state-file.
+		state, err = loadSyncState(opts.stateFile, target)
+		if err != nil {
+			return err
+		}
+	}
+	if opts.watch {
+		return opts.watchSync(args, stdout, policy, target, state)
+	}
+	return opts.syncOnce(args, stdout, policy, plan, nil, target, state, nil)
+}
+
+// syncOnce syncs the images from args[0] to args[1], as validated by run, once.
+// If plan is not nil, the planned copies are executed instead.
+// If cfg is not nil, it is used instead of reading the YAML source configuration in args[0].
+// state is used to skip unchanged images and record the synced ones, if it is not nil.
+// If stop is closed, no further images are copied, and syncOnce returns nil after the copies already started finish;
+// stop may be nil.
+func (opts *syncOptions) syncOnce(args []string, stdout io.Writer, policy *signature.Policy, plan *syncPlan, cfg sourceConfig,
+	target syncTarget, state *syncState, stop <-chan struct{},
+) (retErr error) {
+	startTime := time.Now()
 	imageListSelection := copy.CopySystemImage
 	if opts.all {
 		imageListSelection = copy.CopyAllImages
@@ -635,14 +1272,79 @@ func (opts *syncOptions) run(args []string, stdout io.Writer) (retErr error) {
 	ctx, cancel := opts.global.commandTimeoutContext()
 	defer cancel()
 
+	var hookSummary syncHookSummary // Set after all copies finish
+	if !opts.dryRun {
+		defer func() {
+			if retErr != nil {
+				hookSummary.Error = retErr.Error()
+			}
+			event := syncHookEvent{Event: syncHookSyncFinished, Source: args[0], Destination: args[1], Summary: &hookSummary}
+			if err := opts.runSyncHooks(ctx, event); err != nil {
+				if retErr == nil {
+					retErr = err
+				} else {
+					logrus.Error(err)
+				}
+			}
+		}()
+	}
+
 	sourceArg := args[0]
 	var srcRepoList []repoDescriptor
-	if err = retry.IfNecessary(ctx, func() error {
-		srcRepoList, err = imagesToCopy(sourceArg, opts.source, sourceCtx)
+	if plan != nil {
+		srcRepoList, err = plan.repoDescriptors(sourceCtx)
+		if err != nil {
+			return err
+		}
+		if err := opts.verifyPlannedSettings(srcRepoList); err != nil {
+			return err
+		}
+		if err := verifyPlannedSources(ctx, opts.retryOpts, opts.source, srcRepoList); err != nil {
+			return err
+		}
+	} else if opts.lockfile != "" {
+		lockfile, err := loadSyncLockfile(opts.lockfile)
+		if err != nil {
+			return err
+		}
+		srcRepoList, err = lockfile.repoDescriptors(sourceArg, sourceCtx)
+		if err != nil {
+			return err
+		}
+		if err := verifyLockedSources(ctx, opts.retryOpts, srcRepoList); err != nil {
+			return err
+		}
+	} else if isSyncArchiveTransport(opts.source) {
+		archiveSource, err := newSyncArchiveSource(sourceCtx, opts.source, sourceArg)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := archiveSource.close(); err != nil {
+				retErr = noteCloseFailure(retErr, "closing source archive", err)
+			}
+		}()
+		srcRepoList = []repoDescriptor{archiveSource.repo}
+	} else if err = retry.IfNecessary(ctx, func() error {
+		if cfg != nil {
+			srcRepoList, err = imagesToCopyFromSourceConfig(cfg, sourceCtx)
+		} else {
+			srcRepoList, err = imagesToCopy(sourceArg, opts.source, sourceCtx)
+		}
 		return err
 	}, opts.retryOpts); err != nil {
 		return err
 	}
+	if opts.lockfileOut != "" {
+		lockfile, err := lockSourceImages(ctx, opts.retryOpts, srcRepoList)
+		if err != nil {
+			return err
+		}
+		if err := lockfile.write(opts.lockfileOut); err != nil {
+			return err
+		}
+		logrus.Infof("Wrote %d locked images to %s", len(lockfile.Images), opts.lockfileOut)
+	}
 
 	destination := args[1]
 	destinationCtx, err := opts.destImage.newSystemContext()
@@ -650,7 +1352,41 @@ func (opts *syncOptions) run(args []string, stdout io.Writer) (retErr error) {
 		return err
 	}
 
-	options, cleanupOptions, err := opts.copy.copyOptions(stdout)
+	var archiveDest *syncArchiveDestination
+	if isSyncArchiveTransport(opts.destination) {
+		archiveDest, err = newSyncArchiveDestination(destinationCtx, opts.destination, destination, opts.dryRun)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := archiveDest.close(); err != nil {
+				retErr = noteCloseFailure(retErr, "writing destination archive", err)
+			}
+		}()
+	}
+
+	workers := int(opts.parallelImages)
+	if workers > 1 && (opts.destination == ocilayout.Transport.Name() || archiveDest != nil) {
+		// All images are written to a single index.json or archive, which does not support concurrent writers.
+		logrus.Warnf("--parallel-images is ignored with --dest %s, images are copied one at a time", opts.destination)
+		workers = 1
+	}
+
+	progress, reportWriter, err := opts.copy.newProgressReporter(stdout)
+	if err != nil {
+		return err
+	}
+	if workers > 1 {
+		// Human-readable progress of concurrent copies would be interleaved and unreadable.
+		reportWriter = nil
+	}
+	defer func() {
+		if err := progress.close(); err != nil {
+			retErr = noteCloseFailure(retErr, "closing progress output", err)
+		}
+	}()
+
+	options, cleanupOptions, err := opts.copy.copyOptions(reportWriter)
 	if err != nil {
 		return err
 	}
@@ -658,6 +1394,36 @@ func (opts *syncOptions) run(args []string, stdout io.Writer) (retErr error) {
 	options.DestinationCtx = destinationCtx
 	options.ImageListSelection = imageListSelection
 	options.OptimizeDestinationImageAlreadyExists = true
+	if workers > 1 {
+		// Limit the number of blob copies in total, not per image, so that --parallel-images does not multiply the load
+		// on the registries.
+		blobCopies := opts.imageParallelCopies
+		if blobCopies == 0 {
+			blobCopies = defaultSyncBlobCopies
+		}
+		options.ConcurrentBlobCopiesSemaphore = semaphore.NewWeighted(int64(blobCopies))
+	} else {
+		options.MaxParallelDownloads = opts.imageParallelCopies
+	}
+
+	// A PolicyContext can only be used by one goroutine at a time, so each concurrent copy uses its own one,
+	// all sharing the same policy.
+	policyContexts := make(chan *signature.PolicyContext, workers)
+	defer func() {
+		close(policyContexts)
+		for pc := range policyContexts {
+			if err := pc.Destroy(); err != nil {
+				retErr = noteCloseFailure(retErr, "tearing down policy context", err)
+			}
+		}
+	}()
+	for range workers {
+		pc, err := opts.global.newPolicyContext(policy)
+		if err != nil {
+			return fmt.Errorf("Error loading trust policy: %w", err)
+		}
+		policyContexts <- pc
+	}
 
 	errorsPresent := false
 	imagesNumber := 0
@@ -678,73 +1444,354 @@ func (opts *syncOptions) run(args []string, stdout io.Writer) (retErr error) {
 		}()
 	}
 
+	imagesTotal := 0
 	for _, srcRepo := range srcRepoList {
-		options.SourceCtx = srcRepo.Context
-		for counter, ref := range srcRepo.ImageRefs {
-			var destSuffix string
-			var manifestBytes []byte
-			switch ref.Transport() {
-			case docker.Transport:
-				// docker -> dir or docker -> docker
-				destSuffix = ref.DockerReference().String()
-			case directory.Transport:
-				// dir -> docker (we don't allow `dir` -> `dir` sync operations)
-				destSuffix = strings.TrimPrefix(ref.StringWithinTransport(), srcRepo.DirBasePath)
-				if destSuffix == "" {
-					// if source is a full path to an image, have destPath scoped to repo:tag
-					destSuffix = path.Base(srcRepo.DirBasePath)
+		imagesTotal += len(srcRepo.ImageRefs)
+	}
+	results := make([]syncImageResult, imagesTotal)
+	imagesSkipped := 0
+	sourceNames := map[string]struct{}{} // Sources of all images in this run, for --state-file
+	var report *syncReport
+	if opts.reportFile != "" {
+		report = newSyncReport(sourceArg, destination, startTime)
+	}
+	var planOut *syncPlan
+	if opts.planOut != "" {
+		planOut = newSyncPlan(syncPlanSource{Transport: opts.source, Source: sourceArg}, target)
+	}
+	var pruner *syncPruner
+	if opts.prune {
+		pruner = newSyncPruner(destination, opts.appendSuffix)
+	}
+	var archiveGroups dockerArchiveGroups
+	if archiveDest != nil {
+		archiveGroups, err = archiveDest.groupImages(ctx, opts.retryOpts, srcRepoList, func(srcRepo repoDescriptor, ref types.ImageReference) (string, error) {
+			destSuffix, err := opts.destinationSuffix(srcRepo, ref)
+			return destSuffix + opts.appendSuffix, err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	var copyFailed atomic.Bool
+	stopped := false // No further images were copied after stop was closed
+	var wg sync.WaitGroup
+	dispatchErr := func() error {
+		index := 0
+		for _, srcRepo := range srcRepoList {
+			for counter, ref := range srcRepo.ImageRefs {
+				var planned *syncPlanImage
+				if srcRepo.Planned != nil {
+					planned = srcRepo.Planned[counter]
+				}
+				destSuffix, err := opts.destinationSuffix(srcRepo, ref)
+				if err != nil {
+					return err
 				}
-			}
 
-			if !opts.scoped {
-				destSuffix = path.Base(destSuffix)
-			}
+				// Check the state file before creating the destination reference, which refuses to overwrite dir: images.
+				sourceName := transports.ImageName(ref)
+				settings := opts.copySettings(srcRepo)
+				var sourceDigest digest.Digest
+				if srcRepo.Digests != nil {
+					sourceDigest = srcRepo.Digests[counter]
+				}
+				if planned != nil {
+					// The source is referenced by digest; use the original name, and don't skip anything in the plan.
+					sourceName, sourceDigest = planned.Source, planned.SourceDigest
+				} else if state != nil {
+					sourceNames[sourceName] = struct{}{}
+					d := sourceDigest
+					var err error
+					if d == "" {
+						d, err = manifestDigest(ctx, srcRepo.Context, ref)
+					}
+					var destName string
+					if err == nil {
+						sourceDigest = d
+						// The destination may not exist yet, e.g. an OCI layout; then the image can't be unchanged.
+						destName, err = opts.destinationName(destination, destSuffix)
+					}
+					if err != nil {
+						logrus.WithError(err).Debugf("Error checking whether %q is unchanged, not using the state file", sourceName)
+					} else if image, ok := state.unchanged(sourceName, sourceDigest, destName, settings); ok {
+						if pruner != nil {
+							destRef, err := alltransports.ParseImageName(destName)
+							if err != nil {
+								return fmt.Errorf("invalid destination %q: %w", destName, err)
+							}
+							pruner.add(destRef)
+						}
+						logrus.WithFields(logrus.Fields{
+							"from": sourceName,
+							"to":   destName,
+						}).Infof("Skipping unchanged image ref %d/%d", counter+1, len(srcRepo.ImageRefs))
+						imagesSkipped++
+						if !opts.dryRun {
+							results[index] = syncImageResult{ref: ref, destName: destName, destDigest: image.DestinationDigest, skipped: true}
+							index++
+						}
+						continue
+					}
+				}
 
-			destRef, err := destinationReference(path.Join(destination, destSuffix)+opts.appendSuffix, opts.destination)
-			if err != nil {
-				return err
-			}
+				// Wait until a copy slot is free before creating the destination reference (which can create directories),
+				// so that nothing is created, and no copy is started, after a failure without --keep-going.
+				var policyContext *signature.PolicyContext
+				if !opts.dryRun {
+					policyContext = <-policyContexts
+					if copyFailed.Load() && !opts.keepGoing {
+						policyContexts <- policyContext
+						return nil
+					}
+					select {
+					case <-stop:
+						stopped = true
+						policyContexts <- policyContext
+						return nil
+					default:
+					}
+				}
 
-			fromToFields := logrus.Fields{
-				"from": transports.ImageName(ref),
-				"to":   transports.ImageName(destRef),
-			}
-			if opts.dryRun {
-				logrus.WithFields(fromToFields).Infof("Would have copied image ref %d/%d", counter+1, len(srcRepo.ImageRefs))
-			} else {
-				logrus.WithFields(fromToFields).Infof("Copying image ref %d/%d", counter+1, len(srcRepo.ImageRefs))
-				if err = retry.IfNecessary(ctx, func() error {
-					manifestBytes, err = copy.Image(ctx, policyContext, destRef, ref, options)
+				var destRef types.ImageReference // nil for destinations which don't exist yet with --dry-run
+				var destName string
+				switch {
+				case planned != nil:
+					destRef, err = plannedDestinationReference(target, planned.Destination)
+				case opts.dryRun && opts.destination == directory.Transport.Name():
+					// Don't create the destination directory, so that the image can be copied later, e.g. using --plan-in.
+					destDir := path.Join(destination, destSuffix) + opts.appendSuffix
+					if err = checkDirectoryDestination(destDir); err == nil {
+						destName, err = opts.destinationName(destination, destSuffix)
+					}
+				case opts.dryRun && opts.destination == ocilayout.Transport.Name():
+					// Don't create the OCI layout. If it already exists, the reference is used by --prune.
+					if _, err = os.Stat(destination); err == nil {
+						destRef, err = ociLayoutReference(destination, destSuffix+opts.appendSuffix)
+					} else if errors.Is(err, fs.ErrNotExist) {
+						destName, err = opts.destinationName(destination, destSuffix)
+					}
+				case archiveDest != nil:
+					destRef, err = archiveDest.reference(destSuffix + opts.appendSuffix)
+				case opts.destination == ocilayout.Transport.Name():
+					destRef, err = ociDestinationReference(destination, destSuffix+opts.appendSuffix)
+				default:
+					destRef, err = destinationReference(path.Join(destination, destSuffix)+opts.appendSuffix, opts.destination)
+				}
+				if err != nil {
+					if policyContext != nil {
+						policyContexts <- policyContext
+					}
 					return err
-				}, opts.retryOpts); err != nil {
-					if !opts.keepGoing {
-						return fmt.Errorf("Error copying ref %q: %w", transports.ImageName(ref), err)
+				}
+
+				if pruner != nil && destRef != nil {
+					pruner.add(destRef)
+				}
+
+				switch {
+				case archiveDest != nil:
+					destName = archiveDest.imageName(destRef)
+				case destRef != nil:
+					destName = transports.ImageName(destRef)
+				}
+				fromToFields := logrus.Fields{
+					"from": transports.ImageName(ref),
+					"to":   destName,
+				}
+				if opts.dryRun {
+					logrus.WithFields(fromToFields).Infof("Would have copied image ref %d/%d", counter+1, len(srcRepo.ImageRefs))
+					imagesNumber++
+					if planOut != nil {
+						if err := planOut.addImage(ctx, opts.retryOpts, srcRepo, ref, sourceDigest, destName, settings); err != nil {
+							return err
+						}
 					}
-					// log the error, keep a note that there was a failure and move on to the next
-					// image ref
-					errorsPresent = true
-					logrus.WithError(err).Errorf("Error copying ref %q", transports.ImageName(ref))
 					continue
 				}
-				// Ensure that we log the manifest digest to a file only if the copy operation was successful
-				if opts.digestFile != "" {
-					manifestDigest, err := manifest.Digest(manifestBytes)
-					if err != nil {
-						return err
+
+				if first, ok := archiveGroups.first[index]; ok {
+					logrus.WithFields(fromToFields).Infof("Writing image ref %d/%d as an additional tag of %s", counter+1, len(srcRepo.ImageRefs), results[first].destName)
+					results[index] = syncImageResult{ref: ref, destName: destName} // The outcome is set from the first image below
+					index++
+					policyContexts <- policyContext
+					continue
+				}
+
+				srcRef, err := srcRepo.sourceReference(counter)
+				if err != nil {
+					policyContexts <- policyContext
+					return err
+				}
+				logrus.WithFields(fromToFields).Infof("Copying image ref %d/%d", counter+1, len(srcRepo.ImageRefs))
+				imageOptions := *options
+				imageOptions.SourceCtx = srcRepo.Context
+				if tags := archiveGroups.additionalTags[index]; tags != nil {
+					destCtx := *imageOptions.DestinationCtx
+					destCtx.DockerArchiveAdditionalTags = tags
+					imageOptions.DestinationCtx = &destCtx
+				}
+				var variantPlatforms []platformSelector
+				if srcRepo.Platforms != nil {
+					imageOptions.ImageListSelection = copy.CopySpecificImages
+					imageOptions.InstancePlatforms = srcRepo.Platforms.filters
+					variantPlatforms = srcRepo.Platforms.variants
+				}
+				res := &results[index]
+				index++
+				res.ref, res.destName = ref, destName
+				var blobs *blobStats
+				if report != nil {
+					blobs = &res.blobs
+				}
+				wg.Go(func() {
+					defer func() { policyContexts <- policyContext }()
+					start := time.Now()
+					res.destDigest, res.err = opts.copyImage(ctx, policyContext, srcRef, destRef, &imageOptions, variantPlatforms, progress, blobs)
+					res.duration = time.Since(start)
+					if res.err == nil {
+						// Before recording the image in the state file, so that it is copied, and the hooks run, again after a failure.
+						res.err = opts.runSyncHooks(ctx, syncHookEvent{
+							Event:       syncHookImageSynced,
+							Source:      sourceName,
+							Destination: res.destName,
+							Digest:      res.destDigest,
+						})
 					}
-					outputStr := fmt.Sprintf("%s %s", manifestDigest.String(), transports.ImageName(destRef))
-					if _, err = digestFile.WriteString(outputStr + "\n"); err != nil {
-						return fmt.Errorf("Failed to write digest to file %q: %w", opts.digestFile, err)
+					if res.err == nil && state != nil && sourceDigest != "" {
+						res.err = state.record(sourceName, syncStateImage{
+							SourceDigest:      sourceDigest,
+							Destination:       res.destName,
+							DestinationDigest: res.destDigest,
+							Settings:          settings,
+							Synced:            time.Now().UTC(),
+						})
 					}
-				}
+					switch {
+					case res.err != nil:
+						copyFailed.Store(true)
+						if opts.keepGoing {
+							// log the error, keep a note that there was a failure and move on to the next image ref
+							logrus.WithError(res.err).Errorf("Error copying ref %q", transports.ImageName(ref))
+						}
+					case workers > 1:
+						logrus.WithFields(fromToFields).Info("Copied image ref")
+					}
+				})
 			}
+		}
+		return nil
+	}()
+	wg.Wait()
+	for i, first := range archiveGroups.first {
+		if results[i].ref != nil {
+			results[i].destDigest, results[i].err = results[first].destDigest, results[first].err
+		}
+	}
 
+	// Process the results in the order of the source images, so that the digest file does not depend on timing.
+	var copyErr error
+	for _, res := range results {
+		if res.ref == nil { // Not copied
+			continue
+		}
+		if res.err != nil {
+			errorsPresent = true
+			hookSummary.Failed++
+			switch {
+			case opts.keepGoing: // Already logged
+			case dispatchErr == nil && copyErr == nil:
+				copyErr = fmt.Errorf("Error copying ref %q: %w", transports.ImageName(res.ref), res.err)
+			default:
+				logrus.WithError(res.err).Errorf("Error copying ref %q", transports.ImageName(res.ref))
+			}
+			continue
+		}
+		// Ensure that we log the manifest digest to a file only if the copy operation was successful
+		if opts.digestFile != "" {
+			outputStr := fmt.Sprintf("%s %s", res.destDigest.String(), res.destName)
+			if _, err = digestFile.WriteString(outputStr + "\n"); err != nil {
+				return fmt.Errorf("Failed to write digest to file %q: %w", opts.digestFile, err)
+			}
+		}
+		if !res.skipped {
 			imagesNumber++
 		}
 	}
+	hookSummary.Synced, hookSummary.Unchanged = imagesNumber, imagesSkipped
+	if report != nil {
+		report.addResults(srcRepoList, results, dispatchErr)
+		if err := report.write(opts.reportFile, opts.reportFormat); err != nil {
+			return err
+		}
+	}
+	if dispatchErr != nil {
+		return dispatchErr
+	}
+	if copyErr != nil {
+		return copyErr
+	}
+	if stopped {
+		// Not all source images were processed, so don't prune the destination or forget any synced images.
+		logrus.Infof("Stopped after syncing %d images from %d sources", imagesNumber, len(srcRepoList))
+		if errorsPresent {
+			return errors.New("Sync failed due to previous reported error(s) for one or more images")
+		}
+		return nil
+	}
+
+	if pruner != nil || (plan != nil && len(plan.Prunes) != 0) {
+		var pruned []types.ImageReference
+		var err error
+		if plan != nil {
+			copiedDigests := map[digest.Digest]struct{}{}
+			for _, res := range results {
+				if res.ref != nil && res.err == nil {
+					copiedDigests[res.destDigest] = struct{}{}
+				}
+			}
+			pruned, err = plan.applyPrunes(ctx, destinationCtx, opts.retryOpts, copiedDigests, opts.keepGoing)
+		} else {
+			pruned, err = pruner.prune(ctx, destinationCtx, opts.retryOpts, opts.pruneMaxDeletions, opts.dryRun, opts.keepGoing)
+		}
+		if err != nil {
+			if !opts.keepGoing {
+				return err
+			}
+			errorsPresent = true
+			logrus.WithError(err).Error("Error pruning destination tags")
+		}
+		if opts.dryRun {
+			logrus.Infof("Would have pruned %d tags", len(pruned))
+			if planOut != nil {
+				if err := planOut.addPrunes(ctx, destinationCtx, opts.retryOpts, pruned); err != nil {
+					return err
+				}
+			}
+		} else {
+			logrus.Infof("Pruned %d tags", len(pruned))
+		}
+	}
+
+	if state != nil {
+		// A plan only contains the images which were not skipped when it was created, so it does not determine which images are still synced.
+		if !opts.dryRun && plan == nil {
+			// Forget images which no longer exist at the source, or were excluded by the source filters.
+			if err := state.retain(sourceNames); err != nil {
+				return err
+			}
+		}
+		logrus.Infof("Skipped %d unchanged images", imagesSkipped)
+	}
 
 	if opts.dryRun {
 		logrus.Infof("Would have synced %d images from %d sources", imagesNumber, len(srcRepoList))
+		if planOut != nil && !errorsPresent {
+			if err := planOut.write(opts.planOut); err != nil {
+				return err
+			}
+			logrus.Infof("Wrote plan to %s", opts.planOut)
+		}
 	} else {
 		logrus.Infof("Synced %d images from %d sources", imagesNumber, len(srcRepoList))
 	}
@@ -753,3 +1800,58 @@ func (opts *syncOptions) run(args []string, stdout io.Writer) (retErr error) {
 	}
 	return errors.New("Sync failed due to previous reported error(s) for one or more images")
 }
+
+// syncImageResult is the result of copying a single image in syncOptions.run.
+type syncImageResult struct {
+	ref        types.ImageReference // nil if the copy was not started
+	destName   string               // transports.ImageName of the destination
+	destDigest digest.Digest
+	skipped    bool // The image was not copied because it is unchanged since it was recorded in --state-file
+	err        error
+	blobs      blobStats     // Blobs of the last copy attempt, only collected with --report
+	duration   time.Duration // Duration of the copy, including retries
+}
+
+// copyImage copies ref to destRef, including its referrers if requested, and returns the digest of the manifest of the copied image.
+// If ref is a list, instances matching variantPlatforms are copied in addition to options.Instances.
+// If blobs is not nil, it is set to the blobs processed by the last copy attempt.
+// options must not be shared with other concurrent copies.
+func (opts *syncOptions) copyImage(ctx context.Context, policyContext *signature.PolicyContext, ref, destRef types.ImageReference,
+	options *copy.Options, variantPlatforms []platformSelector, progress *jsonProgressReporter, blobs *blobStats,
+) (digest.Digest, error) {
+	if len(variantPlatforms) > 0 {
+		var resolved []digest.Digest
+		if err := retry.IfNecessary(ctx, func() error {
+			var err error
+			resolved, err = resolvePlatformInstances(ctx, options.SourceCtx, ref, variantPlatforms)
+			return err
+		}, opts.retryOpts); err != nil {
+			return "", fmt.Errorf("resolving platforms: %w", err)
+		}
+		options.Instances = slices.Concat(options.Instances, resolved)
+	}
+
+	var manifestBytes []byte
+	err := retry.IfNecessary(ctx, func() error {
+		var listeners []func(types.ProgressProperties)
+		if blobs != nil {
+			*blobs = blobStats{} // Only report the last attempt
+			listeners = append(listeners, blobs.blobProgress)
+		}
+		progressDone := progress.startImage(options, ref, destRef, listeners...)
+		var err error
+		manifestBytes, err = copy.Image(ctx, policyContext, destRef, ref, options)
+		progressDone(manifestBytes, err)
+		if err != nil {
+			return err
+		}
+		if err := opts.copy.copyReferrers(ctx, options, ref, destRef, manifestBytes); err != nil {
+			return fmt.Errorf("copying referrers: %w", err)
+		}
+		return nil
+	}, opts.retryOpts)
+	if err != nil {
+		return "", err
+	}
+	return manifest.Digest(manifestBytes)
+}
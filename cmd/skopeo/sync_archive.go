@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/sirupsen/logrus"
+	"go.podman.io/common/pkg/retry"
+	dockerarchive "go.podman.io/image/v5/docker/archive"
+	"go.podman.io/image/v5/docker/reference"
+	ociarchive "go.podman.io/image/v5/oci/archive"
+	"go.podman.io/image/v5/transports"
+	"go.podman.io/image/v5/types"
+	"go.podman.io/storage/pkg/archive"
+	"go.podman.io/storage/pkg/idtools"
+)
+
+// isSyncArchiveTransport returns true if transport is one of the single-file, multi-image transports supported by sync.
+func isSyncArchiveTransport(transport string) bool {
+	return transport == dockerarchive.Transport.Name() || transport == ociarchive.Transport.Name()
+}
+
+// syncArchiveSource contains all images of a docker-archive or oci-archive file used as a sync source.
+type syncArchiveSource struct {
+	repo    repoDescriptor
+	reader  *dockerarchive.Reader // For docker-archive
+	tempDir string                // For oci-archive, contains the extracted OCI layout
+}
+
+// newSyncArchiveSource opens the archive at path, using transport, and enumerates all its images.
+// The caller must call close when the images are no longer used.
+func newSyncArchiveSource(sys *types.SystemContext, transport, path string) (*syncArchiveSource, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("Invalid source archive specified: %w", err)
+	}
+	s := &syncArchiveSource{repo: repoDescriptor{Context: sys}}
+	success := false
+	defer func() {
+		if !success {
+			if err := s.close(); err != nil {
+				logrus.Warnf("Error closing source archive %s: %v", path, err)
+			}
+		}
+	}()
+
+	switch transport {
+	case dockerarchive.Transport.Name():
+		reader, err := dockerarchive.NewReader(sys, path)
+		if err != nil {
+			return nil, err
+		}
+		s.reader = reader
+		images, err := reader.List()
+		if err != nil {
+			return nil, err
+		}
+		for i, refs := range images {
+			for _, ref := range refs {
+				if ref.DockerReference() == nil {
+					logrus.Warnf("Skipping untagged image @%d in %s, it has no name to use at the destination", i, path)
+					continue
+				}
+				s.repo.ImageRefs = append(s.repo.ImageRefs, ref)
+			}
+		}
+
+	case ociarchive.Transport.Name():
+		tempDir, err := os.MkdirTemp(sys.BigFilesTemporaryDir, "skopeo-sync-")
+		if err != nil {
+			return nil, err
+		}
+		s.tempDir = tempDir
+		// Images named only by a tag are synced using the name of the layout directory, so use the archive name for the directory.
+		layoutDir := filepath.Join(tempDir, strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)))
+		if err := untarFile(path, layoutDir); err != nil {
+			return nil, err
+		}
+		s.repo.DirBasePath = layoutDir
+		s.repo.ImageRefs, err = imagesToCopyFromOCILayout(layoutDir)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(s.repo.ImageRefs) == 0 {
+		return nil, fmt.Errorf("No images to sync found in %q", path)
+	}
+	success = true
+	return s, nil
+}
+
+// close releases the resources used by s.
+func (s *syncArchiveSource) close() error {
+	var errs []error
+	if s.reader != nil {
+		errs = append(errs, s.reader.Close())
+	}
+	if s.tempDir != "" {
+		errs = append(errs, os.RemoveAll(s.tempDir))
+	}
+	return errors.Join(errs...)
+}
+
+// untarFile extracts the tar file at path into dir.
+func untarFile(path, dir string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := archive.Untar(f, dir, &archive.TarOptions{NoLchown: true}); err != nil {
+		return fmt.Errorf("extracting %q: %w", path, err)
+	}
+	return nil
+}
+
+// syncArchiveDestination writes all images of a sync into a single docker-archive or oci-archive file.
+type syncArchiveDestination struct {
+	transport string
+	path      string
+	writer    *dockerarchive.Writer // For docker-archive, nil with dryRun
+	tempDir   string                // For oci-archive, contains the OCI layout written to path on close, "" with dryRun
+}
+
+// newSyncArchiveDestination returns a syncArchiveDestination writing to path using transport.
+// With dryRun, nothing is created, but references to the images in the archive can still be created.
+// The caller must call close after all images are copied.
+func newSyncArchiveDestination(sys *types.SystemContext, transport, path string, dryRun bool) (*syncArchiveDestination, error) {
+	if info, err := os.Stat(path); err == nil && (!info.Mode().IsRegular() || info.Size() != 0) {
+		return nil, fmt.Errorf("Refusing to overwrite destination archive %q", path)
+	}
+	d := &syncArchiveDestination{transport: transport, path: path}
+	if dryRun {
+		return d, nil
+	}
+	switch transport {
+	case dockerarchive.Transport.Name():
+		writer, err := dockerarchive.NewWriter(sys, path)
+		if err != nil {
+			return nil, err
+		}
+		d.writer = writer
+	case ociarchive.Transport.Name():
+		tempDir, err := os.MkdirTemp(sys.BigFilesTemporaryDir, "skopeo-sync-")
+		if err != nil {
+			return nil, err
+		}
+		d.tempDir = tempDir
+	}
+	return d, nil
+}
+
+// reference returns a reference to name, a destination image name computed by sync, in the archive.
+func (d *syncArchiveDestination) reference(name string) (types.ImageReference, error) {
+	switch d.transport {
+	case dockerarchive.Transport.Name():
+		tagged, err := dockerArchiveTag(name)
+		if err != nil {
+			return nil, err
+		}
+		if d.writer == nil {
+			return dockerarchive.NewReference(d.path, tagged)
+		}
+		return d.writer.NewReference(tagged)
+	default: // oci-archive
+		if d.tempDir == "" {
+			return ociarchive.NewReference(d.path, strings.TrimPrefix(name, "/"))
+		}
+		return ociDestinationReference(d.tempDir, name)
+	}
+}
+
+// dockerArchiveTag parses name, a destination image name computed by sync, as a docker-archive tag.
+func dockerArchiveTag(name string) (reference.NamedTagged, error) {
+	named, err := reference.ParseNormalizedNamed(strings.TrimPrefix(name, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("Cannot obtain a valid image reference for transport %q and reference %q: %w", dockerarchive.Transport.Name(), name, err)
+	}
+	tagged, ok := named.(reference.NamedTagged)
+	if !ok {
+		return nil, fmt.Errorf("Cannot store %q in a docker-archive, which only supports tagged names", name)
+	}
+	return tagged, nil
+}
+
+// dockerArchiveGroups describes images of a sync written to a docker-archive together.
+// A docker-archive can only contain each image once, so an image with the same configuration as an earlier one
+// (e.g. the same image with another tag) is not copied again, but written as an additional tag of the earlier image.
+// Images are identified by their index in the list of all images of the sync.
+type dockerArchiveGroups struct {
+	additionalTags map[int][]reference.NamedTagged // Tags of the other images of the group, for the first image of each group
+	first          map[int]int                     // The first image of the group, for the other images
+}
+
+// groupImages returns the dockerArchiveGroups of the images in srcRepoList, with destination names returned by name.
+// It returns no groups for oci-archive destinations, and with dryRun.
+func (d *syncArchiveDestination) groupImages(ctx context.Context, retryOpts *retry.Options, srcRepoList []repoDescriptor,
+	name func(srcRepo repoDescriptor, ref types.ImageReference) (string, error),
+) (dockerArchiveGroups, error) {
+	groups := dockerArchiveGroups{additionalTags: map[int][]reference.NamedTagged{}, first: map[int]int{}}
+	if d.writer == nil {
+		return groups, nil
+	}
+	firstImages := map[digest.Digest]int{} // Indexed by the digest of the image configuration
+	index := 0
+	for _, srcRepo := range srcRepoList {
+		for i, ref := range srcRepo.ImageRefs {
+			srcRef, err := srcRepo.sourceReference(i)
+			if err != nil {
+				return dockerArchiveGroups{}, err
+			}
+			var configDigest digest.Digest
+			if err := retry.IfNecessary(ctx, func() error {
+				var err error
+				configDigest, err = imageConfigDigest(ctx, srcRepo.Context, srcRef)
+				return err
+			}, retryOpts); err != nil {
+				return dockerArchiveGroups{}, fmt.Errorf("reading configuration digest of %s: %w", transports.ImageName(ref), err)
+			}
+			if first, ok := firstImages[configDigest]; ok {
+				n, err := name(srcRepo, ref)
+				if err != nil {
+					return dockerArchiveGroups{}, err
+				}
+				tag, err := dockerArchiveTag(n)
+				if err != nil {
+					return dockerArchiveGroups{}, err
+				}
+				groups.additionalTags[first] = append(groups.additionalTags[first], tag)
+				groups.first[index] = first
+			} else {
+				firstImages[configDigest] = index
+			}
+			index++
+		}
+	}
+	return groups, nil
+}
+
+// imageConfigDigest returns the digest of the configuration of ref; for image lists, of the instance for the platform of sys.
+func imageConfigDigest(ctx context.Context, sys *types.SystemContext, ref types.ImageReference) (_ digest.Digest, retErr error) {
+	img, err := ref.NewImage(ctx, sys)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err := img.Close(); err != nil {
+			retErr = noteCloseFailure(retErr, "closing image", err)
+		}
+	}()
+	return img.ConfigInfo().Digest, nil
+}
+
+// close finishes writing the archive, containing all images copied successfully.
+func (d *syncArchiveDestination) close() error {
+	if d.writer != nil {
+		return d.writer.Close()
+	}
+	if d.tempDir == "" {
+		return nil
+	}
+	defer os.RemoveAll(d.tempDir)
+	if _, err := os.Stat(filepath.Join(d.tempDir, "index.json")); err != nil {
+		if errors.Is(err, os.ErrNotExist) { // No image was copied
+			return nil
+		}
+		return err
+	}
+	return tarDirectory(d.tempDir, d.path)
+}
+
+// tarDirectory writes the contents of dir to the tar file at path.
+func tarDirectory(dir, path string) (retErr error) {
+	input, err := archive.TarWithOptions(dir, &archive.TarOptions{
+		Compression: archive.Uncompressed,
+		// Don’t include the data about the user account this code is running under.
+		ChownOpts: &idtools.IDPair{UID: 0, GID: 0},
+	})
+	if err != nil {
+		return fmt.Errorf("creating archive of %q: %w", dir, err)
+	}
+	defer input.Close()
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			retErr = noteCloseFailure(retErr, "closing archive", err)
+		}
+	}()
+	if _, err := io.Copy(f, input); err != nil {
+		return fmt.Errorf("writing %q: %w", path, err)
+	}
+	return nil
+}
+
+// imageName returns the name of ref, returned by d.reference, for logs and reports.
+func (d *syncArchiveDestination) imageName(ref types.ImageReference) string {
+	if d.tempDir != "" {
+		// Refer to the archive, not to the temporary OCI layout
+		return fmt.Sprintf("%s:%s:%s", ociarchive.Transport.Name(), d.path, ociReferenceName(ref))
+	}
+	return transports.ImageName(ref)
+}
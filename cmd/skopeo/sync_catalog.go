@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"regexp"
+	"slices"
+
+	"github.com/sirupsen/logrus"
+	"go.podman.io/image/v5/pkg/docker/config"
+	"go.podman.io/image/v5/types"
+)
+
+// catalogPageSize is the number of repositories requested per page of a catalog API response.
+const catalogPageSize = 1000
+
+// fetchCatalog returns the names of all repositories in registry, as listed by the /v2/_catalog API.
+func fetchCatalog(ctx context.Context, sys *types.SystemContext, registry string) ([]string, error) {
+	var auth types.DockerAuthConfig
+	if sys != nil && sys.DockerAuthConfig != nil {
+		auth = *sys.DockerAuthConfig
+	} else {
+		a, err := config.GetCredentials(sys, registry)
+		if err != nil {
+			return nil, fmt.Errorf("getting credentials for %s: %w", registry, err)
+		}
+		auth = a
+	}
+	c, err := newRegistryHostClient(sys, registry, registry, auth, "registry:catalog:*")
+	if err != nil {
+		return nil, err
+	}
+
+	var res []string
+	seen := map[string]struct{}{}
+	path := fmt.Sprintf("/v2/_catalog?n=%d", catalogPageSize)
+	for path != "" {
+		if _, ok := seen[path]; ok {
+			return nil, fmt.Errorf("listing repositories of %s: catalog pagination loops at %q", registry, path)
+		}
+		seen[path] = struct{}{}
+		repos, next, err := c.fetchCatalogPage(ctx, path)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, repos...)
+		path = next
+	}
+	return res, nil
+}
+
+// fetchCatalogPage fetches a single page of a catalog API response at path.
+// It returns the repository names and the path of the next page (or "").
+func (c *registryClient) fetchCatalogPage(ctx context.Context, path string) ([]string, string, error) {
+	res, err := c.get(ctx, path)
+	if err != nil {
+		return nil, "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("listing repositories of %s: HTTP status %s", c.host, res.Status)
+	}
+	var catalog struct {
+		Repositories []string `json:"repositories"`
+	}
+	if err := json.NewDecoder(io.LimitReader(res.Body, maxReferrersIndexSize)).Decode(&catalog); err != nil {
+		return nil, "", fmt.Errorf("decoding repositories of %s: %w", c.host, err)
+	}
+	next := ""
+	if match := linkNextRegexp.FindStringSubmatch(res.Header.Get("Link")); match != nil {
+		next = match[1]
+	}
+	return catalog.Repositories, next, nil
+}
+
+// configuresRepository returns true if repoName is listed explicitly in the images, images-by-tag-regex or images-by-semver
+// keys of cfg.
+func (cfg *registrySyncConfig) configuresRepository(repoName string) bool {
+	_, inImages := cfg.Images[repoName]
+	_, inImagesByTagRegex := cfg.ImagesByTagRegex[repoName]
+	_, inImagesBySemver := cfg.ImagesBySemver[repoName]
+	return inImages || inImagesByTagRegex || inImagesBySemver
+}
+
+// catalogFilterCollection lists the repositories of registryName, and returns a filterCollection for those matching the
+// repositories-by-regex key of cfg. Repositories listed explicitly by other keys are not included.
+// A tag of a repository matching several regular expressions is copied if it matches any of the corresponding tag regular expressions.
+func (cfg *registrySyncConfig) catalogFilterCollection(ctx context.Context, sys *types.SystemContext, registryName string) (filterCollection, error) {
+	type repositoryFilter struct {
+		repo, tag *regexp.Regexp
+	}
+	var filters []repositoryFilter
+	for _, repoRegex := range slices.Sorted(maps.Keys(cfg.RepositoriesByRegex)) {
+		repoPattern, err := regexp.Compile(repoRegex)
+		if err != nil {
+			return nil, fmt.Errorf("registry %s: repositories-by-regex: %w", registryName, err)
+		}
+		tagPattern, err := regexp.Compile(cfg.RepositoriesByRegex[repoRegex])
+		if err != nil {
+			return nil, fmt.Errorf("registry %s: repositories-by-regex: %s: %w", registryName, repoRegex, err)
+		}
+		filters = append(filters, repositoryFilter{repo: repoPattern, tag: tagPattern})
+	}
+
+	logrus.WithField("registry", registryName).Info("Querying registry for repositories")
+	repos, err := fetchCatalog(ctx, sys, registryName)
+	if err != nil {
+		return nil, fmt.Errorf("registry %s: %w", registryName, err)
+	}
+	collection := filterCollection{}
+	for _, repoName := range repos {
+		if cfg.configuresRepository(repoName) {
+			continue
+		}
+		var tagPatterns []*regexp.Regexp
+		for _, f := range filters {
+			if f.repo.MatchString(repoName) {
+				tagPatterns = append(tagPatterns, f.tag)
+			}
+		}
+		if len(tagPatterns) == 0 {
+			continue
+		}
+		collection[repoName] = func(logger *logrus.Entry, sourceReferences []types.ImageReference) []types.ImageReference {
+			var res []types.ImageReference
+			for _, sourceReference := range sourceReferences {
+				tag, ok := referenceTag(logger, sourceReference)
+				if ok && slices.ContainsFunc(tagPatterns, func(p *regexp.Regexp) bool { return p.MatchString(tag) }) {
+					res = append(res, sourceReference)
+				}
+			}
+			return res
+		}
+	}
+	return collection, nil
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/opencontainers/go-digest"
+	"go.podman.io/image/v5/docker/reference"
+	"gopkg.in/yaml.v3"
+)
+
+// registrySyncConfigKeys are the YAML keys of registrySyncConfig.
+var registrySyncConfigKeys = func() map[string]struct{} {
+	res := map[string]struct{}{}
+	t := reflect.TypeFor[registrySyncConfig]()
+	for i := range t.NumField() {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+		if name == "" {
+			name = strings.ToLower(t.Field(i).Name)
+		}
+		res[name] = struct{}{}
+	}
+	return res
+}()
+
+// yamlErrorLineRegexp matches the line number prefix of yaml.TypeError messages.
+var yamlErrorLineRegexp = regexp.MustCompile(`^line (\d+): (.*)$`)
+
+// sourceConfigError is returned for an invalid sync YAML file, and contains all errors found in the file.
+type sourceConfigError struct {
+	errs []error // Each error starts with FILE:LINE:COLUMN
+}
+
+func (e *sourceConfigError) Error() string {
+	msgs := make([]string, 0, len(e.errs))
+	for _, err := range e.errs {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// sourceConfigParser parses and validates a sync YAML file, collecting all errors with their positions in the file.
+type sourceConfigParser struct {
+	file string
+	errs []error
+}
+
+// errorf records an error at the position of node.
+func (p *sourceConfigParser) errorf(node *yaml.Node, format string, args ...any) {
+	p.errs = append(p.errs, fmt.Errorf("%s:%d:%d: %s", p.file, node.Line, node.Column, fmt.Sprintf(format, args...)))
+}
+
+// decodeError records err, returned when decoding node.
+// yaml.TypeError only includes line numbers, so the column is taken from the first node on that line within node.
+func (p *sourceConfigParser) decodeError(node *yaml.Node, err error) {
+	var typeErr *yaml.TypeError
+	if !errors.As(err, &typeErr) {
+		p.errorf(node, "%v", err)
+		return
+	}
+	for _, msg := range typeErr.Errors {
+		pos := node
+		if match := yamlErrorLineRegexp.FindStringSubmatch(msg); match != nil {
+			msg = match[2]
+			if line, err := strconv.Atoi(match[1]); err == nil {
+				if n := nodeAtLine(node, line); n != nil {
+					pos = n
+				}
+			}
+		}
+		p.errorf(pos, "%s", msg)
+	}
+}
+
+// nodeAtLine returns the first node within node (in document order) at line, or nil if there is none.
+func nodeAtLine(node *yaml.Node, line int) *yaml.Node {
+	if node.Line == line {
+		return node
+	}
+	for _, child := range node.Content {
+		if n := nodeAtLine(child, line); n != nil {
+			return n
+		}
+	}
+	return nil
+}
+
+// resolveAlias returns the node referred to by node, if it is an alias, or node.
+func resolveAlias(node *yaml.Node) *yaml.Node {
+	for node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	return node
+}
+
+// mappingPairs calls fn for every key and value of node, if it is a mapping, and records an error for duplicate keys.
+func (p *sourceConfigParser) mappingPairs(node *yaml.Node, fn func(key, value *yaml.Node)) {
+	node = resolveAlias(node)
+	if node.Kind != yaml.MappingNode {
+		return
+	}
+	seen := map[string]struct{}{}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], resolveAlias(node.Content[i+1])
+		if _, ok := seen[key.Value]; ok {
+			p.errorf(key, "duplicate key %q", key.Value)
+			continue
+		}
+		seen[key.Value] = struct{}{}
+		fn(key, value)
+	}
+}
+
+// sequenceItems calls fn for every item of node, if it is a sequence.
+func sequenceItems(node *yaml.Node, fn func(item *yaml.Node)) {
+	node = resolveAlias(node)
+	if node.Kind != yaml.SequenceNode {
+		return
+	}
+	for _, item := range node.Content {
+		fn(resolveAlias(item))
+	}
+}
+
+// parse parses the contents of a sync YAML file.
+func (p *sourceConfigParser) parse(data []byte) (sourceConfig, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal %q: %w", p.file, err)
+	}
+	cfg := sourceConfig{}
+	if len(root.Content) == 0 { // An empty document
+		return cfg, nil
+	}
+	doc := resolveAlias(root.Content[0])
+	if doc.Kind != yaml.MappingNode {
+		if doc.Tag != "!!null" {
+			p.errorf(doc, "expected a mapping of registry names to their configuration")
+		}
+		return nil, &sourceConfigError{errs: p.errs}
+	}
+	p.mappingPairs(doc, func(key, value *yaml.Node) {
+		cfg[key.Value] = p.registry(key, value)
+	})
+	if len(p.errs) != 0 {
+		return nil, &sourceConfigError{errs: p.errs}
+	}
+	return cfg, nil
+}
+
+// registry parses and validates the configuration of a registry in value, with the registry name in key.
+func (p *sourceConfigParser) registry(key, value *yaml.Node) registrySyncConfig {
+	registryName := key.Value
+	validName := true
+	// reference.ParseNormalizedNamed replaces the legacy index.docker.io with docker.io
+	if named, err := reference.ParseNormalizedNamed(registryName + "/repository"); err != nil ||
+		(reference.Domain(named) != registryName && registryName != "index.docker.io") {
+		p.errorf(key, "invalid registry name %q", registryName)
+		validName = false // Don't report every repository name as invalid as well
+	}
+	var cfg registrySyncConfig
+	if value.Kind != yaml.MappingNode {
+		if value.Tag != "!!null" {
+			p.errorf(value, "expected a mapping with the configuration of registry %s", registryName)
+		}
+		return cfg
+	}
+	p.mappingPairs(value, func(key, value *yaml.Node) {
+		merge := key.Tag == "!!merge" // A << key, merging another mapping
+		if _, ok := registrySyncConfigKeys[key.Value]; !ok && !merge {
+			p.errorf(key, "unknown key %q in the configuration of registry %s", key.Value, registryName)
+			return
+		}
+		// Decode each key separately, so that an error does not prevent validating the other keys.
+		if err := (&yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Content: []*yaml.Node{key, value}}).Decode(&cfg); err != nil {
+			p.decodeError(value, err)
+			return
+		}
+		if !merge && validName {
+			p.validate(registryName, &cfg, key.Value, value)
+		}
+	})
+	return cfg
+}
+
+// validate validates the value of key, already decoded into cfg.
+func (p *sourceConfigParser) validate(registryName string, cfg *registrySyncConfig, key string, value *yaml.Node) {
+	repository := func(node *yaml.Node) reference.Named {
+		named, err := parseRepositoryReference(fmt.Sprintf("%s/%s", registryName, node.Value))
+		if err != nil {
+			p.errorf(node, "invalid repository name %q: %v", node.Value, err)
+			return nil
+		}
+		return named
+	}
+	knownKeys := func(node *yaml.Node, keys ...string) {
+		p.mappingPairs(node, func(key, _ *yaml.Node) {
+			if !slices.Contains(keys, key.Value) {
+				p.errorf(key, "unknown key %q, expected one of %s", key.Value, strings.Join(keys, ", "))
+			}
+		})
+	}
+	regex := func(node *yaml.Node) {
+		if _, err := regexp.Compile(node.Value); err != nil {
+			p.errorf(node, "invalid regular expression %q: %v", node.Value, err)
+		}
+	}
+	platform := func(node *yaml.Node) {
+		if _, err := parsePlatform(node.Value); err != nil {
+			p.errorf(node, "%v", err)
+		}
+	}
+	destination := func(node *yaml.Node) {
+		if err := validateDestinationTemplate(node.Value); err != nil {
+			p.errorf(node, "%v", err)
+		}
+	}
+
+	switch key {
+	case "images":
+		p.mappingPairs(value, func(repo, refs *yaml.Node) {
+			named := repository(repo)
+			if named == nil {
+				return
+			}
+			sequenceItems(refs, func(ref *yaml.Node) {
+				if _, err := digest.Parse(ref.Value); err == nil {
+					return
+				}
+				if _, err := reference.WithTag(named, ref.Value); err != nil {
+					p.errorf(ref, "invalid tag or digest %q", ref.Value)
+				}
+			})
+		})
+	case "images-by-tag-regex", "images-by-tag-regex-exclude":
+		p.mappingPairs(value, func(repo, tagRegex *yaml.Node) {
+			repository(repo)
+			regex(tagRegex)
+		})
+	case "images-by-semver":
+		p.mappingPairs(value, func(repo, constraint *yaml.Node) {
+			repository(repo)
+			if _, err := semver.NewConstraint(constraint.Value); err != nil {
+				p.errorf(constraint, "invalid semantic version constraint %q: %v", constraint.Value, err)
+			}
+		})
+	case "repositories-by-regex":
+		p.mappingPairs(value, func(repoRegex, tagRegex *yaml.Node) {
+			regex(repoRegex)
+			regex(tagRegex)
+		})
+	case "platforms":
+		sequenceItems(value, platform)
+	case "image-platforms":
+		p.mappingPairs(value, func(repo, platforms *yaml.Node) {
+			repository(repo)
+			if len(platforms.Content) == 0 {
+				p.errorf(platforms, "no platforms specified for %s", repo.Value)
+			}
+			sequenceItems(platforms, platform)
+		})
+	case "max-tags":
+		if cfg.MaxTags < 0 {
+			p.errorf(value, "invalid value %d, expected a non-negative number", cfg.MaxTags)
+		}
+	case "newer-than":
+		if _, err := parseNewerThan(cfg.NewerThan, time.Now()); err != nil {
+			p.errorf(value, "%v", err)
+		}
+	case "destination":
+		destination(value)
+	case "image-destinations":
+		p.mappingPairs(value, func(repo, template *yaml.Node) {
+			repository(repo)
+			destination(template)
+		})
+	case "credentials":
+		knownKeys(value, "username", "password", "identitytoken")
+	case "destination-rewrites":
+		sequenceItems(value, func(rewrite *yaml.Node) {
+			knownKeys(rewrite, "regex", "replacement")
+			p.mappingPairs(rewrite, func(key, value *yaml.Node) {
+				if key.Value == "regex" {
+					regex(value)
+				}
+			})
+		})
+		for i, rewrite := range cfg.DestinationRewrites {
+			if rewrite.Regex == "" {
+				p.errorf(resolveAlias(value).Content[i], "regex must be specified")
+			}
+		}
+	}
+}
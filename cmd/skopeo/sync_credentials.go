@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	helperclient "github.com/docker/docker-credential-helpers/client"
+	"go.podman.io/image/v5/types"
+	"gopkg.in/yaml.v3"
+)
+
+// credentialsConfig is an implementation of the Unmarshaler interface, used to
+// customize the unmarshaling behaviour of the credentials YAML key.
+// The key is either a mapping with a username and password, or a string referring to
+// the credentials: env:VAR or file:/path, containing USERNAME[:PASSWORD], or helper:NAME, a docker credential helper.
+type credentialsConfig struct {
+	inline types.DockerAuthConfig // Credentials specified directly in the YAML file
+	ref    string                 // A reference to the credentials, or "" if inline is used
+}
+
+func (c *credentialsConfig) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		var ref string
+		if err := value.Decode(&ref); err != nil {
+			return err
+		}
+		if err := validateCredentialsReference(ref); err != nil {
+			return err
+		}
+		*c = credentialsConfig{ref: ref}
+		return nil
+	}
+	var inline types.DockerAuthConfig
+	if err := value.Decode(&inline); err != nil {
+		return err
+	}
+	*c = credentialsConfig{inline: inline}
+	return nil
+}
+
+// validateCredentialsReference returns an error if ref is not a valid credentials reference.
+func validateCredentialsReference(ref string) error {
+	kind, value, ok := strings.Cut(ref, ":")
+	if !ok || value == "" || (kind != "env" && kind != "file" && kind != "helper") {
+		return fmt.Errorf("invalid credentials %q, expected env:VAR, file:PATH or helper:NAME", ref)
+	}
+	return nil
+}
+
+// resolveSecret returns the value of a secret specified in the YAML file: env:VAR and file:PATH are replaced by the contents
+// of the environment variable or file, with any trailing newline removed, other values are used literally.
+func resolveSecret(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, "env:"):
+		name := strings.TrimPrefix(value, "env:")
+		res, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return res, nil
+	case strings.HasPrefix(value, "file:"):
+		contents, err := os.ReadFile(strings.TrimPrefix(value, "file:"))
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(contents), "\r\n"), nil
+	default:
+		return value, nil
+	}
+}
+
+// resolve returns the credentials for registryName, or nil if none were specified.
+func (c *credentialsConfig) resolve(registryName string) (*types.DockerAuthConfig, error) {
+	if c.ref == "" {
+		if c.inline == (types.DockerAuthConfig{}) {
+			return nil, nil
+		}
+		auth := c.inline
+		return &auth, nil
+	}
+
+	if helper, ok := strings.CutPrefix(c.ref, "helper:"); ok {
+		creds, err := helperclient.Get(helperclient.NewShellProgramFunc("docker-credential-"+helper), registryName)
+		if err != nil {
+			return nil, fmt.Errorf("getting credentials from credential helper %s: %w", helper, err)
+		}
+		if creds.Username == "<token>" {
+			return &types.DockerAuthConfig{IdentityToken: creds.Secret}, nil
+		}
+		return &types.DockerAuthConfig{Username: creds.Username, Password: creds.Secret}, nil
+	}
+	creds, err := resolveSecret(c.ref)
+	if err != nil {
+		return nil, err
+	}
+	if creds == "" {
+		return nil, errors.New("credentials are empty")
+	}
+	return getDockerAuth(creds)
+}
+
+// applyAuthentication sets the authentication options of cfg (credentials, authfile, registry-token, identity-token) in sys.
+func (cfg *registrySyncConfig) applyAuthentication(registryName string, sys *types.SystemContext) error {
+	if cfg.AuthFile != "" {
+		sys.AuthFilePath = cfg.AuthFile
+	}
+	auth, err := cfg.Credentials.resolve(registryName)
+	if err != nil {
+		return fmt.Errorf("registry %s: credentials: %w", registryName, err)
+	}
+	if cfg.IdentityToken != "" {
+		token, err := resolveSecret(cfg.IdentityToken)
+		if err != nil {
+			return fmt.Errorf("registry %s: identity-token: %w", registryName, err)
+		}
+		if auth != nil {
+			return fmt.Errorf("registry %s: credentials and identity-token cannot be specified at the same time", registryName)
+		}
+		auth = &types.DockerAuthConfig{IdentityToken: token}
+	}
+	if auth != nil {
+		sys.DockerAuthConfig = auth
+	}
+	if cfg.RegistryToken != "" {
+		token, err := resolveSecret(cfg.RegistryToken)
+		if err != nil {
+			return fmt.Errorf("registry %s: registry-token: %w", registryName, err)
+		}
+		sys.DockerBearerRegistryToken = token
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"go.podman.io/image/v5/docker/reference"
+	"go.podman.io/image/v5/types"
+)
+
+// destinationRewriteConfig is a single element of the destination-rewrites YAML key.
+type destinationRewriteConfig struct {
+	Regex       string `yaml:"regex"`       // Regular expression matched against the destination name
+	Replacement string `yaml:"replacement"` // Replacement of the matched text, may refer to submatches as $1 or ${name}
+}
+
+// destinationRewrite is a parsed destinationRewriteConfig.
+type destinationRewrite struct {
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+// destinationMapping computes destination names of images from a YAML source.
+type destinationMapping struct {
+	template string // Template with destinationPlaceholders, or "" to use the default name
+	rewrites []destinationRewrite
+}
+
+// destinationPlaceholders are the placeholders which can be used in destination templates.
+var destinationPlaceholders = []string{"{registry}", "{repository}", "{name}", "{tag}", "{digest}"}
+
+// destinationPlaceholderRegexp matches anything which looks like a placeholder.
+var destinationPlaceholderRegexp = regexp.MustCompile(`\{[^{}]*\}`)
+
+// validateDestinationTemplate returns an error if template uses unknown placeholders.
+func validateDestinationTemplate(template string) error {
+	if template == "" || strings.HasPrefix(template, "/") {
+		return fmt.Errorf("invalid destination template %q", template)
+	}
+	for _, p := range destinationPlaceholderRegexp.FindAllString(template, -1) {
+		if !slices.Contains(destinationPlaceholders, p) {
+			return fmt.Errorf("unknown placeholder %s in %q, expected one of %s", p, template, strings.Join(destinationPlaceholders, ", "))
+		}
+	}
+	return nil
+}
+
+// parseDestinationRewrites parses the destination-rewrites YAML key.
+func parseDestinationRewrites(rewrites []destinationRewriteConfig) ([]destinationRewrite, error) {
+	res := make([]destinationRewrite, 0, len(rewrites))
+	for i, r := range rewrites {
+		if r.Regex == "" {
+			return nil, fmt.Errorf("destination-rewrites[%d]: regex must be specified", i)
+		}
+		pattern, err := regexp.Compile(r.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("destination-rewrites[%d]: %w", i, err)
+		}
+		res = append(res, destinationRewrite{pattern: pattern, replacement: r.Replacement})
+	}
+	return res, nil
+}
+
+// destinationName returns the destination name, relative to the sync destination, for ref, an image from a YAML source.
+// defaultName is the name which would be used without m.
+func (m *destinationMapping) destinationName(ref types.ImageReference, defaultName string) (string, error) {
+	res := defaultName
+	if m.template != "" {
+		named := ref.DockerReference()
+		if named == nil {
+			return "", fmt.Errorf("destination templates are not supported for %s", ref.StringWithinTransport())
+		}
+		tag, digest := "", ""
+		if tagged, ok := named.(reference.Tagged); ok {
+			tag = tagged.Tag()
+		}
+		if digested, ok := named.(reference.Digested); ok {
+			digest = digested.Digest().String()
+		}
+		if strings.Contains(m.template, "{tag}") && tag == "" {
+			return "", fmt.Errorf("destination template %q uses {tag}, but %s has no tag", m.template, named.String())
+		}
+		if strings.Contains(m.template, "{digest}") && digest == "" {
+			return "", fmt.Errorf("destination template %q uses {digest}, but %s has no digest", m.template, named.String())
+		}
+		res = strings.NewReplacer(
+			"{registry}", reference.Domain(named),
+			"{repository}", reference.Path(named),
+			"{name}", path.Base(reference.Path(named)),
+			"{tag}", tag,
+			"{digest}", digest,
+		).Replace(m.template)
+		if !strings.Contains(m.template, "{tag}") && !strings.Contains(m.template, "{digest}") {
+			if tag != "" {
+				res += ":" + tag
+			} else if digest != "" {
+				res += "@" + digest
+			}
+		}
+	}
+	for _, r := range m.rewrites {
+		res = r.pattern.ReplaceAllString(res, r.replacement)
+	}
+	return res, nil
+}
+
+// repoDestinations validates the destination, image-destinations and destination-rewrites keys of cfg, and returns a function
+// which returns the destination mapping for a repository, or nil if none was configured.
+func (cfg *registrySyncConfig) repoDestinations(registryName string) (func(repoName string) *destinationMapping, error) {
+	rewrites, err := parseDestinationRewrites(cfg.DestinationRewrites)
+	if err != nil {
+		return nil, fmt.Errorf("registry %s: %w", registryName, err)
+	}
+	if cfg.Destination != "" {
+		if err := validateDestinationTemplate(cfg.Destination); err != nil {
+			return nil, fmt.Errorf("registry %s: destination: %w", registryName, err)
+		}
+	}
+	for repoName, template := range cfg.ImageDestinations {
+		if err := validateDestinationTemplate(template); err != nil {
+			return nil, fmt.Errorf("registry %s: image-destinations: %s: %w", registryName, repoName, err)
+		}
+	}
+	return func(repoName string) *destinationMapping {
+		template, ok := cfg.ImageDestinations[repoName]
+		if !ok {
+			template = cfg.Destination
+		}
+		if template == "" && len(rewrites) == 0 {
+			return nil
+		}
+		return &destinationMapping{template: template, rewrites: rewrites}
+	}, nil
+}
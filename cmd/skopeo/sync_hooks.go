@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/sirupsen/logrus"
+	"go.podman.io/common/pkg/retry"
+)
+
+// Values of syncHookEvent.Event.
+const (
+	syncHookImageSynced  = "image-synced"  // An image was copied successfully
+	syncHookSyncFinished = "sync-finished" // The sync finished, successfully or not
+)
+
+// syncHookTimeout is the timeout of a single --webhook request.
+const syncHookTimeout = 30 * time.Second
+
+// syncHookSummary is the outcome of a sync, included in syncHookSyncFinished events.
+type syncHookSummary struct {
+	Synced    int    `json:"synced"`
+	Unchanged int    `json:"unchanged"` // Skipped using --state-file or --watch
+	Failed    int    `json:"failed"`
+	Error     string `json:"error,omitempty"`
+}
+
+// syncHookEvent is passed to --on-image-synced in environment variables, and posted to --webhook.
+type syncHookEvent struct {
+	Event       string           `json:"event"`
+	Time        time.Time        `json:"time"`
+	Source      string           `json:"source"`           // The source image, or the SOURCE argument for syncHookSyncFinished
+	Destination string           `json:"destination"`      // The destination image, or the DESTINATION argument for syncHookSyncFinished
+	Digest      digest.Digest    `json:"digest,omitempty"` // The manifest digest of the destination image
+	Summary     *syncHookSummary `json:"summary,omitempty"`
+}
+
+// validateSyncHooks returns an error if the --on-image-synced or --webhook values are invalid.
+func (opts *syncOptions) validateSyncHooks() error {
+	if opts.webhook != "" {
+		u, err := url.Parse(opts.webhook)
+		if err != nil {
+			return fmt.Errorf("invalid --webhook %q: %w", opts.webhook, err)
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid --webhook %q, expected an http or https URL", opts.webhook)
+		}
+	}
+	return nil
+}
+
+// runSyncHooks runs --on-image-synced and posts to --webhook, if set, for event.
+// Failures are only logged with --ignore-hook-failures.
+func (opts *syncOptions) runSyncHooks(ctx context.Context, event syncHookEvent) error {
+	if opts.onImageSynced == "" && opts.webhook == "" {
+		return nil
+	}
+	event.Time = time.Now().UTC()
+	var errs []error
+	if opts.onImageSynced != "" {
+		if err := runSyncHookCommand(ctx, opts.onImageSynced, event); err != nil {
+			errs = append(errs, fmt.Errorf("running --on-image-synced for %s event: %w", event.Event, err))
+		}
+	}
+	if opts.webhook != "" {
+		if err := retry.IfNecessary(ctx, func() error {
+			return postSyncHookEvent(ctx, opts.webhook, event)
+		}, opts.retryOpts); err != nil {
+			errs = append(errs, fmt.Errorf("posting %s event to --webhook: %w", event.Event, err))
+		}
+	}
+	err := errors.Join(errs...)
+	if err != nil && opts.ignoreHookFailures {
+		logrus.Warn(err)
+		return nil
+	}
+	return err
+}
+
+// runSyncHookCommand runs command using the shell, with event in SKOPEO_SYNC_* environment variables.
+// The output of command is written to stderr.
+func runSyncHookCommand(ctx context.Context, command string, event syncHookEvent) error {
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", command)
+	cmd.Env = append(os.Environ(),
+		"SKOPEO_SYNC_EVENT="+event.Event,
+		"SKOPEO_SYNC_SOURCE="+event.Source,
+		"SKOPEO_SYNC_DESTINATION="+event.Destination,
+		"SKOPEO_SYNC_DIGEST="+event.Digest.String(),
+	)
+	if event.Summary != nil {
+		cmd.Env = append(cmd.Env,
+			"SKOPEO_SYNC_SYNCED="+strconv.Itoa(event.Summary.Synced),
+			"SKOPEO_SYNC_UNCHANGED="+strconv.Itoa(event.Summary.Unchanged),
+			"SKOPEO_SYNC_FAILED="+strconv.Itoa(event.Summary.Failed),
+			"SKOPEO_SYNC_ERROR="+event.Summary.Error,
+		)
+	}
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// postSyncHookEvent posts event as JSON to webhook.
+func postSyncHookEvent(ctx context.Context, webhook string, event syncHookEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, syncHookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", defaultUserAgent)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s returned status %s", webhook, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/sirupsen/logrus"
+	"go.podman.io/common/pkg/retry"
+	"go.podman.io/image/v5/docker"
+	"go.podman.io/image/v5/docker/reference"
+	"go.podman.io/image/v5/transports"
+	"go.podman.io/image/v5/types"
+	"go.podman.io/storage/pkg/ioutils"
+)
+
+// syncLockfileVersion is the current format version of --lockfile-out.
+const syncLockfileVersion = 1
+
+// syncLockedImage is a single image of a lockfile.
+type syncLockedImage struct {
+	Registry   string        `json:"registry"`      // The registry in the YAML source
+	Repository string        `json:"repository"`    // The repository in the YAML source, relative to Registry
+	Tag        string        `json:"tag,omitempty"` // Omitted for images specified by digest
+	Digest     digest.Digest `json:"digest"`        // The manifest digest
+}
+
+// syncLockfile is the contents of --lockfile-out and --lockfile.
+type syncLockfile struct {
+	Version int               `json:"version"`
+	Images  []syncLockedImage `json:"images"`
+}
+
+// loadSyncLockfile reads the lockfile at path.
+func loadSyncLockfile(path string) (*syncLockfile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading lockfile: %w", err)
+	}
+	var lockfile syncLockfile
+	if err := json.Unmarshal(data, &lockfile); err != nil {
+		return nil, fmt.Errorf("parsing lockfile %q: %w", path, err)
+	}
+	if lockfile.Version != syncLockfileVersion {
+		return nil, fmt.Errorf("lockfile %q has unsupported version %d", path, lockfile.Version)
+	}
+	return &lockfile, nil
+}
+
+// write writes the lockfile to path.
+func (l *syncLockfile) write(path string) error {
+	data, err := json.MarshalIndent(l, "", "    ")
+	if err != nil {
+		return err
+	}
+	if err := ioutils.AtomicWriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("writing lockfile: %w", err)
+	}
+	return nil
+}
+
+// lockSourceImages resolves the manifest digests of all images in srcRepoList, read from a YAML source,
+// sets the Digests of each element of srcRepoList, so that exactly those images are copied, and returns a lockfile with them.
+func lockSourceImages(ctx context.Context, retryOpts *retry.Options, srcRepoList []repoDescriptor) (*syncLockfile, error) {
+	lockfile := &syncLockfile{Version: syncLockfileVersion, Images: []syncLockedImage{}}
+	for i := range srcRepoList {
+		srcRepo := &srcRepoList[i]
+		srcRepo.Digests = make([]digest.Digest, 0, len(srcRepo.ImageRefs))
+		for _, ref := range srcRepo.ImageRefs {
+			image := syncLockedImage{Registry: srcRepo.Registry, Repository: srcRepo.Repository}
+			switch named := ref.DockerReference().(type) {
+			case reference.Digested:
+				image.Digest = named.Digest()
+			case reference.NamedTagged:
+				image.Tag = named.Tag()
+				if err := retry.IfNecessary(ctx, func() error {
+					var err error
+					image.Digest, err = manifestDigest(ctx, srcRepo.Context, ref)
+					return err
+				}, retryOpts); err != nil {
+					return nil, fmt.Errorf("reading manifest digest of %s: %w", transports.ImageName(ref), err)
+				}
+			default:
+				return nil, fmt.Errorf("internal error: %s has neither a tag nor a digest", transports.ImageName(ref))
+			}
+			srcRepo.Digests = append(srcRepo.Digests, image.Digest)
+			lockfile.Images = append(lockfile.Images, image)
+		}
+	}
+	slices.SortFunc(lockfile.Images, func(a, b syncLockedImage) int {
+		return cmp.Or(cmp.Compare(a.Registry, b.Registry), cmp.Compare(a.Repository, b.Repository),
+			cmp.Compare(a.Tag, b.Tag), cmp.Compare(a.Digest, b.Digest))
+	})
+	return lockfile, nil
+}
+
+// repoDescriptors returns repository descriptors for the images of the lockfile, with Digests set,
+// using the configuration of their registries in yamlFile.
+func (l *syncLockfile) repoDescriptors(yamlFile string, sourceCtx *types.SystemContext) ([]repoDescriptor, error) {
+	cfg, err := newSourceConfig(yamlFile)
+	if err != nil {
+		return nil, err
+	}
+	type repoKey struct{ registry, repository string }
+	repoDescriptorFuncs := map[string]func(repoName string, refs []types.ImageReference) repoDescriptor{}
+	repoIndexes := map[repoKey]int{} // Indexes of the descriptors in res
+	var res []repoDescriptor
+	for _, image := range l.Images {
+		newRepoDescriptor, ok := repoDescriptorFuncs[image.Registry]
+		if !ok {
+			registryConfig, ok := cfg[image.Registry]
+			if !ok {
+				return nil, fmt.Errorf("registry %s in lockfile is not configured in %q", image.Registry, yamlFile)
+			}
+			_, newRepoDescriptor, err = registryConfig.repoDescriptorFunc(image.Registry, *sourceCtx)
+			if err != nil {
+				return nil, err
+			}
+			repoDescriptorFuncs[image.Registry] = newRepoDescriptor
+		}
+
+		repoRef, err := parseRepositoryReference(fmt.Sprintf("%s/%s", image.Registry, image.Repository))
+		if err != nil {
+			return nil, fmt.Errorf("invalid repository %s/%s in lockfile: %w", image.Registry, image.Repository, err)
+		}
+		if err := image.Digest.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid digest of %s in lockfile: %w", repoRef.Name(), err)
+		}
+		var named reference.Named
+		if image.Tag != "" {
+			named, err = reference.WithTag(repoRef, image.Tag)
+		} else {
+			named, err = reference.WithDigest(repoRef, image.Digest)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid image %s in lockfile: %w", repoRef.Name(), err)
+		}
+		ref, err := docker.NewReference(named)
+		if err != nil {
+			return nil, err
+		}
+
+		key := repoKey{image.Registry, image.Repository}
+		index, ok := repoIndexes[key]
+		if !ok {
+			index = len(res)
+			repoIndexes[key] = index
+			res = append(res, newRepoDescriptor(image.Repository, nil))
+		}
+		res[index].ImageRefs = append(res[index].ImageRefs, ref)
+		res[index].Digests = append(res[index].Digests, image.Digest)
+	}
+	return res, nil
+}
+
+// verifyLockedSources returns an error if any image in srcRepoList, read from a lockfile, is no longer available at the source.
+func verifyLockedSources(ctx context.Context, retryOpts *retry.Options, srcRepoList []repoDescriptor) error {
+	missing := 0
+	for _, srcRepo := range srcRepoList {
+		for i := range srcRepo.ImageRefs {
+			ref, err := srcRepo.sourceReference(i)
+			if err != nil {
+				return err
+			}
+			if err := retry.IfNecessary(ctx, func() error {
+				_, err := manifestDigest(ctx, srcRepo.Context, ref)
+				return err
+			}, retryOpts); err != nil {
+				logrus.Errorf("Locked image %s is not available at the source: %v", transports.ImageName(ref), err)
+				missing++
+			}
+		}
+	}
+	if missing != 0 {
+		return fmt.Errorf("Refusing to sync, %d locked images are no longer available at the source", missing)
+	}
+	return nil
+}
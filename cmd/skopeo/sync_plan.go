@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/sirupsen/logrus"
+	"go.podman.io/common/pkg/retry"
+	"go.podman.io/image/v5/directory"
+	"go.podman.io/image/v5/docker"
+	"go.podman.io/image/v5/docker/reference"
+	ocilayout "go.podman.io/image/v5/oci/layout"
+	"go.podman.io/image/v5/transports"
+	"go.podman.io/image/v5/transports/alltransports"
+	"go.podman.io/image/v5/types"
+	"go.podman.io/storage/pkg/ioutils"
+)
+
+// syncPlanVersion is the current format version of --plan-out.
+const syncPlanVersion = 1
+
+// syncPlanSource identifies the source of a sync plan.
+type syncPlanSource struct {
+	Transport string `json:"transport"` // --src
+	Source    string `json:"source"`    // The SOURCE argument
+}
+
+// syncPlanImage is a single image copy of a sync plan.
+type syncPlanImage struct {
+	Source       string        `json:"source"`                 // transports.ImageName of the source
+	PinnedSource string        `json:"pinnedSource,omitempty"` // transports.ImageName of the source by digest, for docker sources
+	SourceDigest digest.Digest `json:"sourceDigest"`
+	Destination  string        `json:"destination"`        // transports.ImageName of the destination
+	Registry     string        `json:"registry,omitempty"` // The registry in a YAML source whose configuration is used to access the source
+	// The copy options the plan was created with, including the platforms configured in a YAML source; applying the plan requires the same options.
+	Settings syncCopySettings `json:"settings"`
+}
+
+// syncPlanPrune is a destination tag deleted by a sync plan.
+type syncPlanPrune struct {
+	Destination string        `json:"destination"` // transports.ImageName of the tag
+	Digest      digest.Digest `json:"digest"`      // Manifest digest of the tag when the plan was created
+}
+
+// syncPlan is the contents of --plan-out and --plan-in.
+type syncPlan struct {
+	Version int             `json:"version"`
+	Created time.Time       `json:"created"`
+	Source  syncPlanSource  `json:"source"`
+	Target  syncTarget      `json:"target"`
+	Images  []syncPlanImage `json:"images"`
+	Prunes  []syncPlanPrune `json:"prunes,omitempty"`
+}
+
+// newSyncPlan returns an empty plan for a sync from source to target.
+func newSyncPlan(source syncPlanSource, target syncTarget) *syncPlan {
+	return &syncPlan{
+		Version: syncPlanVersion,
+		Created: time.Now().UTC(),
+		Source:  source,
+		Target:  target,
+		Images:  []syncPlanImage{},
+	}
+}
+
+// loadSyncPlan reads the plan at path.
+func loadSyncPlan(path string) (*syncPlan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading plan: %w", err)
+	}
+	var plan syncPlan
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return nil, fmt.Errorf("parsing plan %q: %w", path, err)
+	}
+	if plan.Version != syncPlanVersion {
+		return nil, fmt.Errorf("plan %q has unsupported version %d", path, plan.Version)
+	}
+	return &plan, nil
+}
+
+// write writes the plan to path.
+func (p *syncPlan) write(path string) error {
+	data, err := json.MarshalIndent(p, "", "    ")
+	if err != nil {
+		return err
+	}
+	if err := ioutils.AtomicWriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("writing plan: %w", err)
+	}
+	return nil
+}
+
+// addImage adds a copy of ref, from srcRepo, to destName, using settings, to the plan.
+// sourceDigest is the manifest digest of ref, or "" if it is not known yet.
+func (p *syncPlan) addImage(ctx context.Context, retryOpts *retry.Options, srcRepo repoDescriptor, ref types.ImageReference,
+	sourceDigest digest.Digest, destName string, settings syncCopySettings,
+) error {
+	if sourceDigest == "" {
+		if err := retry.IfNecessary(ctx, func() error {
+			var err error
+			sourceDigest, err = manifestDigest(ctx, srcRepo.Context, ref)
+			return err
+		}, retryOpts); err != nil {
+			return fmt.Errorf("reading manifest digest of %s: %w", transports.ImageName(ref), err)
+		}
+	}
+	image := syncPlanImage{
+		Source:       transports.ImageName(ref),
+		SourceDigest: sourceDigest,
+		Destination:  destName,
+		Registry:     srcRepo.Registry,
+		Settings:     settings,
+	}
+	if ref.Transport() == docker.Transport {
+		pinned, err := reference.WithDigest(reference.TrimNamed(ref.DockerReference()), sourceDigest)
+		if err != nil {
+			return err
+		}
+		pinnedRef, err := docker.NewReference(pinned)
+		if err != nil {
+			return err
+		}
+		image.PinnedSource = transports.ImageName(pinnedRef)
+	}
+	p.Images = append(p.Images, image)
+	return nil
+}
+
+// addPrunes adds deletions of refs to the plan.
+func (p *syncPlan) addPrunes(ctx context.Context, sys *types.SystemContext, retryOpts *retry.Options, refs []types.ImageReference) error {
+	for _, ref := range refs {
+		var d digest.Digest
+		if err := retry.IfNecessary(ctx, func() error {
+			var err error
+			d, err = manifestDigest(ctx, sys, ref)
+			return err
+		}, retryOpts); err != nil {
+			return fmt.Errorf("reading manifest digest of %s: %w", transports.ImageName(ref), err)
+		}
+		p.Prunes = append(p.Prunes, syncPlanPrune{Destination: transports.ImageName(ref), Digest: d})
+	}
+	return nil
+}
+
+// parsePlannedReference parses name, a reference in the plan, and verifies that it uses transport.
+func parsePlannedReference(name, transport string) (types.ImageReference, error) {
+	ref, err := alltransports.ParseImageName(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid reference %q in plan: %w", name, err)
+	}
+	if ref.Transport().Name() != transport {
+		return nil, fmt.Errorf("invalid reference %q in plan, expected transport %q", name, transport)
+	}
+	return ref, nil
+}
+
+// repoDescriptors returns repository descriptors for the images of the plan, with Planned set.
+func (p *syncPlan) repoDescriptors(sourceCtx *types.SystemContext) ([]repoDescriptor, error) {
+	srcTransport := p.Source.Transport
+	var yamlConfig sourceConfig
+	if srcTransport == "yaml" {
+		srcTransport = docker.Transport.Name()
+		cfg, err := newSourceConfig(p.Source.Source)
+		if err != nil {
+			return nil, err
+		}
+		yamlConfig = cfg
+	}
+
+	registryContexts := map[string]*types.SystemContext{}
+	var res []repoDescriptor
+	for i := range p.Images {
+		image := &p.Images[i]
+		name := image.Source
+		if image.PinnedSource != "" {
+			name = image.PinnedSource
+		}
+		ref, err := parsePlannedReference(name, srcTransport)
+		if err != nil {
+			return nil, err
+		}
+		sys := sourceCtx
+		if image.Registry != "" {
+			cfg, ok := yamlConfig[image.Registry]
+			if !ok {
+				return nil, fmt.Errorf("registry %s in plan is not configured in %q", image.Registry, p.Source.Source)
+			}
+			if sys, ok = registryContexts[image.Registry]; !ok {
+				sys, err = cfg.systemContext(image.Registry, *sourceCtx)
+				if err != nil {
+					return nil, err
+				}
+				registryContexts[image.Registry] = sys
+			}
+		}
+		platforms, err := parseSyncPlatforms(image.Settings.Platforms)
+		if err != nil {
+			return nil, fmt.Errorf("invalid platforms of %s in plan: %w", image.Source, err)
+		}
+		res = append(res, repoDescriptor{
+			ImageRefs: []types.ImageReference{ref},
+			Context:   sys,
+			Platforms: platforms,
+			Registry:  image.Registry,
+			Planned:   []*syncPlanImage{image},
+		})
+	}
+	return res, nil
+}
+
+// verifyPlannedSources returns an error if the manifest of any planned source image in srcRepoList changed since the plan was created.
+func verifyPlannedSources(ctx context.Context, retryOpts *retry.Options, srcTransport string, srcRepoList []repoDescriptor) error {
+	if srcTransport == "yaml" {
+		srcTransport = docker.Transport.Name()
+	}
+	var changed []string
+	for _, srcRepo := range srcRepoList {
+		for _, image := range srcRepo.Planned {
+			ref, err := parsePlannedReference(image.Source, srcTransport)
+			if err != nil {
+				return err
+			}
+			var d digest.Digest
+			if err := retry.IfNecessary(ctx, func() error {
+				var err error
+				d, err = manifestDigest(ctx, srcRepo.Context, ref)
+				return err
+			}, retryOpts); err != nil {
+				return fmt.Errorf("Refusing to apply the plan, reading manifest digest of %s: %w", image.Source, err)
+			}
+			if d != image.SourceDigest {
+				logrus.Errorf("Source %s changed since the plan was created: planned %s, now %s", image.Source, image.SourceDigest, d)
+				changed = append(changed, image.Source)
+			}
+		}
+	}
+	if len(changed) != 0 {
+		return fmt.Errorf("Refusing to apply the plan, %d source images changed since it was created", len(changed))
+	}
+	return nil
+}
+
+// verifyPlannedSettings returns an error if the copy options of opts differ from those the planned images in srcRepoList were planned with.
+func (opts *syncOptions) verifyPlannedSettings(srcRepoList []repoDescriptor) error {
+	for _, srcRepo := range srcRepoList {
+		settings := opts.copySettings(srcRepo)
+		for _, image := range srcRepo.Planned {
+			if !settings.equal(image.Settings) {
+				planned, err := json.Marshal(image.Settings)
+				if err != nil {
+					return err
+				}
+				current, err := json.Marshal(settings)
+				if err != nil {
+					return err
+				}
+				return fmt.Errorf("Refusing to apply the plan, it was created with different copy options: planned %s, now %s", planned, current)
+			}
+		}
+	}
+	return nil
+}
+
+// plannedDestinationReference returns a reference to name, the destination of a planned image, in target.
+func plannedDestinationReference(target syncTarget, name string) (types.ImageReference, error) {
+	switch target.Transport {
+	case directory.Transport.Name():
+		path, ok := strings.CutPrefix(name, directory.Transport.Name()+":")
+		if !ok {
+			return nil, fmt.Errorf("invalid reference %q in plan, expected transport %q", name, target.Transport)
+		}
+		return destinationReference(path, target.Transport)
+	case ocilayout.Transport.Name():
+		if err := os.MkdirAll(target.Destination, 0o755); err != nil {
+			return nil, fmt.Errorf("Error creating OCI layout directory %s: %w", target.Destination, err)
+		}
+	}
+	return parsePlannedReference(name, target.Transport)
+}
+
+// applyPrunes deletes the planned prunes.
+// Tags which changed since the plan was created, or which refer to one of copiedDigests, are not deleted.
+// It returns the deleted tags.
+func (p *syncPlan) applyPrunes(ctx context.Context, sys *types.SystemContext, retryOpts *retry.Options,
+	copiedDigests map[digest.Digest]struct{}, keepGoing bool,
+) ([]types.ImageReference, error) {
+	var refs []types.ImageReference
+	var errs []error
+	for _, prune := range p.Prunes {
+		ref, err := parsePlannedReference(prune.Destination, p.Target.Transport)
+		if err != nil {
+			return nil, err
+		}
+		var d digest.Digest
+		if err := retry.IfNecessary(ctx, func() error {
+			var err error
+			d, err = manifestDigest(ctx, sys, ref)
+			return err
+		}, retryOpts); err != nil {
+			errs = append(errs, fmt.Errorf("Refusing to prune %s, reading its manifest digest: %w", prune.Destination, err))
+			continue
+		}
+		if d != prune.Digest {
+			errs = append(errs, fmt.Errorf("Refusing to prune %s, it changed since the plan was created", prune.Destination))
+			continue
+		}
+		if _, ok := copiedDigests[d]; ok && ref.Transport() == docker.Transport {
+			// Registries delete manifests, not tags
+			logrus.Warnf("Not pruning %s: it refers to the same manifest as a copied image", prune.Destination)
+			continue
+		}
+		refs = append(refs, ref)
+	}
+	if len(errs) != 0 {
+		if !keepGoing {
+			return nil, errors.Join(errs...)
+		}
+		for _, err := range errs {
+			logrus.Error(err)
+		}
+	}
+	deleted, err := deleteImages(ctx, sys, retryOpts, refs, keepGoing)
+	if err == nil && len(errs) != 0 {
+		err = fmt.Errorf("%d of %d tags could not be pruned", len(errs), len(p.Prunes))
+	}
+	return deleted, err
+}
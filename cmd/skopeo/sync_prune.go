@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/sirupsen/logrus"
+	"go.podman.io/common/pkg/retry"
+	"go.podman.io/image/v5/docker"
+	"go.podman.io/image/v5/docker/reference"
+	ocilayout "go.podman.io/image/v5/oci/layout"
+	"go.podman.io/image/v5/transports"
+	"go.podman.io/image/v5/types"
+)
+
+// pruneRepository is a destination repository written by a sync, as tracked by syncPruner.
+type pruneRepository struct {
+	name     string               // Repository name, e.g. registry.example.com/busybox, or busybox within an OCI layout
+	ref      types.ImageReference // One of the synced images in the repository
+	syncTags map[string]struct{}  // Tags which were synced from the source
+}
+
+// syncPruner collects the destination repositories written by a sync, and deletes the tags
+// in them which do not exist at the source.
+type syncPruner struct {
+	layoutDir string // The destination OCI layout, for --dest oci
+	suffix    string // Only tags with this suffix are considered, for --append-suffix
+	repos     map[string]*pruneRepository
+	order     []string // Keys of repos, in the order they were first seen
+}
+
+// prunedTag is a destination tag which does not exist at the source.
+type prunedTag struct {
+	repo *pruneRepository
+	ref  types.ImageReference
+}
+
+func newSyncPruner(layoutDir, suffix string) *syncPruner {
+	return &syncPruner{
+		layoutDir: layoutDir,
+		suffix:    suffix,
+		repos:     map[string]*pruneRepository{},
+	}
+}
+
+// splitRepositoryTag splits name into a repository and a tag, or returns ok == false if name does not end with a tag.
+func splitRepositoryTag(name string) (repo, tag string, ok bool) {
+	if strings.Contains(name, "@") {
+		return "", "", false
+	}
+	i := strings.LastIndex(name, ":")
+	if i == -1 || strings.Contains(name[i+1:], "/") { // No tag, possibly a registry port
+		return "", "", false
+	}
+	return name[:i], name[i+1:], true
+}
+
+// add records that destRef was synced from the source.
+// Images referenced by digest are ignored; only tags are ever pruned.
+func (p *syncPruner) add(destRef types.ImageReference) {
+	var repoName, tag string
+	switch destRef.Transport() {
+	case docker.Transport:
+		tagged, ok := destRef.DockerReference().(reference.NamedTagged)
+		if !ok {
+			return
+		}
+		if _, ok := tagged.(reference.Digested); ok {
+			return
+		}
+		repoName, tag = tagged.Name(), tagged.Tag()
+	case ocilayout.Transport:
+		var ok bool
+		repoName, tag, ok = splitRepositoryTag(ociReferenceName(destRef))
+		if !ok {
+			return
+		}
+	default:
+		return
+	}
+	repo, ok := p.repos[repoName]
+	if !ok {
+		repo = &pruneRepository{name: repoName, ref: destRef, syncTags: map[string]struct{}{}}
+		p.repos[repoName] = repo
+		p.order = append(p.order, repoName)
+	}
+	repo.syncTags[tag] = struct{}{}
+}
+
+// destinationTags returns the tags of repo at the destination.
+func (p *syncPruner) destinationTags(ctx context.Context, sys *types.SystemContext, repo *pruneRepository) ([]string, error) {
+	switch repo.ref.Transport() {
+	case docker.Transport:
+		return docker.GetRepositoryTags(ctx, sys, repo.ref)
+	case ocilayout.Transport:
+		images, err := ocilayout.List(p.layoutDir)
+		if err != nil {
+			return nil, err
+		}
+		var res []string
+		for _, image := range images {
+			if repoName, tag, ok := splitRepositoryTag(ociReferenceName(image.Reference)); ok && repoName == repo.name {
+				res = append(res, tag)
+			}
+		}
+		return res, nil
+	default:
+		return nil, fmt.Errorf("pruning is not supported for transport %q", repo.ref.Transport().Name())
+	}
+}
+
+// tagReference returns a reference to tag in repo.
+func (p *syncPruner) tagReference(repo *pruneRepository, tag string) (types.ImageReference, error) {
+	switch repo.ref.Transport() {
+	case docker.Transport:
+		tagged, err := reference.WithTag(reference.TrimNamed(repo.ref.DockerReference()), tag)
+		if err != nil {
+			return nil, err
+		}
+		return docker.NewReference(tagged)
+	case ocilayout.Transport:
+		return ocilayout.NewReference(p.layoutDir, repo.name+":"+tag)
+	default:
+		return nil, fmt.Errorf("pruning is not supported for transport %q", repo.ref.Transport().Name())
+	}
+}
+
+// staleTags returns the tags of the synced repositories at the destination which were not synced from the source.
+func (p *syncPruner) staleTags(ctx context.Context, sys *types.SystemContext, retryOpts *retry.Options) ([]prunedTag, error) {
+	var res []prunedTag
+	for _, repoName := range p.order {
+		repo := p.repos[repoName]
+		var destTags []string
+		if err := retry.IfNecessary(ctx, func() error {
+			var err error
+			destTags, err = p.destinationTags(ctx, sys, repo)
+			return err
+		}, retryOpts); err != nil {
+			return nil, fmt.Errorf("listing tags of %s: %w", repo.name, err)
+		}
+
+		var stale []prunedTag
+		for _, tag := range destTags {
+			if _, ok := repo.syncTags[tag]; ok || !strings.HasSuffix(tag, p.suffix) {
+				continue
+			}
+			ref, err := p.tagReference(repo, tag)
+			if err != nil {
+				return nil, err
+			}
+			stale = append(stale, prunedTag{repo: repo, ref: ref})
+		}
+		if len(stale) != 0 && repo.ref.Transport() == docker.Transport {
+			// Registries delete manifests, not tags: deleting a stale tag would also delete any synced tag of the same image.
+			var err error
+			stale, err = p.withoutSharedManifests(ctx, sys, retryOpts, repo, stale)
+			if err != nil {
+				return nil, err
+			}
+		}
+		res = append(res, stale...)
+	}
+	return res, nil
+}
+
+// withoutSharedManifests returns the subset of stale, tags in a docker repo, which do not refer to the same manifest
+// as one of the synced tags.
+func (p *syncPruner) withoutSharedManifests(ctx context.Context, sys *types.SystemContext, retryOpts *retry.Options,
+	repo *pruneRepository, stale []prunedTag,
+) ([]prunedTag, error) {
+	getDigest := func(ref types.ImageReference) (digest.Digest, error) {
+		var d digest.Digest
+		err := retry.IfNecessary(ctx, func() error {
+			var err error
+			d, err = docker.GetDigest(ctx, sys, ref)
+			return err
+		}, retryOpts)
+		return d, err
+	}
+
+	syncDigests := map[digest.Digest]string{}
+	for tag := range repo.syncTags {
+		ref, err := p.tagReference(repo, tag)
+		if err != nil {
+			return nil, err
+		}
+		d, err := getDigest(ref)
+		if err != nil {
+			// The tag may not exist if the copy failed with --keep-going, or in --dry-run mode.
+			logrus.Debugf("Error reading digest of %s: %v", transports.ImageName(ref), err)
+			continue
+		}
+		syncDigests[d] = tag
+	}
+	var res []prunedTag
+	for _, s := range stale {
+		d, err := getDigest(s.ref)
+		if err != nil {
+			return nil, fmt.Errorf("reading digest of %s: %w", transports.ImageName(s.ref), err)
+		}
+		if tag, ok := syncDigests[d]; ok {
+			logrus.Warnf("Not pruning %s: it refers to the same manifest as the synced tag %q", transports.ImageName(s.ref), tag)
+			continue
+		}
+		res = append(res, s)
+	}
+	return res, nil
+}
+
+// prune deletes the stale tags of all synced repositories, or only logs them with dryRun.
+// It refuses to delete anything if there are more than maxDeletions (unless it is 0) stale tags.
+// It returns the deleted tags, or the tags which would have been deleted with dryRun.
+func (p *syncPruner) prune(ctx context.Context, sys *types.SystemContext, retryOpts *retry.Options,
+	maxDeletions uint, dryRun, keepGoing bool,
+) ([]types.ImageReference, error) {
+	stale, err := p.staleTags(ctx, sys, retryOpts)
+	if err != nil {
+		return nil, err
+	}
+	refs := make([]types.ImageReference, 0, len(stale))
+	for _, s := range stale {
+		if dryRun {
+			logrus.WithField("repository", s.repo.name).Infof("Would have pruned %s", transports.ImageName(s.ref))
+		}
+		refs = append(refs, s.ref)
+	}
+	if maxDeletions != 0 && uint(len(stale)) > maxDeletions {
+		return nil, fmt.Errorf("Refusing to prune %d tags, more than --prune-max-deletions=%d", len(stale), maxDeletions)
+	}
+	if dryRun {
+		return refs, nil
+	}
+	return deleteImages(ctx, sys, retryOpts, refs, keepGoing)
+}
+
+// deleteImages deletes refs, and returns the deleted ones.
+// With keepGoing, it tries to delete all of refs even if some deletions fail.
+func deleteImages(ctx context.Context, sys *types.SystemContext, retryOpts *retry.Options,
+	refs []types.ImageReference, keepGoing bool,
+) ([]types.ImageReference, error) {
+	var deleted []types.ImageReference
+	failed := 0
+	for _, ref := range refs {
+		logrus.Infof("Pruning %s", transports.ImageName(ref))
+		if err := retry.IfNecessary(ctx, func() error {
+			return ref.DeleteImage(ctx, sys)
+		}, retryOpts); err != nil {
+			if !keepGoing {
+				return deleted, fmt.Errorf("Error pruning %q: %w", transports.ImageName(ref), err)
+			}
+			logrus.WithError(err).Errorf("Error pruning %q", transports.ImageName(ref))
+			failed++
+			continue
+		}
+		deleted = append(deleted, ref)
+	}
+	if failed != 0 {
+		return deleted, fmt.Errorf("%d of %d tags could not be pruned", failed, len(refs))
+	}
+	return deleted, nil
+}
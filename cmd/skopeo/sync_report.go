@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"go.podman.io/image/v5/transports"
+)
+
+// Values of (skopeo sync --report-format).
+const (
+	syncReportFormatJSON  = "json"
+	syncReportFormatJUnit = "junit"
+)
+
+// Values of syncImageReport.Status.
+const (
+	syncImageCopied    = "copied"    // The image was copied, transferring at least one blob
+	syncImageUnchanged = "unchanged" // The destination already contained the image: no blobs were transferred, or --state-file recorded the source as unchanged
+	syncImageSkipped   = "skipped"   // The image was not copied because the sync stopped after an error
+	syncImageFailed    = "failed"
+)
+
+// syncImageReport describes the outcome of syncing a single image.
+type syncImageReport struct {
+	Source          string        `json:"source"`
+	Destination     string        `json:"destination,omitempty"` // Omitted if the sync stopped before the destination was determined
+	Status          string        `json:"status"`
+	Error           string        `json:"error,omitempty"`
+	ManifestDigest  digest.Digest `json:"manifestDigest,omitempty"` // Of the destination image
+	BytesCopied     uint64        `json:"bytesCopied"`
+	DurationSeconds float64       `json:"durationSeconds"`
+}
+
+// syncReport is the contents of the file written by (skopeo sync --report).
+type syncReport struct {
+	Source          string            `json:"source"`      // The SOURCE argument
+	Destination     string            `json:"destination"` // The DESTINATION argument
+	StartTime       time.Time         `json:"startTime"`
+	EndTime         time.Time         `json:"endTime"`
+	DurationSeconds float64           `json:"durationSeconds"`
+	Summary         map[string]int    `json:"summary"` // Number of images with each status
+	Images          []syncImageReport `json:"images"`
+}
+
+// newSyncReport returns a syncReport for a sync from source to destination, which started at startTime.
+func newSyncReport(source, destination string, startTime time.Time) *syncReport {
+	return &syncReport{
+		Source:      source,
+		Destination: destination,
+		StartTime:   startTime.UTC(),
+		Summary: map[string]int{
+			syncImageCopied:    0,
+			syncImageUnchanged: 0,
+			syncImageSkipped:   0,
+			syncImageFailed:    0,
+		},
+		Images: []syncImageReport{},
+	}
+}
+
+// add adds image to r.
+func (r *syncReport) add(image syncImageReport) {
+	r.Images = append(r.Images, image)
+	r.Summary[image.Status]++
+}
+
+// write writes r to path, in format.
+func (r *syncReport) write(path, format string) error {
+	r.EndTime = time.Now().UTC()
+	r.DurationSeconds = r.EndTime.Sub(r.StartTime).Seconds()
+	var data []byte
+	var err error
+	switch format {
+	case syncReportFormatJSON:
+		data, err = json.MarshalIndent(r, "", "    ")
+	case syncReportFormatJUnit:
+		data, err = r.junit()
+	default:
+		err = fmt.Errorf("unknown report format %q", format)
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("Failed to write report to file %q: %w", path, err)
+	}
+	return nil
+}
+
+// junitTestSuites is the root element of a JUnit XML report.
+type junitTestSuites struct {
+	XMLName    xml.Name         `xml:"testsuites"`
+	TestSuites []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure"`
+	Skipped   *junitMessage `xml:"skipped"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+}
+
+// junitSeconds formats seconds as a JUnit time attribute.
+func junitSeconds(seconds float64) string {
+	return strconv.FormatFloat(seconds, 'f', 3, 64)
+}
+
+// junit returns r in the JUnit XML format: one test suite for the sync, with a test case for each image.
+func (r *syncReport) junit() ([]byte, error) {
+	suite := junitTestSuite{
+		Name:      fmt.Sprintf("skopeo sync %s %s", r.Source, r.Destination),
+		Tests:     len(r.Images),
+		Failures:  r.Summary[syncImageFailed],
+		Skipped:   r.Summary[syncImageSkipped],
+		Time:      junitSeconds(r.DurationSeconds),
+		Timestamp: r.StartTime.Format(time.RFC3339),
+	}
+	for _, image := range r.Images {
+		testCase := junitTestCase{
+			Name:      image.Source,
+			ClassName: "skopeo.sync",
+			Time:      junitSeconds(image.DurationSeconds),
+		}
+		switch image.Status {
+		case syncImageFailed:
+			testCase.Failure = &junitMessage{Message: image.Error}
+		case syncImageSkipped:
+			testCase.Skipped = &junitMessage{Message: "not copied after an earlier error"}
+		default:
+			testCase.SystemOut = fmt.Sprintf("%s to %s, manifest digest %s, %d bytes copied", image.Status, image.Destination, image.ManifestDigest, image.BytesCopied)
+		}
+		suite.TestCases = append(suite.TestCases, testCase)
+	}
+	data, err := xml.MarshalIndent(junitTestSuites{TestSuites: []junitTestSuite{suite}}, "", "    ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
+
+// addResults adds the outcome of syncing the images of srcRepoList, with results in the same order, to r.
+// dispatchErr is the error which stopped the sync before the first image without a result, if any.
+func (r *syncReport) addResults(srcRepoList []repoDescriptor, results []syncImageResult, dispatchErr error) {
+	index := 0
+	for _, srcRepo := range srcRepoList {
+		for _, ref := range srcRepo.ImageRefs {
+			res := results[index]
+			index++
+			image := syncImageReport{
+				Source:          transports.ImageName(ref),
+				Destination:     res.destName,
+				ManifestDigest:  res.destDigest,
+				BytesCopied:     res.blobs.BytesCopied,
+				DurationSeconds: res.duration.Seconds(),
+			}
+			switch {
+			case res.ref == nil && dispatchErr != nil:
+				image.Status, image.Error = syncImageFailed, dispatchErr.Error()
+				dispatchErr = nil // Only the first image without a result failed
+			case res.ref == nil:
+				image.Status = syncImageSkipped
+			case res.err != nil:
+				image.Status, image.Error = syncImageFailed, res.err.Error()
+			case res.skipped || len(res.blobs.Copied) == 0:
+				image.Status = syncImageUnchanged
+			default:
+				image.Status = syncImageCopied
+			}
+			r.add(image)
+		}
+	}
+}
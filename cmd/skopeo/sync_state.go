@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/sirupsen/logrus"
+	"go.podman.io/image/v5/docker"
+	"go.podman.io/image/v5/manifest"
+	"go.podman.io/image/v5/types"
+	"go.podman.io/storage/pkg/ioutils"
+)
+
+// syncStateVersion is the current format version of --state-file.
+const syncStateVersion = 1
+
+// syncTarget identifies the destination of a sync, as recorded in state files and plans.
+// A state file is only used for syncs with the same target.
+type syncTarget struct {
+	Transport    string `json:"transport"`
+	Destination  string `json:"destination"`
+	Scoped       bool   `json:"scoped"`
+	AppendSuffix string `json:"appendSuffix,omitempty"`
+}
+
+// syncCopySettings are the options affecting the result of copying an image, as recorded in state files.
+type syncCopySettings struct {
+	Platforms       []string `json:"platforms,omitempty"` // Platforms copied from image lists, if set for the repository
+	All             bool     `json:"all,omitempty"`
+	Format          string   `json:"format,omitempty"`
+	PreserveDigests bool     `json:"preserveDigests,omitempty"`
+	// Signing options; signPassphraseFile is not recorded, it does not affect which signatures are created.
+	RemoveSignatures         bool   `json:"removeSignatures,omitempty"`
+	SignByFingerprint        string `json:"signBy,omitempty"`
+	SignBySequoiaFingerprint string `json:"signBySequoiaFingerprint,omitempty"`
+	SignBySigstoreParamFile  string `json:"signBySigstore,omitempty"`
+	SignBySigstorePrivateKey string `json:"signBySigstorePrivateKey,omitempty"`
+	// Referrers are copied separately from the image, so they must be copied again for images synced without them.
+	Referrers              bool     `json:"referrers,omitempty"`
+	ReferrersArtifactTypes []string `json:"referrersArtifactTypes,omitempty"`
+}
+
+// copySettings returns the settings used to copy the images of srcRepo.
+func (opts *syncOptions) copySettings(srcRepo repoDescriptor) syncCopySettings {
+	settings := syncCopySettings{
+		All:                      opts.all,
+		PreserveDigests:          opts.copy.preserveDigests,
+		RemoveSignatures:         opts.copy.removeSignatures,
+		SignByFingerprint:        opts.copy.signByFingerprint,
+		SignBySequoiaFingerprint: opts.copy.signBySequoiaFingerprint,
+		SignBySigstoreParamFile:  opts.copy.signBySigstoreParamFile,
+		SignBySigstorePrivateKey: opts.copy.signBySigstorePrivateKey,
+		Referrers:                opts.copy.referrers,
+		ReferrersArtifactTypes:   opts.copy.referrersArtifactTypes,
+	}
+	if opts.copy.format.Present() {
+		settings.Format = opts.copy.format.Value()
+	}
+	if srcRepo.Platforms != nil {
+		settings.Platforms = srcRepo.Platforms.names
+	}
+	return settings
+}
+
+// equal returns true if s and other are the same settings.
+func (s syncCopySettings) equal(other syncCopySettings) bool {
+	return slices.Equal(s.Platforms, other.Platforms) && s.All == other.All && s.Format == other.Format &&
+		s.PreserveDigests == other.PreserveDigests && s.RemoveSignatures == other.RemoveSignatures &&
+		s.SignByFingerprint == other.SignByFingerprint && s.SignBySequoiaFingerprint == other.SignBySequoiaFingerprint &&
+		s.SignBySigstoreParamFile == other.SignBySigstoreParamFile && s.SignBySigstorePrivateKey == other.SignBySigstorePrivateKey &&
+		s.Referrers == other.Referrers && slices.Equal(s.ReferrersArtifactTypes, other.ReferrersArtifactTypes)
+}
+
+// syncStateImage records a single successfully synced image.
+type syncStateImage struct {
+	SourceDigest      digest.Digest    `json:"sourceDigest"`
+	Destination       string           `json:"destination"` // transports.ImageName of the destination
+	DestinationDigest digest.Digest    `json:"destinationDigest"`
+	Settings          syncCopySettings `json:"settings"`
+	Synced            time.Time        `json:"synced"`
+}
+
+// syncStateFile is the contents of --state-file.
+type syncStateFile struct {
+	Version int                       `json:"version"`
+	Target  syncTarget                `json:"target"`
+	Images  map[string]syncStateImage `json:"images"` // Keyed by transports.ImageName of the source
+}
+
+// syncState is the state of a sync, persisted in --state-file after each copied image.
+// It is safe for concurrent use.
+type syncState struct {
+	path string // "" if the state is only kept in memory
+
+	mutex    sync.Mutex // Protects contents
+	contents syncStateFile
+}
+
+// loadSyncState reads the state file at path, or returns an empty state if it does not exist.
+// The contents of a state file for a different target are ignored.
+// If path is "", it returns an empty state which is only kept in memory.
+func loadSyncState(path string, target syncTarget) (*syncState, error) {
+	state := &syncState{
+		path: path,
+		contents: syncStateFile{
+			Version: syncStateVersion,
+			Target:  target,
+			Images:  map[string]syncStateImage{},
+		},
+	}
+	if path == "" {
+		return state, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return state, nil
+		}
+		return nil, fmt.Errorf("reading state file: %w", err)
+	}
+	var contents syncStateFile
+	if err := json.Unmarshal(data, &contents); err != nil {
+		return nil, fmt.Errorf("parsing state file %q: %w", path, err)
+	}
+	if contents.Version != syncStateVersion {
+		return nil, fmt.Errorf("state file %q has unsupported version %d", path, contents.Version)
+	}
+	if contents.Target != target {
+		logrus.Warnf("State file %q was written for a different destination, ignoring its contents", path)
+		return state, nil
+	}
+	if contents.Images != nil {
+		state.contents.Images = contents.Images
+	}
+	return state, nil
+}
+
+// manifestDigest returns the digest of the manifest of ref, using a HEAD request for docker references.
+func manifestDigest(ctx context.Context, sys *types.SystemContext, ref types.ImageReference) (_ digest.Digest, retErr error) {
+	if ref.Transport() == docker.Transport {
+		return docker.GetDigest(ctx, sys, ref)
+	}
+	src, err := ref.NewImageSource(ctx, sys)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err := src.Close(); err != nil {
+			retErr = noteCloseFailure(retErr, "closing image source", err)
+		}
+	}()
+	manifestBytes, _, err := src.GetManifest(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	return manifest.Digest(manifestBytes)
+}
+
+// unchanged returns the recorded state of sourceName if it was already synced from a source with sourceDigest,
+// to destination (a transports.ImageName) and with settings.
+func (s *syncState) unchanged(sourceName string, sourceDigest digest.Digest, destination string, settings syncCopySettings) (syncStateImage, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	image, ok := s.contents.Images[sourceName]
+	if !ok || image.SourceDigest != sourceDigest || image.Destination != destination || !image.Settings.equal(settings) {
+		return syncStateImage{}, false
+	}
+	return image, true
+}
+
+// record records that sourceName was synced, and saves the state file.
+func (s *syncState) record(sourceName string, image syncStateImage) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.contents.Images[sourceName] = image
+	return s.saveLocked()
+}
+
+// retain removes the state of all images with source names not in sourceNames, and saves the state file.
+func (s *syncState) retain(sourceNames map[string]struct{}) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for name := range s.contents.Images {
+		if _, ok := sourceNames[name]; !ok {
+			delete(s.contents.Images, name)
+		}
+	}
+	return s.saveLocked()
+}
+
+// saveLocked writes the state file, if any. The caller must hold s.mutex.
+func (s *syncState) saveLocked() error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(s.contents, "", "    ")
+	if err != nil {
+		return err
+	}
+	if err := ioutils.AtomicWriteFile(s.path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("writing state file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"go.podman.io/image/v5/docker/reference"
+	"go.podman.io/image/v5/storage"
+	"go.podman.io/image/v5/transports"
+	"go.podman.io/image/v5/types"
+	cstorage "go.podman.io/storage"
+)
+
+// defaultStore returns the default containers-storage store, the same one used for references without a store specifier.
+func defaultStore() (cstorage.Store, error) {
+	if store := storage.Transport.GetStoreIfSet(); store != nil {
+		return store, nil
+	}
+	options, err := cstorage.DefaultStoreOptions()
+	if err != nil {
+		return nil, fmt.Errorf("reading containers-storage configuration: %w", err)
+	}
+	options.UIDMap = storage.Transport.DefaultUIDMap()
+	options.GIDMap = storage.Transport.DefaultGIDMap()
+	store, err := cstorage.GetStore(options)
+	if err != nil {
+		return nil, fmt.Errorf("opening containers-storage: %w", err)
+	}
+	storage.Transport.SetStore(store)
+	return store, nil
+}
+
+// imagesToCopyFromStorage returns references to the tagged images in the default containers-storage store.
+// If source is not empty, only images in that repository, or the image with that name, are returned.
+// Each reference includes the image ID, so that it refers to the same image during the whole sync.
+func imagesToCopyFromStorage(source string) ([]types.ImageReference, error) {
+	var filter reference.Named
+	if source != "" {
+		named, err := reference.ParseNormalizedNamed(source)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid containers-storage source %q: %w", source, err)
+		}
+		if _, ok := named.(reference.Digested); ok {
+			return nil, fmt.Errorf("Invalid containers-storage source %q: images referenced by digest are not supported", source)
+		}
+		filter = named
+	}
+
+	store, err := defaultStore()
+	if err != nil {
+		return nil, err
+	}
+	images, err := store.Images()
+	if err != nil {
+		return nil, fmt.Errorf("listing images in containers-storage: %w", err)
+	}
+	var res []types.ImageReference
+	for _, image := range images {
+		for _, name := range image.Names {
+			named, err := reference.ParseNormalizedNamed(name)
+			if err != nil {
+				logrus.Warnf("Ignoring invalid name %q of image %s: %v", name, image.ID, err)
+				continue
+			}
+			tagged, ok := named.(reference.NamedTagged)
+			if !ok { // Names with digests refer to the same image as its tags
+				continue
+			}
+			if filter != nil {
+				if _, ok := filter.(reference.NamedTagged); ok && filter.String() != tagged.String() {
+					continue
+				}
+				if filter.Name() != tagged.Name() {
+					continue
+				}
+			}
+			ref, err := storage.Transport.NewStoreReference(store, tagged, image.ID)
+			if err != nil {
+				return nil, fmt.Errorf("Cannot obtain a valid image reference for %q in containers-storage: %w", name, err)
+			}
+			res = append(res, ref)
+		}
+	}
+	slices.SortFunc(res, func(a, b types.ImageReference) int {
+		return strings.Compare(transports.ImageName(a), transports.ImageName(b))
+	})
+	return res, nil
+}
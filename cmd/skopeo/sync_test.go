@@ -1,11 +1,30 @@
 package main
 
 import (
+	"encoding/json"
+	"encoding/xml"
 	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
 	"testing"
+	"time"
 
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.podman.io/image/v5/copy"
+	"go.podman.io/image/v5/docker"
+	"go.podman.io/image/v5/docker/reference"
 	"go.podman.io/image/v5/types"
 	"gopkg.in/yaml.v3"
 )
@@ -61,6 +80,281 @@ func TestSync(t *testing.T) {
 	// Actual feature tests exist in integration and systemtest
 }
 
+// ociRefNames returns the org.opencontainers.image.ref.name values in the index.json of the OCI layout in dir.
+func ociRefNames(t *testing.T, dir string) []string {
+	var res []string
+	for _, desc := range readOCIIndex(t, dir).Manifests {
+		if name, ok := desc.Annotations[imgspecv1.AnnotationRefName]; ok {
+			res = append(res, name)
+		}
+	}
+	return res
+}
+
+func TestSyncOCI(t *testing.T) {
+	const fixture = "../../integration/fixtures/uncompressed-image-s2"
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	for _, name := range []string{"latest", "registry.example.com/repo/app:1.0"} {
+		_, err := runSkopeo("--insecure-policy", "copy", "-q", "dir:"+fixture, "oci:"+src+":"+name)
+		require.NoError(t, err)
+	}
+	// Unnamed manifests are not copied
+	index := readOCIIndex(t, src)
+	addOCIReferrer(t, src, index.Manifests[0], "application/spdx+json")
+
+	// oci -> oci
+	dest := filepath.Join(dir, "oci")
+	_, err := runSkopeo("--insecure-policy", "sync", "--src", "oci", "--dest", "oci", src, dest)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"src:latest", "app:1.0"}, ociRefNames(t, dest))
+	assert.Len(t, readOCIIndex(t, dest).Manifests, 2)
+	// A second sync into the same layout adds images, and shares blobs
+	_, err = runSkopeo("--insecure-policy", "sync", "--src", "oci", "--dest", "oci", "--scoped", "--append-suffix", "-mirror", src, dest)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"src:latest", "app:1.0", "src:latest-mirror", "registry.example.com/repo/app:1.0-mirror"}, ociRefNames(t, dest))
+	blobs, err := os.ReadDir(filepath.Join(dest, "blobs", "sha256"))
+	require.NoError(t, err)
+	srcBlobs, err := os.ReadDir(filepath.Join(src, "blobs", "sha256"))
+	require.NoError(t, err)
+	assert.Less(t, len(blobs), len(srcBlobs)) // The referrer is not copied
+	// Syncing an unchanged source again does not add new entries
+	_, err = runSkopeo("--insecure-policy", "sync", "--src", "oci", "--dest", "oci", src, dest)
+	require.NoError(t, err)
+	assert.Len(t, readOCIIndex(t, dest).Manifests, 4)
+
+	// oci -> dir -> oci
+	dirDest := filepath.Join(dir, "dir")
+	_, err = runSkopeo("--insecure-policy", "sync", "--src", "oci", "--dest", "dir", src, dirDest)
+	require.NoError(t, err)
+	for _, name := range []string{"src:latest", "app:1.0"} {
+		_, err = os.Stat(filepath.Join(dirDest, name, "manifest.json"))
+		assert.NoError(t, err, name)
+	}
+	dest = filepath.Join(dir, "from-dir")
+	_, err = runSkopeo("--insecure-policy", "sync", "--src", "dir", "--dest", "oci", "--scoped", dirDest, dest)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"src:latest", "app:1.0"}, ociRefNames(t, dest))
+
+	// Syncing a layout into itself is not supported
+	_, err = runSkopeo("--insecure-policy", "sync", "--src", "oci", "--dest", "oci", src, src)
+	assert.Error(t, err)
+	// Layouts without named images
+	empty := filepath.Join(dir, "empty")
+	err = os.MkdirAll(empty, 0o755)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(empty, "index.json"), []byte(`{"schemaVersion":2,"manifests":[]}`), 0o644)
+	require.NoError(t, err)
+	_, err = runSkopeo("--insecure-policy", "sync", "--src", "oci", "--dest", "oci", empty, filepath.Join(dir, "unused"))
+	assert.ErrorContains(t, err, "No images to sync found")
+	_, err = runSkopeo("--insecure-policy", "sync", "--src", "oci", "--dest", "oci", filepath.Join(dir, "this/does/not/exist"), filepath.Join(dir, "unused"))
+	assert.Error(t, err)
+}
+
+func TestSyncParallelImages(t *testing.T) {
+	const fixture = "../../integration/fixtures/uncompressed-image-s2"
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	tags := []string{"t1", "t2", "t3", "t4", "t5"}
+	for _, tag := range tags {
+		_, err := runSkopeo("--insecure-policy", "copy", "-q", "dir:"+fixture, "oci:"+src+":"+tag)
+		require.NoError(t, err)
+	}
+
+	manifestDigest := readOCIIndex(t, src).Manifests[0].Digest
+	dest := filepath.Join(dir, "dest")
+	digestFile := filepath.Join(dir, "digests")
+	_, err := runSkopeo("--insecure-policy", "sync", "--src", "oci", "--dest", "dir", "--parallel-images", "3", "--image-parallel-copies", "2",
+		"--digestfile", digestFile, src, dest)
+	require.NoError(t, err)
+	digests, err := os.ReadFile(digestFile)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSuffix(string(digests), "\n"), "\n")
+	require.Len(t, lines, len(tags))
+	for i, tag := range tags {
+		_, err = os.Stat(filepath.Join(dest, "src:"+tag, "manifest.json"))
+		assert.NoError(t, err, tag)
+		// Digests are written in the order of the source images, regardless of which copy finished first
+		assert.Equal(t, fmt.Sprintf("%s dir:%s", manifestDigest, filepath.Join(dest, "src:"+tag)), lines[i])
+	}
+
+	// Parallel copies into a single OCI layout are serialized
+	_, err = runSkopeo("--insecure-policy", "sync", "--src", "oci", "--dest", "oci", "--parallel-images", "3", src, filepath.Join(dir, "oci"))
+	require.NoError(t, err)
+	assert.Len(t, ociRefNames(t, filepath.Join(dir, "oci")), len(tags))
+
+	out, err := runSkopeo("--insecure-policy", "sync", "--src", "oci", "--dest", "dir", "--parallel-images", "0", src, filepath.Join(dir, "unused"))
+	assertTestFailed(t, out, err, "--parallel-images must be at least 1")
+}
+
+func TestSplitRepositoryTag(t *testing.T) {
+	for _, c := range []struct{ input, repo, tag string }{
+		{"busybox:latest", "busybox", "latest"},
+		{"registry.example.com:5000/ns/busybox:1.0", "registry.example.com:5000/ns/busybox", "1.0"},
+		{"registry.example.com:5000/ns/busybox", "", ""},
+		{"busybox", "", ""},
+		{"busybox@sha256:0000000000000000000000000000000000000000000000000000000000000000", "", ""},
+		{"busybox:latest@sha256:0000000000000000000000000000000000000000000000000000000000000000", "", ""},
+	} {
+		repo, tag, ok := splitRepositoryTag(c.input)
+		assert.Equal(t, c.tag != "", ok, c.input)
+		assert.Equal(t, c.repo, repo, c.input)
+		assert.Equal(t, c.tag, tag, c.input)
+	}
+}
+
+func TestSyncPrune(t *testing.T) {
+	const fixture = "../../integration/fixtures/uncompressed-image-s2"
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	for _, tag := range []string{"t1", "t2", "t3"} {
+		_, err := runSkopeo("--insecure-policy", "copy", "-q", "dir:"+fixture, "oci:"+src+":"+tag)
+		require.NoError(t, err)
+	}
+	dest := filepath.Join(dir, "dest")
+	_, err := runSkopeo("--insecure-policy", "sync", "--src", "oci", "--dest", "oci", src, dest)
+	require.NoError(t, err)
+	// Images of other repositories are never pruned
+	_, err = runSkopeo("--insecure-policy", "copy", "-q", "dir:"+fixture, "oci:"+dest+":other:t2")
+	require.NoError(t, err)
+	for _, tag := range []string{"t2", "t3"} {
+		_, err = runSkopeo("delete", "oci:"+src+":"+tag)
+		require.NoError(t, err)
+	}
+
+	// Too many deletions
+	_, err = runSkopeo("--insecure-policy", "sync", "--src", "oci", "--dest", "oci", "--prune", "--prune-max-deletions", "1", src, dest)
+	assert.ErrorContains(t, err, "Refusing to prune 2 tags")
+	assert.ElementsMatch(t, []string{"src:t1", "src:t2", "src:t3", "other:t2"}, ociRefNames(t, dest))
+	// Dry run
+	_, err = runSkopeo("--insecure-policy", "sync", "--src", "oci", "--dest", "oci", "--prune", "--dry-run", src, dest)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"src:t1", "src:t2", "src:t3", "other:t2"}, ociRefNames(t, dest))
+	// Only tags with the --append-suffix are considered
+	_, err = runSkopeo("--insecure-policy", "sync", "--src", "oci", "--dest", "oci", "--prune", "--append-suffix", "-mirror", src, dest)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"src:t1", "src:t2", "src:t3", "src:t1-mirror", "other:t2"}, ociRefNames(t, dest))
+
+	_, err = runSkopeo("--insecure-policy", "sync", "--src", "oci", "--dest", "oci", "--prune", src, dest)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"src:t1", "other:t2"}, ociRefNames(t, dest))
+
+	_, err = runSkopeo("--insecure-policy", "sync", "--src", "oci", "--dest", "dir", "--prune", src, filepath.Join(dir, "unused"))
+	assert.ErrorContains(t, err, "--prune is not supported with --dest dir")
+}
+
+func TestSyncStateFile(t *testing.T) {
+	const fixture = "../../integration/fixtures/uncompressed-image-s2"
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	for _, tag := range []string{"t1", "t2"} {
+		_, err := runSkopeo("--insecure-policy", "copy", "-q", "dir:"+fixture, "oci:"+src+":"+tag)
+		require.NoError(t, err)
+	}
+	manifestDigest := readOCIIndex(t, src).Manifests[0].Digest
+	dest := filepath.Join(dir, "dest")
+	stateFile := filepath.Join(dir, "state.json")
+	digestFile := filepath.Join(dir, "digests")
+	readState := func() syncStateFile {
+		data, err := os.ReadFile(stateFile)
+		require.NoError(t, err)
+		var state syncStateFile
+		err = json.Unmarshal(data, &state)
+		require.NoError(t, err)
+		return state
+	}
+
+	_, err := runSkopeo("--insecure-policy", "sync", "--src", "oci", "--dest", "dir", "--state-file", stateFile, src, dest)
+	require.NoError(t, err)
+	state := readState()
+	assert.Equal(t, syncTarget{Transport: "dir", Destination: dest}, state.Target)
+	require.Len(t, state.Images, 2)
+	image := state.Images["oci:"+src+":t1"]
+	assert.Equal(t, manifestDigest, image.SourceDigest)
+	assert.Equal(t, "dir:"+filepath.Join(dest, "src:t1"), image.Destination)
+	assert.Equal(t, manifestDigest, image.DestinationDigest)
+
+	// Unchanged images are skipped, so the existing dir: destinations are not an error, and are still in the digest file.
+	_, err = runSkopeo("--insecure-policy", "sync", "--src", "oci", "--dest", "dir", "--state-file", stateFile, "--digestfile", digestFile, src, dest)
+	require.NoError(t, err)
+	digests, err := os.ReadFile(digestFile)
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("%s dir:%s\n%s dir:%s\n", manifestDigest, filepath.Join(dest, "src:t1"), manifestDigest, filepath.Join(dest, "src:t2")),
+		string(digests))
+
+	// Images synced with different copy options are not skipped, so the existing dir: destinations are an error.
+	for _, args := range [][]string{{"--all"}, {"--format", "oci"}, {"--preserve-digests"}, {"--remove-signatures"}} {
+		_, err = runSkopeo(append(append([]string{"--insecure-policy", "sync", "--src", "oci", "--dest", "dir", "--state-file", stateFile}, args...), src, dest)...)
+		assert.ErrorContains(t, err, "Refusing to overwrite", args)
+	}
+	assert.Equal(t, syncCopySettings{}, readState().Images["oci:"+src+":t1"].Settings)
+
+	// New images are copied, removed images are forgotten
+	_, err = runSkopeo("--insecure-policy", "copy", "-q", "dir:"+fixture, "oci:"+src+":t3")
+	require.NoError(t, err)
+	_, err = runSkopeo("delete", "oci:"+src+":t1")
+	require.NoError(t, err)
+	_, err = runSkopeo("--insecure-policy", "sync", "--src", "oci", "--dest", "dir", "--state-file", stateFile, src, dest)
+	require.NoError(t, err)
+	_, err = os.Stat(filepath.Join(dest, "src:t3", "manifest.json"))
+	assert.NoError(t, err)
+	state = readState()
+	assert.Len(t, state.Images, 2)
+	assert.Contains(t, state.Images, "oci:"+src+":t3")
+
+	// The state of a different destination is ignored
+	_, err = runSkopeo("--insecure-policy", "sync", "--src", "oci", "--dest", "dir", "--scoped", "--state-file", stateFile, src, dest)
+	assert.ErrorContains(t, err, "Refusing to overwrite")
+
+	err = os.WriteFile(stateFile, []byte(`{"version":2}`), 0o644)
+	require.NoError(t, err)
+	_, err = runSkopeo("--insecure-policy", "sync", "--src", "oci", "--dest", "dir", "--state-file", stateFile, src, dest)
+	assert.ErrorContains(t, err, "unsupported version 2")
+}
+
+func TestSyncCopySettings(t *testing.T) {
+	settings := func(args ...string) syncCopySettings {
+		flags, copyOpts := sharedCopyFlags()
+		err := flags.Parse(args)
+		require.NoError(t, err)
+		opts := syncOptions{copy: copyOpts}
+		return opts.copySettings(repoDescriptor{})
+	}
+	defaults := settings()
+	assert.True(t, defaults.equal(settings()))
+
+	// Options which change the copied images, or what is copied with them
+	for _, args := range [][]string{
+		{"--format", "oci"},
+		{"--preserve-digests"},
+		{"--remove-signatures"},
+		{"--sign-by", "fingerprint"},
+		{"--sign-by-sq-fingerprint", "fingerprint"},
+		{"--sign-by-sigstore", "/params.yaml"},
+		{"--sign-by-sigstore-private-key", "/key.private"},
+		{"--referrers"},
+		{"--referrers", "--referrers-artifact-type", "application/spdx+json"},
+	} {
+		s := settings(args...)
+		assert.False(t, defaults.equal(s), args)
+		assert.False(t, s.equal(defaults), args)
+	}
+	assert.False(t, settings("--referrers", "--referrers-artifact-type", "a").equal(settings("--referrers", "--referrers-artifact-type", "b")))
+
+	// Options which don't
+	for _, args := range [][]string{
+		{"--progress", "json"},
+		{"--sign-passphrase-file", "/passphrase"},
+	} {
+		assert.True(t, defaults.equal(settings(args...)), args)
+	}
+
+	opts := syncOptions{copy: &sharedCopyOptions{}, all: true}
+	assert.False(t, defaults.equal(opts.copySettings(repoDescriptor{})))
+	opts = syncOptions{copy: &sharedCopyOptions{}}
+	assert.False(t, defaults.equal(opts.copySettings(repoDescriptor{Platforms: &syncPlatforms{names: []string{"linux/arm64"}}})))
+}
+
 // TestSyncTLSPrecedence validates the interactions of tls-verify in YAML and --src-tls-verify in the CLI.
 func TestSyncTLSPrecedence(t *testing.T) {
 	for _, tt := range []struct {
@@ -99,3 +393,1200 @@ images:
 		})
 	}
 }
+
+func TestSyncYAMLPlatforms(t *testing.T) {
+	sourceCtx := &types.SystemContext{}
+	var cfg registrySyncConfig
+	err := yaml.Unmarshal([]byte(`
+platforms: [linux/amd64, linux/arm64]
+image-platforms:
+  special: [linux/arm/v7, linux/s390x]
+images:
+  normal: [latest]
+  special: [latest]
+`), &cfg)
+	require.NoError(t, err)
+	descs, err := imagesToCopyFromRegistry("example.com", cfg, *sourceCtx)
+	require.NoError(t, err)
+	require.Len(t, descs, 2)
+	platforms := map[string]*syncPlatforms{}
+	for _, desc := range descs {
+		require.Len(t, desc.ImageRefs, 1)
+		platforms[desc.ImageRefs[0].DockerReference().String()] = desc.Platforms
+	}
+	assert.Equal(t, &syncPlatforms{
+		names:   []string{"linux/amd64", "linux/arm64"},
+		filters: []copy.InstancePlatformFilter{{OS: "linux", Architecture: "amd64"}, {OS: "linux", Architecture: "arm64"}},
+	}, platforms["example.com/normal:latest"])
+	assert.Equal(t, &syncPlatforms{
+		names:    []string{"linux/arm/v7", "linux/s390x"},
+		filters:  []copy.InstancePlatformFilter{{OS: "linux", Architecture: "s390x"}},
+		variants: []platformSelector{{os: "linux", architecture: "arm", variant: "v7"}},
+	}, platforms["example.com/special:latest"])
+
+	// No platforms
+	cfg = registrySyncConfig{}
+	err = yaml.Unmarshal([]byte(`
+images:
+  normal: [latest]
+`), &cfg)
+	require.NoError(t, err)
+	descs, err = imagesToCopyFromRegistry("example.com", cfg, *sourceCtx)
+	require.NoError(t, err)
+	require.Len(t, descs, 1)
+	assert.Nil(t, descs[0].Platforms)
+
+	// Invalid platforms
+	for _, c := range []string{
+		"platforms: [linux]",
+		"image-platforms: {normal: [linux/amd64/v1/x]}",
+		"image-platforms: {normal: []}",
+	} {
+		cfg = registrySyncConfig{}
+		err = yaml.Unmarshal([]byte(c+"\nimages: {normal: [latest]}\n"), &cfg)
+		require.NoError(t, err, c)
+		_, err = imagesToCopyFromRegistry("example.com", cfg, *sourceCtx)
+		assert.Error(t, err, c)
+	}
+}
+
+// dockerTagReferences returns references to tags in repo.
+func dockerTagReferences(t *testing.T, repo string, tags ...string) []types.ImageReference {
+	var res []types.ImageReference
+	for _, tag := range tags {
+		ref, err := docker.ParseReference("//" + repo + ":" + tag)
+		require.NoError(t, err)
+		res = append(res, ref)
+	}
+	return res
+}
+
+func TestSemverFilterCollectionMaxTags(t *testing.T) {
+	logger := logrus.NewEntry(logrus.StandardLogger())
+	refs := dockerTagReferences(t, "example.com/repo", "1.0.0", "latest", "2.1.0", "1.10.0", "2.0.0", "0.9.0", "3.0.0-rc1")
+	for _, c := range []struct {
+		maxTags  int
+		expected []string
+	}{
+		{0, []string{"1.0.0", "2.1.0", "1.10.0", "2.0.0"}},
+		{2, []string{"2.1.0", "2.0.0"}},
+		{3, []string{"2.1.0", "1.10.0", "2.0.0"}},
+		{10, []string{"1.0.0", "2.1.0", "1.10.0", "2.0.0"}},
+	} {
+		collection, err := semverFilterCollection(map[string]string{"repo": ">= 1.0.0"}, c.maxTags)
+		require.NoError(t, err)
+		var tags []string
+		for _, ref := range collection["repo"](logger, refs) {
+			tag, ok := referenceTag(logger, ref)
+			require.True(t, ok)
+			tags = append(tags, tag)
+		}
+		assert.Equal(t, c.expected, tags, c.maxTags)
+	}
+}
+
+func TestTagFilters(t *testing.T) {
+	logger := logrus.NewEntry(logrus.StandardLogger())
+	var cfg registrySyncConfig
+	err := yaml.Unmarshal([]byte(`
+images-by-tag-regex-exclude:
+  repo: -rc[0-9]*$
+max-tags: 2
+newer-than: 2024-01-01
+`), &cfg)
+	require.NoError(t, err)
+	filters, err := cfg.tagFilters()
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), filters.newerThan)
+	refs := dockerTagReferences(t, "example.com/repo", "1.0", "1.1-rc1", "1.1-rc", "1.1", "rc-1")
+	assert.Equal(t, dockerTagReferences(t, "example.com/repo", "1.0", "1.1", "rc-1"), filters.excluded(logger, "repo", refs))
+	assert.Equal(t, refs, filters.excluded(logger, "other", refs))
+	// Images with an unknown creation time are not skipped
+	refs = dockerTagReferences(t, "127.0.0.1:1/repo", "1.0", "1.1")
+	assert.Equal(t, refs, filters.newer(&types.SystemContext{}, logger, refs))
+
+	for _, c := range []string{
+		"images-by-tag-regex-exclude: {repo: '('}",
+		"max-tags: -1",
+		"newer-than: yesterday",
+		"newer-than: -24h",
+	} {
+		cfg = registrySyncConfig{}
+		err = yaml.Unmarshal([]byte(c), &cfg)
+		require.NoError(t, err, c)
+		_, err = cfg.tagFilters()
+		assert.Error(t, err, c)
+	}
+}
+
+func TestParseNewerThan(t *testing.T) {
+	now := time.Date(2025, 6, 15, 12, 0, 0, 0, time.UTC)
+	for _, c := range []struct {
+		input    string
+		expected time.Time
+	}{
+		{"2024-01-01", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"2024-01-01T10:20:30Z", time.Date(2024, 1, 1, 10, 20, 30, 0, time.UTC)},
+		{"24h", time.Date(2025, 6, 14, 12, 0, 0, 0, time.UTC)},
+	} {
+		res, err := parseNewerThan(c.input, now)
+		require.NoError(t, err, c.input)
+		assert.True(t, c.expected.Equal(res), c.input)
+	}
+	for _, c := range []string{"", "2024-13-01", "-1h", "1d"} {
+		_, err := parseNewerThan(c, now)
+		assert.Error(t, err, c)
+	}
+}
+
+func TestSyncYAMLDestinations(t *testing.T) {
+	sourceCtx := &types.SystemContext{}
+	var cfg registrySyncConfig
+	err := yaml.Unmarshal([]byte(`
+destination: "mirror/{registry}/{repository}"
+image-destinations:
+  org/tool: "tools/{name}:{tag}-x"
+  org/pinned: "{name}-{digest}"
+destination-rewrites:
+  - regex: "^mirror/quay.io/org/([^:@]*)"
+    replacement: "mirror/org-$1"
+images:
+  org/app: [latest, "sha256:0000000000000000000000000000000000000000000000000000000000000000"]
+  org/tool: ["1.0"]
+  org/pinned: [latest]
+`), &cfg)
+	require.NoError(t, err)
+	descs, err := imagesToCopyFromRegistry("quay.io", cfg, *sourceCtx)
+	require.NoError(t, err)
+	names := map[string]string{}
+	var errs []string
+	for _, desc := range descs {
+		require.NotNil(t, desc.Destination)
+		for _, ref := range desc.ImageRefs {
+			name, err := desc.Destination.destinationName(ref, "default")
+			if err != nil {
+				errs = append(errs, ref.DockerReference().String())
+				continue
+			}
+			names[ref.DockerReference().String()] = name
+		}
+	}
+	assert.Equal(t, map[string]string{
+		"quay.io/org/app:latest": "mirror/org-app:latest",
+		"quay.io/org/app@sha256:0000000000000000000000000000000000000000000000000000000000000000": "mirror/org-app@sha256:0000000000000000000000000000000000000000000000000000000000000000",
+		"quay.io/org/tool:1.0": "tools/tool:1.0-x",
+	}, names)
+	// {digest} is not available for tagged images
+	assert.Equal(t, []string{"quay.io/org/pinned:latest"}, errs)
+
+	// Rewrites apply to default names as well
+	cfg = registrySyncConfig{}
+	err = yaml.Unmarshal([]byte(`
+destination-rewrites:
+  - {regex: "^app", replacement: "renamed"}
+images:
+  org/app: [latest]
+  other: [latest]
+`), &cfg)
+	require.NoError(t, err)
+	descs, err = imagesToCopyFromRegistry("quay.io", cfg, *sourceCtx)
+	require.NoError(t, err)
+	for _, desc := range descs {
+		require.NotNil(t, desc.Destination)
+		name, err := desc.Destination.destinationName(desc.ImageRefs[0], path.Base(desc.ImageRefs[0].DockerReference().String()))
+		require.NoError(t, err)
+		if reference.Path(desc.ImageRefs[0].DockerReference()) == "org/app" {
+			assert.Equal(t, "renamed:latest", name)
+		} else {
+			assert.Equal(t, "other:latest", name)
+		}
+	}
+
+	// No mapping
+	cfg = registrySyncConfig{}
+	err = yaml.Unmarshal([]byte(`images: {app: [latest]}`), &cfg)
+	require.NoError(t, err)
+	descs, err = imagesToCopyFromRegistry("quay.io", cfg, *sourceCtx)
+	require.NoError(t, err)
+	require.Len(t, descs, 1)
+	assert.Nil(t, descs[0].Destination)
+
+	for _, c := range []string{
+		`destination: "{unknown}"`,
+		`destination: "/absolute"`,
+		`image-destinations: {app: "{repo}"}`,
+		`image-destinations: {app: ""}`,
+		`destination-rewrites: [{regex: "(", replacement: ""}]`,
+		`destination-rewrites: [{replacement: "x"}]`,
+	} {
+		cfg = registrySyncConfig{}
+		err = yaml.Unmarshal([]byte(c+"\nimages: {app: [latest]}\n"), &cfg)
+		require.NoError(t, err, c)
+		_, err = imagesToCopyFromRegistry("quay.io", cfg, *sourceCtx)
+		assert.Error(t, err, c)
+	}
+}
+
+func TestSyncYAMLRepositoriesByRegex(t *testing.T) {
+	catalog := []string{"library/alpine", "library/busybox", "library/nginx", "other/app", "tools/busybox"}
+	tags := map[string][]string{
+		"library/alpine":  {"stable-1", "stable-2", "edge"},
+		"library/busybox": {"stable-1", "latest"},
+		"library/nginx":   {"stable-1", "latest"},
+		"other/app":       {"stable-1"},
+		"tools/busybox":   {"stable-1", "latest"},
+	}
+
+	mux := http.NewServeMux()
+	server := httptest.NewTLSServer(mux)
+	defer server.Close()
+	mux.HandleFunc("/v2/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v2/" {
+			return
+		}
+		repo, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/v2/"), "/tags/list")
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		err := json.NewEncoder(w).Encode(map[string]any{"name": repo, "tags": tags[repo]})
+		assert.NoError(t, err)
+	})
+	mux.HandleFunc("/v2/_catalog", func(w http.ResponseWriter, r *http.Request) {
+		page := catalog[:2]
+		if r.URL.Query().Get("last") == catalog[1] {
+			page = catalog[2:]
+		} else {
+			w.Header().Set("Link", fmt.Sprintf(`</v2/_catalog?n=1000&last=%s>; rel="next"`, catalog[1]))
+		}
+		err := json.NewEncoder(w).Encode(map[string]any{"repositories": page})
+		assert.NoError(t, err)
+	})
+	registryName := strings.TrimPrefix(server.URL, "https://")
+	sourceCtx := types.SystemContext{DockerInsecureSkipTLSVerify: types.OptionalBoolTrue}
+
+	repos, err := fetchCatalog(t.Context(), &sourceCtx, registryName)
+	require.NoError(t, err)
+	assert.Equal(t, catalog, repos)
+
+	var cfg registrySyncConfig
+	err = yaml.Unmarshal([]byte(`
+repositories-by-regex:
+  ^library/: ^stable-
+  busybox$: ^latest$
+images-by-tag-regex:
+  library/nginx: ^latest$
+images-by-tag-regex-exclude:
+  library/alpine: -2$
+`), &cfg)
+	require.NoError(t, err)
+	descs, err := imagesToCopyFromRegistry(registryName, cfg, sourceCtx)
+	require.NoError(t, err)
+	var refs []string
+	for _, desc := range descs {
+		for _, ref := range desc.ImageRefs {
+			refs = append(refs, strings.TrimPrefix(ref.DockerReference().String(), registryName+"/"))
+		}
+	}
+	assert.ElementsMatch(t, []string{
+		"library/alpine:stable-1",
+		"library/busybox:stable-1",
+		"library/busybox:latest",
+		"library/nginx:latest", // From images-by-tag-regex only
+		"tools/busybox:latest",
+	}, refs)
+
+	for _, c := range []string{
+		`repositories-by-regex: {"(": "."}`,
+		`repositories-by-regex: {".": "("}`,
+	} {
+		cfg = registrySyncConfig{}
+		err = yaml.Unmarshal([]byte(c), &cfg)
+		require.NoError(t, err, c)
+		_, err = imagesToCopyFromRegistry(registryName, cfg, sourceCtx)
+		assert.Error(t, err, c)
+	}
+
+	// The catalog API is not available
+	cfg = registrySyncConfig{}
+	err = yaml.Unmarshal([]byte(`repositories-by-regex: {".": "."}`), &cfg)
+	require.NoError(t, err)
+	_, err = imagesToCopyFromRegistry("127.0.0.1:1", cfg, sourceCtx)
+	assert.Error(t, err)
+}
+
+func TestFetchCatalogLinkToOtherHost(t *testing.T) {
+	otherServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("Unexpected request to another host: %s, Authorization %q", r.URL, r.Header.Get("Authorization"))
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer otherServer.Close()
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "user" || password != "pass" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="test"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Link", fmt.Sprintf(`<%s/v2/_catalog?n=1000&last=a>; rel="next"`, otherServer.URL))
+		err := json.NewEncoder(w).Encode(map[string]any{"repositories": []string{"a"}})
+		assert.NoError(t, err)
+	}))
+	defer server.Close()
+	sys := &types.SystemContext{
+		DockerInsecureSkipTLSVerify: types.OptionalBoolTrue,
+		DockerAuthConfig:            &types.DockerAuthConfig{Username: "user", Password: "pass"},
+	}
+
+	_, err := fetchCatalog(t.Context(), sys, strings.TrimPrefix(server.URL, "https://"))
+	assert.ErrorContains(t, err, "refusing to follow")
+}
+
+func TestFetchCatalogIdentityToken(t *testing.T) {
+	const token = "the-token"
+	mux := http.NewServeMux()
+	server := httptest.NewTLSServer(mux)
+	defer server.Close()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		// The identity token is used as an OAuth2 refresh token
+		if r.Method != http.MethodPost || r.PostFormValue("grant_type") != "refresh_token" || r.PostFormValue("refresh_token") != "identity-token" ||
+			r.PostFormValue("scope") != "registry:catalog:*" || r.PostFormValue("service") != "test" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprintf(w, `{"access_token":%q}`, token)
+	})
+	mux.HandleFunc("/v2/_catalog", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+token {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test"`, server.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		err := json.NewEncoder(w).Encode(map[string]any{"repositories": []string{"a"}})
+		assert.NoError(t, err)
+	})
+	sys := &types.SystemContext{
+		DockerInsecureSkipTLSVerify: types.OptionalBoolTrue,
+		DockerAuthConfig:            &types.DockerAuthConfig{IdentityToken: "identity-token"},
+	}
+
+	repos, err := fetchCatalog(t.Context(), sys, strings.TrimPrefix(server.URL, "https://"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a"}, repos)
+
+	sys.DockerAuthConfig.IdentityToken = "wrong"
+	_, err = fetchCatalog(t.Context(), sys, strings.TrimPrefix(server.URL, "https://"))
+	assert.Error(t, err)
+}
+
+func TestSyncYAMLCredentials(t *testing.T) {
+	dir := t.TempDir()
+	credsFile := filepath.Join(dir, "creds")
+	err := os.WriteFile(credsFile, []byte("fileuser:filepass\n"), 0o600)
+	require.NoError(t, err)
+	tokenFile := filepath.Join(dir, "token")
+	err = os.WriteFile(tokenFile, []byte("file-token\n"), 0o600)
+	require.NoError(t, err)
+	// A docker credential helper returning fixed credentials for registry.example.com, and an identity token for other registries
+	err = os.WriteFile(filepath.Join(dir, "docker-credential-sync-test"), []byte(`#!/bin/sh
+read -r server
+if [ "$server" = registry.example.com ]; then
+	echo '{"ServerURL":"registry.example.com","Username":"helperuser","Secret":"helperpass"}'
+else
+	echo '{"ServerURL":"'"$server"'","Username":"<token>","Secret":"helper-token"}'
+fi
+`), 0o755)
+	require.NoError(t, err)
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("SYNC_TEST_CREDS", "envuser:envpass")
+	t.Setenv("SYNC_TEST_TOKEN", "env-token")
+
+	for _, c := range []struct {
+		registry, yaml string
+		expected       *types.DockerAuthConfig
+		registryToken  string
+		authFile       string
+	}{
+		{"registry.example.com", ``, nil, "", ""},
+		{"registry.example.com", `credentials: {username: user, password: pass}`, &types.DockerAuthConfig{Username: "user", Password: "pass"}, "", ""},
+		{"registry.example.com", `credentials: env:SYNC_TEST_CREDS`, &types.DockerAuthConfig{Username: "envuser", Password: "envpass"}, "", ""},
+		{"registry.example.com", `credentials: file:` + credsFile, &types.DockerAuthConfig{Username: "fileuser", Password: "filepass"}, "", ""},
+		{"registry.example.com", `credentials: helper:sync-test`, &types.DockerAuthConfig{Username: "helperuser", Password: "helperpass"}, "", ""},
+		{"other.example.com", `credentials: helper:sync-test`, &types.DockerAuthConfig{IdentityToken: "helper-token"}, "", ""},
+		{"registry.example.com", `identity-token: env:SYNC_TEST_TOKEN`, &types.DockerAuthConfig{IdentityToken: "env-token"}, "", ""},
+		{"registry.example.com", `identity-token: literal-token`, &types.DockerAuthConfig{IdentityToken: "literal-token"}, "", ""},
+		{"registry.example.com", `registry-token: file:` + tokenFile, nil, "file-token", ""},
+		{"registry.example.com", `authfile: /path/to/auth.json`, nil, "", "/path/to/auth.json"},
+	} {
+		var cfg registrySyncConfig
+		err := yaml.Unmarshal([]byte(c.yaml), &cfg)
+		require.NoError(t, err, c.yaml)
+		sys := types.SystemContext{}
+		err = cfg.applyAuthentication(c.registry, &sys)
+		require.NoError(t, err, c.yaml)
+		assert.Equal(t, c.expected, sys.DockerAuthConfig, c.yaml)
+		assert.Equal(t, c.registryToken, sys.DockerBearerRegistryToken, c.yaml)
+		assert.Equal(t, c.authFile, sys.AuthFilePath, c.yaml)
+	}
+
+	for _, c := range []string{
+		`credentials: user:pass`,
+		`credentials: vault:secret`,
+		`credentials: "env:"`,
+		`credentials: [user, pass]`,
+	} {
+		var cfg registrySyncConfig
+		err := yaml.Unmarshal([]byte(c), &cfg)
+		assert.Error(t, err, c)
+	}
+
+	for _, c := range []string{
+		`credentials: env:SYNC_TEST_UNSET`,
+		`credentials: file:` + filepath.Join(dir, "missing"),
+		`credentials: helper:sync-test-missing`,
+		`registry-token: env:SYNC_TEST_UNSET`,
+		`identity-token: file:` + filepath.Join(dir, "missing"),
+		"credentials: env:SYNC_TEST_CREDS\nidentity-token: env:SYNC_TEST_TOKEN",
+	} {
+		var cfg registrySyncConfig
+		err := yaml.Unmarshal([]byte(c+"\nimages: {app: [latest]}\n"), &cfg)
+		require.NoError(t, err, c)
+		_, err = imagesToCopyFromRegistry("registry.example.com", cfg, types.SystemContext{})
+		assert.Error(t, err, c)
+	}
+}
+
+func TestNewSourceConfig(t *testing.T) {
+	dir := t.TempDir()
+	writeConfig := func(contents string) string {
+		path := filepath.Join(dir, "sync.yaml")
+		err := os.WriteFile(path, []byte(contents), 0o644)
+		require.NoError(t, err)
+		return path
+	}
+
+	// A valid file
+	path := writeConfig(`
+registry.example.com:
+  images:
+    busybox: []
+    redis: ["1.0", "sha256:0000000000000000000000000000000011111111111111111111111111111111"]
+  images-by-tag-regex:
+    nginx: ^1\.13$
+  images-by-semver:
+    alpine: ">= 3.12.0"
+  credentials: &creds
+    username: john
+    password: secret
+  tls-verify: false
+  platforms: [linux/amd64]
+  destination-rewrites:
+    - {regex: "^a", replacement: "b"}
+quay.io:
+  credentials: *creds
+index.docker.io:
+`)
+	cfg, err := newSourceConfig(path)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"index.docker.io", "quay.io", "registry.example.com"}, slices.Sorted(maps.Keys(cfg)))
+	rc := cfg["registry.example.com"]
+	assert.Equal(t, map[string][]string{
+		"busybox": {},
+		"redis":   {"1.0", "sha256:0000000000000000000000000000000011111111111111111111111111111111"},
+	}, rc.Images)
+	assert.Equal(t, map[string]string{"nginx": `^1\.13$`}, rc.ImagesByTagRegex)
+	assert.Equal(t, types.OptionalBoolTrue, rc.TLSVerify.skip)
+	assert.Equal(t, []string{"linux/amd64"}, rc.Platforms)
+	assert.Equal(t, types.DockerAuthConfig{Username: "john", Password: "secret"}, cfg["quay.io"].Credentials.inline)
+
+	out, err := runSkopeo("sync", "--validate-config", path)
+	require.NoError(t, err)
+	assert.Equal(t, path+" is valid\n", out)
+
+	// An empty file
+	cfg, err = newSourceConfig(writeConfig(""))
+	require.NoError(t, err)
+	assert.Empty(t, cfg)
+
+	// Errors are reported with their positions
+	path = writeConfig(`registry.example.com:
+  images-by-tagregex:
+    nginx: ^1$
+  images:
+    "Invalid": []
+    busybox: [latest, "bad tag"]
+  images-by-semver:
+    alpine: ">= 1.0 <"
+  images-by-tag-regex-exclude: {busybox: "("}
+  max-tags: many
+  newer-than: yesterday
+  platforms: [linux]
+  image-platforms: {busybox: []}
+  destination: "{unknown}"
+  destination-rewrites:
+    - {regexp: "x"}
+  credentials: {user: john}
+  tls-verify: maybe
+  tls-verify: true
+https://quay.io:
+  images: {a: []}
+quay.io: [a]
+`)
+	_, err = newSourceConfig(path)
+	var configErr *sourceConfigError
+	require.ErrorAs(t, err, &configErr)
+	var msgs []string
+	for _, err := range configErr.errs {
+		msgs = append(msgs, strings.TrimPrefix(err.Error(), path+":"))
+	}
+	assert.Equal(t, []string{
+		`2:3: unknown key "images-by-tagregex" in the configuration of registry registry.example.com`,
+		`5:5: invalid repository name "Invalid": invalid reference format: repository name must be lowercase`,
+		`6:23: invalid tag or digest "bad tag"`,
+		`8:13: invalid semantic version constraint ">= 1.0 <": improper constraint: ">= 1.0 <"`,
+		`9:42: invalid regular expression "(": error parsing regexp: missing closing ): ` + "`(`",
+		`10:13: cannot unmarshal !!str ` + "`many`" + ` into int`,
+		`11:15: invalid value "yesterday", expected a date (YYYY-MM-DD), an RFC 3339 timestamp, or a duration`,
+		`12:15: invalid platform "linux", expected OS/ARCH[/VARIANT]`,
+		`13:30: no platforms specified for busybox`,
+		`14:16: unknown placeholder {unknown} in "{unknown}", expected one of {registry}, {repository}, {name}, {tag}, {digest}`,
+		`16:8: unknown key "regexp", expected one of regex, replacement`,
+		`16:7: regex must be specified`,
+		`17:17: unknown key "user", expected one of username, password, identitytoken`,
+		`18:15: cannot unmarshal !!str ` + "`maybe`" + ` into bool`,
+		`19:3: duplicate key "tls-verify"`,
+		`20:1: invalid registry name "https://quay.io"`,
+		`22:10: expected a mapping with the configuration of registry quay.io`,
+	}, msgs)
+
+	out, err = runSkopeo("sync", "--validate-config", path)
+	assert.ErrorContains(t, err, "17 errors found")
+	assert.Equal(t, len(configErr.errs), strings.Count(out, path+":"))
+
+	// Syntax errors
+	_, err = newSourceConfig(writeConfig("registry.example.com:\n  images: [\n"))
+	assert.ErrorContains(t, err, "line 2")
+	_, err = newSourceConfig(writeConfig("- registry.example.com\n"))
+	assert.ErrorContains(t, err, path+":1:1: expected a mapping of registry names")
+
+	// Arguments are not accepted with --validate-config
+	out, err = runSkopeo("sync", "--validate-config", path, "a", "b")
+	assertTestFailed(t, out, err, "No arguments expected")
+}
+
+func TestSyncPlan(t *testing.T) {
+	const fixture = "../../integration/fixtures/uncompressed-image-s2"
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	for _, tag := range []string{"t1", "t2"} {
+		_, err := runSkopeo("--insecure-policy", "copy", "-q", "dir:"+fixture, "oci:"+src+":"+tag)
+		require.NoError(t, err)
+	}
+	manifestDigest := readOCIIndex(t, src).Manifests[0].Digest
+	dest := filepath.Join(dir, "dest")
+	_, err := runSkopeo("--insecure-policy", "sync", "--src", "oci", "--dest", "oci", src, dest)
+	require.NoError(t, err)
+	_, err = runSkopeo("delete", "oci:"+src+":t2")
+	require.NoError(t, err)
+	_, err = runSkopeo("--insecure-policy", "copy", "-q", "dir:"+fixture, "oci:"+src+":t3")
+	require.NoError(t, err)
+	planFile := filepath.Join(dir, "plan.json")
+	readPlan := func() syncPlan {
+		data, err := os.ReadFile(planFile)
+		require.NoError(t, err)
+		var plan syncPlan
+		err = json.Unmarshal(data, &plan)
+		require.NoError(t, err)
+		return plan
+	}
+
+	// The plan is only written, nothing is changed
+	_, err = runSkopeo("--insecure-policy", "sync", "--src", "oci", "--dest", "oci", "--prune", "--dry-run", "--plan-out", planFile, src, dest)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"src:t1", "src:t2"}, ociRefNames(t, dest))
+	plan := readPlan()
+	assert.Equal(t, syncPlanSource{Transport: "oci", Source: src}, plan.Source)
+	assert.Equal(t, syncTarget{Transport: "oci", Destination: dest}, plan.Target)
+	assert.Equal(t, []syncPlanImage{
+		{Source: "oci:" + src + ":t1", SourceDigest: manifestDigest, Destination: "oci:" + dest + ":src:t1"},
+		{Source: "oci:" + src + ":t3", SourceDigest: manifestDigest, Destination: "oci:" + dest + ":src:t3"},
+	}, plan.Images)
+	assert.Equal(t, []syncPlanPrune{{Destination: "oci:" + dest + ":src:t2", Digest: manifestDigest}}, plan.Prunes)
+
+	// Images added to the source after the plan was created are not copied
+	_, err = runSkopeo("--insecure-policy", "copy", "-q", "dir:"+fixture, "oci:"+src+":t4")
+	require.NoError(t, err)
+	_, err = runSkopeo("--insecure-policy", "sync", "--plan-in", planFile)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"src:t1", "src:t3"}, ociRefNames(t, dest))
+
+	// A changed source image is refused
+	_, err = runSkopeo("--insecure-policy", "sync", "--src", "oci", "--dest", "oci", "--dry-run", "--plan-out", planFile, src, dest)
+	require.NoError(t, err)
+	_, err = runSkopeo("--insecure-policy", "copy", "-q", "--dest-compress-format", "zstd", "dir:"+fixture, "oci:"+src+":t1")
+	require.NoError(t, err)
+	_, err = runSkopeo("--insecure-policy", "sync", "--plan-in", planFile)
+	assert.ErrorContains(t, err, "Refusing to apply the plan, 1 source images changed")
+	assert.ElementsMatch(t, []string{"src:t1", "src:t3"}, ociRefNames(t, dest))
+
+	// --dry-run does not create dir: destinations, existing images are not overwritten
+	dirDest := filepath.Join(dir, "dir-dest")
+	_, err = runSkopeo("--insecure-policy", "sync", "--src", "oci", "--dest", "dir", "--dry-run", "--plan-out", planFile, src, dirDest)
+	require.NoError(t, err)
+	_, err = os.Stat(dirDest)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	_, err = runSkopeo("--insecure-policy", "sync", "--plan-in", planFile)
+	require.NoError(t, err)
+	for _, tag := range []string{"t1", "t3", "t4"} {
+		_, err = os.Stat(filepath.Join(dirDest, "src:"+tag, "manifest.json"))
+		assert.NoError(t, err)
+	}
+	_, err = runSkopeo("--insecure-policy", "sync", "--plan-in", planFile)
+	assert.ErrorContains(t, err, "Refusing to overwrite destination directory")
+
+	// --dry-run does not create OCI layouts, even if their parent directory does not exist
+	ociDest := filepath.Join(dir, "new", "oci-dest")
+	_, err = runSkopeo("--insecure-policy", "sync", "--src", "oci", "--dest", "oci", "--dry-run", "--plan-out", planFile, src, ociDest)
+	require.NoError(t, err)
+	_, err = os.Stat(filepath.Dir(ociDest))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	// Options which affect the copied images must be the same as when the plan was created
+	for _, args := range [][]string{{"--all"}, {"--format", "oci"}, {"--remove-signatures"}, {"--referrers"}} {
+		_, err = runSkopeo(append([]string{"--insecure-policy", "sync", "--plan-in", planFile}, args...)...)
+		assert.ErrorContains(t, err, "Refusing to apply the plan, it was created with different copy options", args)
+		_, err = os.Stat(filepath.Dir(ociDest))
+		assert.ErrorIs(t, err, os.ErrNotExist)
+	}
+	_, err = runSkopeo("--insecure-policy", "sync", "--plan-in", planFile)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"src:t1", "src:t3", "src:t4"}, ociRefNames(t, ociDest))
+
+	for _, c := range []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"--plan-in", planFile, src, dest}, "No arguments expected"},
+		{[]string{"--plan-in", planFile, "--src", "oci"}, "read from the plan"},
+		{[]string{"--plan-in", planFile, "--dry-run"}, "cannot be used with --plan-in"},
+		{[]string{"--plan-out", planFile, "--src", "oci", "--dest", "oci", src, dest}, "--plan-out can only be used with --dry-run"},
+		{[]string{"--plan-in", filepath.Join(dir, "missing.json")}, "reading plan"},
+	} {
+		_, err = runSkopeo(append([]string{"--insecure-policy", "sync"}, c.args...)...)
+		assert.ErrorContains(t, err, c.expected, c.args)
+	}
+}
+
+func TestSyncReport(t *testing.T) {
+	const fixture = "../../integration/fixtures/uncompressed-image-s2"
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	for _, tag := range []string{"t1", "t2"} {
+		_, err := runSkopeo("--insecure-policy", "copy", "-q", "dir:"+fixture, "oci:"+src+":"+tag)
+		require.NoError(t, err)
+	}
+	manifestDigest := readOCIIndex(t, src).Manifests[0].Digest
+	rejectPolicy := filepath.Join(dir, "policy.json")
+	err := os.WriteFile(rejectPolicy, []byte(`{"default":[{"type":"reject"}]}`), 0o644)
+	require.NoError(t, err)
+	reportFile := filepath.Join(dir, "report")
+	readReport := func() syncReport {
+		data, err := os.ReadFile(reportFile)
+		require.NoError(t, err)
+		var report syncReport
+		err = json.Unmarshal(data, &report)
+		require.NoError(t, err)
+		return report
+	}
+
+	// Copied, then unchanged according to the state file
+	dest := filepath.Join(dir, "dest")
+	stateFile := filepath.Join(dir, "state.json")
+	_, err = runSkopeo("--insecure-policy", "sync", "--src", "oci", "--dest", "oci", "--state-file", stateFile, "--report", reportFile, src, dest)
+	require.NoError(t, err)
+	report := readReport()
+	assert.Equal(t, src, report.Source)
+	assert.Equal(t, dest, report.Destination)
+	assert.Equal(t, map[string]int{"copied": 2, "unchanged": 0, "skipped": 0, "failed": 0}, report.Summary)
+	require.Len(t, report.Images, 2)
+	for i, tag := range []string{"t1", "t2"} {
+		image := report.Images[i]
+		assert.Equal(t, "oci:"+src+":"+tag, image.Source)
+		assert.Equal(t, "oci:"+dest+":src:"+tag, image.Destination)
+		assert.Equal(t, syncImageCopied, image.Status)
+		assert.Equal(t, manifestDigest, image.ManifestDigest)
+		assert.NotZero(t, image.BytesCopied)
+		assert.Empty(t, image.Error)
+	}
+	_, err = runSkopeo("--insecure-policy", "sync", "--src", "oci", "--dest", "oci", "--state-file", stateFile, "--report", reportFile, src, dest)
+	require.NoError(t, err)
+	report = readReport()
+	assert.Equal(t, map[string]int{"copied": 0, "unchanged": 2, "skipped": 0, "failed": 0}, report.Summary)
+	for _, image := range report.Images {
+		assert.Equal(t, syncImageUnchanged, image.Status)
+		assert.Equal(t, manifestDigest, image.ManifestDigest)
+		assert.Zero(t, image.BytesCopied)
+	}
+
+	// All failures are reported with --keep-going
+	_, err = runSkopeo("--policy", rejectPolicy, "sync", "--src", "oci", "--dest", "oci", "--keep-going", "--report", reportFile, src, filepath.Join(dir, "dest2"))
+	assert.Error(t, err)
+	report = readReport()
+	assert.Equal(t, map[string]int{"copied": 0, "unchanged": 0, "skipped": 0, "failed": 2}, report.Summary)
+	for _, image := range report.Images {
+		assert.Equal(t, syncImageFailed, image.Status)
+		assert.Contains(t, image.Error, "rejected by policy")
+		assert.Empty(t, image.ManifestDigest)
+	}
+
+	// Without --keep-going, the remaining images are skipped
+	_, err = runSkopeo("--policy", rejectPolicy, "sync", "--src", "oci", "--dest", "oci", "--report", reportFile, "--report-format", "junit", src, filepath.Join(dir, "dest3"))
+	assert.Error(t, err)
+	data, err := os.ReadFile(reportFile)
+	require.NoError(t, err)
+	var junit junitTestSuites
+	err = xml.Unmarshal(data, &junit)
+	require.NoError(t, err)
+	require.Len(t, junit.TestSuites, 1)
+	suite := junit.TestSuites[0]
+	assert.Equal(t, 2, suite.Tests)
+	assert.Equal(t, 1, suite.Failures)
+	assert.Equal(t, 1, suite.Skipped)
+	require.Len(t, suite.TestCases, 2)
+	assert.Equal(t, "oci:"+src+":t1", suite.TestCases[0].Name)
+	require.NotNil(t, suite.TestCases[0].Failure)
+	assert.Contains(t, suite.TestCases[0].Failure.Message, "rejected by policy")
+	assert.Equal(t, "oci:"+src+":t2", suite.TestCases[1].Name)
+	assert.NotNil(t, suite.TestCases[1].Skipped)
+
+	for _, c := range []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"--report-format", "xml"}, "unknown --report-format"},
+		{[]string{"--dry-run"}, "--report cannot be used with --dry-run"},
+	} {
+		args := slices.Concat([]string{"--insecure-policy", "sync", "--src", "oci", "--dest", "oci", "--report", reportFile}, c.args, []string{src, dest})
+		_, err = runSkopeo(args...)
+		assert.ErrorContains(t, err, c.expected, c.args)
+	}
+}
+
+func TestSyncArchive(t *testing.T) {
+	const fixture = "../../integration/fixtures/uncompressed-image-s2"
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	for _, tag := range []string{"t1", "t2"} {
+		_, err := runSkopeo("--insecure-policy", "copy", "-q", "dir:"+fixture, "oci:"+src+":"+tag)
+		require.NoError(t, err)
+	}
+	// The same image with a different manifest
+	_, err := runSkopeo("--insecure-policy", "copy", "-q", "--dest-compress-format", "gzip", "dir:"+fixture, "oci:"+src+":t3")
+	require.NoError(t, err)
+	tmpDir := filepath.Join(dir, "tmp")
+	err = os.Mkdir(tmpDir, 0o755)
+	require.NoError(t, err)
+	expectedNames := []string{"src:t1", "src:t2", "src:t3"}
+
+	// docker-archive, which stores each image only once
+	dockerArchive := filepath.Join(dir, "images.tar")
+	_, err = runSkopeo("--insecure-policy", "sync", "--src", "oci", "--dest", "docker-archive", src, dockerArchive)
+	require.NoError(t, err)
+	out, err := runSkopeo("list-tags", "docker-archive:"+dockerArchive)
+	require.NoError(t, err)
+	var tags struct{ Tags []string }
+	err = json.Unmarshal([]byte(out), &tags)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"docker.io/library/src:t1", "docker.io/library/src:t2", "docker.io/library/src:t3"}, tags.Tags)
+	dest := filepath.Join(dir, "from-docker-archive")
+	_, err = runSkopeo("--insecure-policy", "sync", "--src", "docker-archive", "--dest", "oci", dockerArchive, dest)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, expectedNames, ociRefNames(t, dest))
+
+	// oci-archive
+	ociArchive := filepath.Join(dir, "images-oci.tar")
+	_, err = runSkopeo("--insecure-policy", "--tmpdir", tmpDir, "sync", "--src", "oci", "--dest", "oci-archive", src, ociArchive)
+	require.NoError(t, err)
+	dest = filepath.Join(dir, "from-oci-archive")
+	_, err = runSkopeo("--insecure-policy", "--tmpdir", tmpDir, "sync", "--src", "oci-archive", "--dest", "dir", ociArchive, dest)
+	require.NoError(t, err)
+	for _, name := range expectedNames {
+		_, err = os.Stat(filepath.Join(dest, name, "manifest.json"))
+		assert.NoError(t, err)
+	}
+	tmpContents, err := os.ReadDir(tmpDir)
+	require.NoError(t, err)
+	assert.Empty(t, tmpContents)
+
+	for _, c := range []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"--dest", "docker-archive", src, dockerArchive}, "Refusing to overwrite destination archive"},
+		{[]string{"--dest", "oci-archive", src, ociArchive}, "Refusing to overwrite destination archive"},
+		{[]string{"--dest", "docker-archive", "--prune", src, filepath.Join(dir, "new.tar")}, "--prune is not supported with --dest docker-archive"},
+		{[]string{"--dest", "oci-archive", "--state-file", filepath.Join(dir, "state.json"), src, filepath.Join(dir, "new.tar")}, "not supported with the docker-archive and oci-archive transports"},
+	} {
+		_, err = runSkopeo(append([]string{"--insecure-policy", "sync", "--src", "oci"}, c.args...)...)
+		assert.ErrorContains(t, err, c.expected, c.args)
+	}
+	_, err = os.Stat(filepath.Join(dir, "new.tar"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestSyncContainersStorage(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Rootless containers-storage requires re-executing in a user namespace")
+	}
+	const fixture = "../../integration/fixtures/uncompressed-image-s2"
+	dir := t.TempDir()
+	storageConf := filepath.Join(dir, "storage.conf")
+	err := os.WriteFile(storageConf, fmt.Appendf(nil, "[storage]\ndriver = \"vfs\"\ngraphroot = %q\nrunroot = %q\n",
+		filepath.Join(dir, "root"), filepath.Join(dir, "run")), 0o644)
+	require.NoError(t, err)
+	t.Setenv("CONTAINERS_STORAGE_CONF", storageConf)
+	src := filepath.Join(dir, "src")
+	for _, tag := range []string{"t1", "t2"} {
+		_, err := runSkopeo("--insecure-policy", "copy", "-q", "dir:"+fixture, "oci:"+src+":"+tag)
+		require.NoError(t, err)
+	}
+
+	_, err = runSkopeo("--insecure-policy", "sync", "--src", "oci", "--dest", "containers-storage", src, "registry.example.com/mirror")
+	require.NoError(t, err)
+	dest := filepath.Join(dir, "all")
+	_, err = runSkopeo("--insecure-policy", "sync", "--scoped", "--src", "containers-storage", "--dest", "oci", "", dest)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"registry.example.com/mirror/src:t1", "registry.example.com/mirror/src:t2"}, ociRefNames(t, dest))
+	dest = filepath.Join(dir, "single")
+	_, err = runSkopeo("--insecure-policy", "sync", "--src", "containers-storage", "--dest", "oci", "registry.example.com/mirror/src:t2", dest)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"src:t2"}, ociRefNames(t, dest))
+
+	for _, c := range []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"--src", "containers-storage", "--dest", "oci", "registry.example.com/other", filepath.Join(dir, "other")}, "No images to sync found"},
+		{[]string{"--src", "containers-storage", "--dest", "oci", "registry.example.com/mirror/src@sha256:" + strings.Repeat("0", 64), filepath.Join(dir, "other")}, "images referenced by digest are not supported"},
+		{[]string{"--src", "containers-storage", "--dest", "containers-storage", "", "other"}, "sync from 'containers-storage' to 'containers-storage' is not supported"},
+		{[]string{"--src", "oci", "--dest", "containers-storage", "--prune", src, "other"}, "--prune is not supported with --dest containers-storage"},
+	} {
+		_, err = runSkopeo(append([]string{"--insecure-policy", "sync"}, c.args...)...)
+		assert.ErrorContains(t, err, c.expected, c.args)
+	}
+}
+
+func TestSyncWatch(t *testing.T) {
+	const fixture = "../../integration/fixtures/uncompressed-image-s2"
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	dest := filepath.Join(dir, "dest")
+	_, err := runSkopeo("--insecure-policy", "copy", "-q", "dir:"+fixture, "oci:"+src+":t1")
+	require.NoError(t, err)
+	// The index may be read while it is being written, so don't fail on invalid contents.
+	destNames := func() []string {
+		var index imgspecv1.Index
+		data, err := os.ReadFile(filepath.Join(dest, "index.json"))
+		if err != nil || json.Unmarshal(data, &index) != nil {
+			return nil
+		}
+		var res []string
+		for _, desc := range index.Manifests {
+			res = append(res, desc.Annotations[imgspecv1.AnnotationRefName])
+		}
+		slices.Sort(res)
+		return res
+	}
+
+	// startWatch runs sync --watch with args, and returns a function which stops it using SIGTERM.
+	// The signal handler is installed before the first sync, so SIGTERM may only be sent after a sync has finished.
+	startWatch := func(args ...string) func() {
+		watchErr := make(chan error, 1)
+		go func() {
+			_, err := runSkopeo(append([]string{"--insecure-policy", "sync", "--watch", "--interval", "100ms"}, args...)...)
+			watchErr <- err
+		}()
+		return func() {
+			self, err := os.FindProcess(os.Getpid())
+			require.NoError(t, err)
+			err = self.Signal(syscall.SIGTERM)
+			require.NoError(t, err)
+			select {
+			case err := <-watchErr:
+				assert.NoError(t, err)
+			case <-time.After(10 * time.Second):
+				t.Fatal("sync --watch did not stop after SIGTERM")
+			}
+		}
+	}
+
+	stop := startWatch("--src", "oci", "--dest", "oci", src, dest)
+	require.Eventually(t, func() bool { return slices.Equal(destNames(), []string{"src:t1"}) }, 10*time.Second, 50*time.Millisecond)
+	_, err = runSkopeo("--insecure-policy", "copy", "-q", "dir:"+fixture, "oci:"+src+":t2")
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return slices.Equal(destNames(), []string{"src:t1", "src:t2"}) }, 10*time.Second, 50*time.Millisecond)
+	stop()
+
+	// Unchanged images are copied again after a reload of the YAML configuration changes their destination.
+	manifest, err := os.ReadFile(filepath.Join(fixture, "manifest.json"))
+	require.NoError(t, err)
+	var mutex sync.Mutex
+	manifestDigest := digest.FromBytes(manifest)
+	registryName := newSyncTestRegistry(t, fixture, &mutex, map[string]digest.Digest{"latest": manifestDigest},
+		map[digest.Digest][]byte{manifestDigest: manifest})
+	yamlFile := filepath.Join(dir, "sync.yaml")
+	writeYAML := func(destination string) {
+		err := os.WriteFile(yamlFile, fmt.Appendf(nil, "%s:\n  tls-verify: false\n  destination: %q\n  images:\n    app: [latest]\n",
+			registryName, destination), 0o644)
+		require.NoError(t, err)
+	}
+	writeYAML("first/{repository}")
+	dest = filepath.Join(dir, "yaml-dest")
+	stop = startWatch("--src", "yaml", "--dest", "oci", yamlFile, dest)
+	require.Eventually(t, func() bool { return slices.Equal(destNames(), []string{"first/app:latest"}) }, 10*time.Second, 50*time.Millisecond)
+	writeYAML("second/{repository}")
+	require.Eventually(t, func() bool { return slices.Equal(destNames(), []string{"first/app:latest", "second/app:latest"}) }, 10*time.Second, 50*time.Millisecond)
+	stop()
+
+	for _, c := range []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"--dest", "oci", "--dry-run", src, dest}, "--dry-run, --plan-out and --plan-in cannot be used with --watch"},
+		{[]string{"--dest", "oci", "--interval", "0s", src, dest}, "--interval must be positive"},
+		{[]string{"--dest", "docker-archive", src, filepath.Join(dir, "images.tar")}, "--watch is not supported with --dest docker-archive"},
+	} {
+		_, err = runSkopeo(append([]string{"--insecure-policy", "sync", "--watch", "--src", "oci"}, c.args...)...)
+		assert.ErrorContains(t, err, c.expected, c.args)
+	}
+}
+
+func TestReloadSourceConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sync.yaml")
+	writeConfig := func(contents string) digest.Digest {
+		err := os.WriteFile(path, []byte(contents), 0o644)
+		require.NoError(t, err)
+		return digest.FromString(contents)
+	}
+
+	d1 := writeConfig("registry.example.com:\n  images:\n    busybox: []\n")
+	cfg, err := newSourceConfig(path)
+	require.NoError(t, err)
+
+	// Unchanged
+	res, resDigest := reloadSourceConfig(path, cfg, d1)
+	assert.Equal(t, cfg, res)
+	assert.Equal(t, d1, resDigest)
+
+	// Changed
+	d2 := writeConfig("registry.example.com:\n  images:\n    alpine: []\n")
+	res, resDigest = reloadSourceConfig(path, cfg, d1)
+	assert.Equal(t, d2, resDigest)
+	assert.Contains(t, res["registry.example.com"].Images, "alpine")
+	assert.NotContains(t, res["registry.example.com"].Images, "busybox")
+	cfg = res
+
+	// Invalid, or missing: the previous configuration is kept
+	writeConfig("registry.example.com:\n  images:\n    alpine: [\"@invalid\"]\n")
+	_, err = newSourceConfig(path)
+	require.Error(t, err)
+	res, resDigest = reloadSourceConfig(path, cfg, d2)
+	assert.Equal(t, cfg, res)
+	assert.Equal(t, d2, resDigest)
+	err = os.Remove(path)
+	require.NoError(t, err)
+	res, resDigest = reloadSourceConfig(path, cfg, d2)
+	assert.Equal(t, cfg, res)
+	assert.Equal(t, d2, resDigest)
+}
+
+// newSyncTestRegistry starts a registry with a single repository "app", serving tags, manifests and the blobs of fixture,
+// and returns its host name. tags and manifests may be modified while holding mutex.
+func newSyncTestRegistry(t *testing.T, fixture string, mutex *sync.Mutex, tags map[string]digest.Digest, manifests map[digest.Digest][]byte) string {
+	mux := http.NewServeMux()
+	server := httptest.NewTLSServer(mux)
+	t.Cleanup(server.Close)
+	mux.HandleFunc("/v2/", func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		switch {
+		case r.URL.Path == "/v2/":
+		case r.URL.Path == "/v2/app/tags/list":
+			err := json.NewEncoder(w).Encode(map[string]any{"name": "app", "tags": slices.Sorted(maps.Keys(tags))})
+			assert.NoError(t, err)
+		case strings.HasPrefix(r.URL.Path, "/v2/app/manifests/"):
+			ref := strings.TrimPrefix(r.URL.Path, "/v2/app/manifests/")
+			d, ok := tags[ref]
+			if !ok {
+				d = digest.Digest(ref)
+			}
+			data, ok := manifests[d]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", "application/vnd.docker.distribution.manifest.v2+json")
+			w.Header().Set("Docker-Content-Digest", d.String())
+			w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+			if r.Method != http.MethodHead {
+				_, err := w.Write(data)
+				assert.NoError(t, err)
+			}
+		case strings.HasPrefix(r.URL.Path, "/v2/app/blobs/sha256:"):
+			http.ServeFile(w, r, filepath.Join(fixture, strings.TrimPrefix(r.URL.Path, "/v2/app/blobs/sha256:")))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	return strings.TrimPrefix(server.URL, "https://")
+}
+
+func TestSyncLockfile(t *testing.T) {
+	const fixture = "../../integration/fixtures/uncompressed-image-s2"
+	manifest1, err := os.ReadFile(filepath.Join(fixture, "manifest.json"))
+	require.NoError(t, err)
+	manifest2 := append(slices.Clone(manifest1), '\n') // The same image with a different manifest digest
+	digest1, digest2 := digest.FromBytes(manifest1), digest.FromBytes(manifest2)
+
+	var mutex sync.Mutex
+	tags := map[string]digest.Digest{"1.0": digest1, "1.1": digest1, "latest": digest1}
+	manifests := map[digest.Digest][]byte{digest1: manifest1, digest2: manifest2}
+	registryName := newSyncTestRegistry(t, fixture, &mutex, tags, manifests)
+
+	dir := t.TempDir()
+	yamlFile := filepath.Join(dir, "sync.yaml")
+	err = os.WriteFile(yamlFile, fmt.Appendf(nil, `%s:
+  tls-verify: false
+  images-by-tag-regex:
+    app: ^1\.
+`, registryName), 0o644)
+	require.NoError(t, err)
+	lockfilePath := filepath.Join(dir, "lockfile.json")
+
+	_, err = runSkopeo("--insecure-policy", "sync", "--dry-run", "--src", "yaml", "--dest", "dir", "--lockfile-out", lockfilePath, yamlFile, filepath.Join(dir, "unused"))
+	require.NoError(t, err)
+	lockfile, err := loadSyncLockfile(lockfilePath)
+	require.NoError(t, err)
+	assert.Equal(t, []syncLockedImage{
+		{Registry: registryName, Repository: "app", Tag: "1.0", Digest: digest1},
+		{Registry: registryName, Repository: "app", Tag: "1.1", Digest: digest1},
+	}, lockfile.Images)
+
+	// Tags changed at the source after the lockfile was written are not copied
+	mutex.Lock()
+	tags["1.1"], tags["1.2"] = digest2, digest2
+	mutex.Unlock()
+	dest := filepath.Join(dir, "dest")
+	_, err = runSkopeo("--insecure-policy", "sync", "--src", "yaml", "--dest", "dir", "--lockfile", lockfilePath, yamlFile, dest)
+	require.NoError(t, err)
+	entries, err := os.ReadDir(dest)
+	require.NoError(t, err)
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+		data, err := os.ReadFile(filepath.Join(dest, e.Name(), "manifest.json"))
+		require.NoError(t, err)
+		assert.Equal(t, manifest1, data, e.Name())
+	}
+	assert.Equal(t, []string{"app:1.0", "app:1.1"}, names)
+
+	// Refuse to sync if a locked image is no longer available
+	mutex.Lock()
+	delete(manifests, digest1)
+	mutex.Unlock()
+	dest = filepath.Join(dir, "dest2")
+	_, err = runSkopeo("--insecure-policy", "sync", "--src", "yaml", "--dest", "dir", "--lockfile", lockfilePath, yamlFile, dest)
+	assert.ErrorContains(t, err, "2 locked images are no longer available at the source")
+	_, err = os.Stat(dest)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	for _, c := range []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"--src", "oci", "--lockfile", lockfilePath, dir, dest}, "--lockfile and --lockfile-out can only be used with --src yaml"},
+		{[]string{"--src", "yaml", "--lockfile", lockfilePath, "--lockfile-out", lockfilePath, yamlFile, dest}, "--lockfile and --lockfile-out cannot be used together"},
+		{[]string{"--src", "yaml", "--lockfile", filepath.Join(dir, "missing.json"), yamlFile, dest}, "reading lockfile"},
+	} {
+		_, err = runSkopeo(append([]string{"--insecure-policy", "sync", "--dest", "dir"}, c.args...)...)
+		assert.ErrorContains(t, err, c.expected, c.args)
+	}
+}
+
+func TestSyncHooks(t *testing.T) {
+	const fixture = "../../integration/fixtures/uncompressed-image-s2"
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	for _, tag := range []string{"t1", "t2"} {
+		_, err := runSkopeo("--insecure-policy", "copy", "-q", "dir:"+fixture, "oci:"+src+":"+tag)
+		require.NoError(t, err)
+	}
+	fixtureDigest := readOCIIndex(t, src).Manifests[0].Digest
+
+	var mutex sync.Mutex
+	var events []syncHookEvent
+	webhookStatus := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		var event syncHookEvent
+		err := json.NewDecoder(r.Body).Decode(&event)
+		assert.NoError(t, err)
+		mutex.Lock()
+		defer mutex.Unlock()
+		events = append(events, event)
+		w.WriteHeader(webhookStatus)
+	}))
+	defer server.Close()
+
+	commandOutput := filepath.Join(dir, "command-output")
+	command := `echo "$SKOPEO_SYNC_EVENT $SKOPEO_SYNC_SOURCE $SKOPEO_SYNC_DESTINATION $SKOPEO_SYNC_DIGEST $SKOPEO_SYNC_SYNCED $SKOPEO_SYNC_FAILED" >> ` + commandOutput
+	dest := filepath.Join(dir, "dest")
+	_, err := runSkopeo("--insecure-policy", "sync", "--src", "oci", "--dest", "oci", "--on-image-synced", command, "--webhook", server.URL, src, dest)
+	require.NoError(t, err)
+
+	output, err := os.ReadFile(commandOutput)
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf(`image-synced oci:%[1]s:t1 oci:%[2]s:src:t1 %[3]s  
+image-synced oci:%[1]s:t2 oci:%[2]s:src:t2 %[3]s  
+sync-finished %[1]s %[2]s  2 0
+`, src, dest, fixtureDigest), string(output))
+	require.Len(t, events, 3)
+	for i, tag := range []string{"t1", "t2"} {
+		assert.Equal(t, syncHookImageSynced, events[i].Event)
+		assert.Equal(t, fmt.Sprintf("oci:%s:%s", src, tag), events[i].Source)
+		assert.Equal(t, fmt.Sprintf("oci:%s:src:%s", dest, tag), events[i].Destination)
+		assert.Equal(t, fixtureDigest, events[i].Digest)
+		assert.Nil(t, events[i].Summary)
+	}
+	assert.Equal(t, syncHookSyncFinished, events[2].Event)
+	assert.Equal(t, src, events[2].Source)
+	assert.Equal(t, dest, events[2].Destination)
+	assert.Equal(t, &syncHookSummary{Synced: 2}, events[2].Summary)
+
+	// Failing hooks fail the sync, unless --ignore-hook-failures is used
+	for _, hookArgs := range [][]string{
+		{"--on-image-synced", "exit 1"},
+		{"--webhook", server.URL},
+	} {
+		mutex.Lock()
+		webhookStatus, events = http.StatusInternalServerError, nil
+		mutex.Unlock()
+		dest := filepath.Join(dir, "failing")
+		err := os.RemoveAll(dest)
+		require.NoError(t, err)
+		args := append([]string{"--insecure-policy", "sync", "--src", "oci", "--dest", "oci"}, hookArgs...)
+		_, err = runSkopeo(append(args, src, dest)...)
+		assert.Error(t, err, hookArgs)
+		err = os.RemoveAll(dest)
+		require.NoError(t, err)
+		_, err = runSkopeo(append(args, "--ignore-hook-failures", src, dest)...)
+		assert.NoError(t, err, hookArgs)
+	}
+	mutex.Lock()
+	require.NotEmpty(t, events)
+	finished := events[len(events)-1]
+	mutex.Unlock()
+	assert.Equal(t, &syncHookSummary{Synced: 2}, finished.Summary)
+
+	_, err = runSkopeo("--insecure-policy", "sync", "--src", "oci", "--dest", "oci", "--webhook", "ftp://example.com", src, dest)
+	assert.ErrorContains(t, err, "expected an http or https URL")
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/sirupsen/logrus"
+	"go.podman.io/image/v5/signature"
+)
+
+// watchSync calls syncOnce every opts.watchInterval, until the process receives SIGTERM or SIGINT.
+// After such a signal, the copies already started are finished, and no further images are copied.
+// Failures of individual syncs are logged, and the sync is attempted again after the next interval.
+// The YAML source configuration is read again for every sync, so changes to it are applied without a restart;
+// if the changed configuration can't be read or is invalid, the error is logged and the previous configuration is used.
+func (opts *syncOptions) watchSync(args []string, stdout io.Writer, policy *signature.Policy, target syncTarget, state *syncState) error {
+	var cfg sourceConfig
+	var configDigest digest.Digest
+	if opts.source == "yaml" {
+		// Refuse to start with an invalid configuration.
+		data, err := os.ReadFile(args[0])
+		if err != nil {
+			return err
+		}
+		cfg, err = parseSourceConfig(args[0], data)
+		if err != nil {
+			return err
+		}
+		configDigest = digest.FromBytes(data)
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	defer close(done)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	defer signal.Stop(signals)
+	go func() {
+		select {
+		case sig := <-signals:
+			logrus.Infof("Received %s, stopping after the current images are copied", sig)
+			close(stop)
+		case <-done:
+		}
+	}()
+
+	logrus.Infof("Watching %s:%s, syncing every %s", opts.source, args[0], opts.watchInterval)
+	for {
+		start := time.Now()
+		if opts.source == "yaml" {
+			cfg, configDigest = reloadSourceConfig(args[0], cfg, configDigest)
+		}
+		if err := opts.syncOnce(args, stdout, policy, nil, cfg, target, state, stop); err != nil {
+			logrus.WithError(err).Errorf("Sync failed, trying again in %s", time.Until(start.Add(opts.watchInterval)).Round(time.Second))
+		}
+
+		timer := time.NewTimer(time.Until(start.Add(opts.watchInterval)))
+		select {
+		case <-stop:
+			timer.Stop()
+			logrus.Info("Stopped watching")
+			return nil
+		case <-timer.C:
+		}
+	}
+}
+
+// reloadSourceConfig reads the YAML source configuration in path again, if it changed since it was read with configDigest.
+// It returns the changed configuration and its digest, or cfg and configDigest if the configuration is unchanged,
+// can't be read, or is invalid.
+func reloadSourceConfig(path string, cfg sourceConfig, configDigest digest.Digest) (sourceConfig, digest.Digest) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		logrus.WithError(err).Errorf("Reading source configuration %s failed, using the previous configuration", path)
+		return cfg, configDigest
+	}
+	d := digest.FromBytes(data)
+	if d == configDigest {
+		return cfg, configDigest
+	}
+	newCfg, err := parseSourceConfig(path, data)
+	if err != nil {
+		logrus.WithError(err).Errorf("Source configuration %s changed, but is invalid; using the previous configuration", path)
+		return cfg, configDigest
+	}
+	logrus.Infof("Source configuration %s changed, reloading", path)
+	return newCfg, d
+}
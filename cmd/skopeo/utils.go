@@ -38,6 +38,20 @@ type errorShouldDisplayUsage struct {
 	error
 }
 
+// errorWithExitCode is an error which causes skopeo to exit with a specific exit code.
+type errorWithExitCode struct {
+	err      error
+	exitCode int
+}
+
+func (e errorWithExitCode) Error() string {
+	return e.err.Error()
+}
+
+func (e errorWithExitCode) Unwrap() error {
+	return e.err
+}
+
 // noteCloseFailure returns (possibly-nil) err modified to account for (non-nil) closeErr.
 // The error for closeErr is annotated with description (which is not a format string)
 // Typical usage:
@@ -339,6 +353,11 @@ type sharedCopyOptions struct {
 	signPassphraseFile       string                    // Path pointing to a passphrase file when signing
 	preserveDigests          bool                      // Preserve digests during copy
 	format                   commonFlag.OptionalString // Force conversion of the image to a specified format
+	progress                 string                    // Format of machine-readable progress output, or "" for human-readable output
+	progressFD               commonFlag.OptionalInt    // File descriptor to write machine-readable progress output to, instead of stdout
+	progressInterval         time.Duration             // Interval between machine-readable reports of blob progress
+	referrers                bool                      // Copy OCI referrers of copied images
+	referrersArtifactTypes   []string                  // If not empty, only copy referrers with one of these artifact types
 }
 
 // sharedCopyFlags prepares a collection of CLI flags writing into sharedCopyoptions.
@@ -353,12 +372,20 @@ func sharedCopyFlags() (pflag.FlagSet, *sharedCopyOptions) {
 	fs.StringVar(&opts.signPassphraseFile, "sign-passphrase-file", "", "Read a passphrase for signing an image from `PATH`")
 	fs.VarP(commonFlag.NewOptionalStringValue(&opts.format), "format", "f", `MANIFEST TYPE (oci, v2s1, or v2s2) to use in the destination (default is manifest type of source, with fallbacks)`)
 	fs.BoolVar(&opts.preserveDigests, "preserve-digests", false, "Preserve digests of images and lists")
+	fs.StringVar(&opts.progress, "progress", "", "Write machine-readable progress in `FORMAT` (json) instead of human-readable progress")
+	fs.Var(commonFlag.NewOptionalIntValue(&opts.progressFD), "progress-fd", "Write machine-readable progress to file descriptor `FD` instead of stdout")
+	fs.DurationVar(&opts.progressInterval, "progress-interval", time.Second, "Interval between machine-readable reports of blob progress")
+	fs.BoolVar(&opts.referrers, "referrers", false, "Also copy OCI referrers (e.g. SBOMs, attestations, signatures) of copied images")
+	fs.StringSliceVar(&opts.referrersArtifactTypes, "referrers-artifact-type", []string{}, "Only copy referrers with artifact `TYPE` (can be specified multiple times)")
 	return fs, &opts
 }
 
 // copyOptions interprets opts, returns a partially-filled *copy.Options,
 // and a function that should be called to clean up.
 func (opts *sharedCopyOptions) copyOptions(stdout io.Writer) (*copy.Options, func(), error) {
+	if len(opts.referrersArtifactTypes) > 0 && !opts.referrers {
+		return nil, nil, errors.New("--referrers-artifact-type can only be used with --referrers")
+	}
 	var manifestType string
 	if opts.format.Present() {
 		mt, err := parseManifestFormat(opts.format.Value())
@@ -546,6 +573,16 @@ func adjustUsage(c *cobra.Command) {
 	c.DisableFlagsInUseLine = true
 }
 
+// groupUsageTemplate is usageTemplate for commands which only group subcommands, e.g. (skopeo policy):
+// it indents the subcommands like the main skopeo command does, which hack/xref-helpmsgs-manpages relies on.
+var groupUsageTemplate = strings.Replace(usageTemplate, "\n{{rpad .Name", "\n  {{rpad .Name", 1)
+
+// adjustGroupUsage is adjustUsage for commands which only group subcommands.
+func adjustGroupUsage(c *cobra.Command) {
+	adjustUsage(c)
+	c.SetUsageTemplate(groupUsageTemplate)
+}
+
 // promptForPassphrase interactively prompts for a passphrase related to privateKeyFile
 func promptForPassphrase(privateKeyFile string, stdin, stdout *os.File) (string, error) {
 	stdinFd := int(stdin.Fd())
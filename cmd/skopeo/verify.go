@@ -0,0 +1,600 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"go.podman.io/common/pkg/retry"
+	"go.podman.io/image/v5/docker"
+	"go.podman.io/image/v5/docker/reference"
+	"go.podman.io/image/v5/image"
+	"go.podman.io/image/v5/manifest"
+	"go.podman.io/image/v5/pkg/blobinfocache/none"
+	"go.podman.io/image/v5/signature"
+	"go.podman.io/image/v5/transports"
+	"go.podman.io/image/v5/transports/alltransports"
+	"go.podman.io/image/v5/types"
+)
+
+// exitCodePolicyRejected is the exit code of (skopeo verify) if the image is rejected by the policy.
+const exitCodePolicyRejected = 3
+
+type verifyOptions struct {
+	global    *globalOptions
+	image     *imageOptions
+	retryOpts *retry.Options
+	format    string // Output format, "text" or "json"
+}
+
+func verifyCmd(global *globalOptions) *cobra.Command {
+	sharedFlags, sharedOpts := sharedImageFlags()
+	imageFlags, imageOpts := imageFlags(global, sharedOpts, nil, "", "")
+	retryFlags, retryOpts := retryFlags()
+	opts := verifyOptions{
+		global:    global,
+		image:     imageOpts,
+		retryOpts: retryOpts,
+	}
+	cmd := &cobra.Command{
+		Use:   "verify [command options] IMAGE-NAME",
+		Short: "Check whether the trust policy accepts IMAGE-NAME",
+		Long: fmt.Sprintf(`Evaluate the signature verification policy for "IMAGE-NAME" without copying it,
+and report the result of each policy requirement and the signatures found.
+
+Exits with status 3 if the policy rejects the image.
+
+Supported transports:
+%s
+
+See skopeo(1) section "IMAGE NAMES" for the expected format
+`, strings.Join(transports.ListNames(), ", ")),
+		RunE: commandAction(opts.run),
+		Example: `skopeo verify docker://registry.example.com/example/busybox:latest
+skopeo --policy ./policy.json verify --format json docker://registry.example.com/example/busybox:latest`,
+		ValidArgsFunction: autocompleteImageNames,
+	}
+	adjustUsage(cmd)
+	flags := cmd.Flags()
+	flags.AddFlagSet(&sharedFlags)
+	flags.AddFlagSet(&imageFlags)
+	flags.AddFlagSet(&retryFlags)
+	flags.StringVar(&opts.format, "format", "text", "Output the result in `FORMAT` (text or json)")
+	return cmd
+}
+
+// verifyResult is the output of (skopeo verify).
+type verifyResult struct {
+	Image          string              `json:"image"`
+	ManifestDigest digest.Digest       `json:"manifestDigest"`
+	Allowed        bool                `json:"allowed"`
+	Error          string              `json:"error,omitempty"` // Set if !Allowed
+	Scope          policyScope         `json:"scope"`
+	Requirements   []requirementResult `json:"requirements"`
+	Signatures     []signatureInfo     `json:"signatures"`
+}
+
+// requirementResult is the result of evaluating a single policy requirement.
+type requirementResult struct {
+	Type        string             `json:"type"`
+	Requirement json.RawMessage    `json:"requirement"`
+	Allowed     bool               `json:"allowed"`
+	Error       string             `json:"error,omitempty"`
+	Signatures  []signatureVerdict `json:"signatures,omitempty"` // Only set for requirements which evaluate individual signatures
+}
+
+// signatureVerdict is the result of evaluating a single signature against a single requirement.
+type signatureVerdict struct {
+	Index          int    `json:"index"` // Index into verifyResult.Signatures
+	Accepted       bool   `json:"accepted"`
+	KeyFingerprint string `json:"keyFingerprint,omitempty"` // The trusted key which cryptographically verified the signature, if any
+	Identity       string `json:"identity,omitempty"`       // The verified signer identity of a sigstore certificate, if any
+	Error          string `json:"error,omitempty"`
+}
+
+// signatureInfo describes a signature found for the image. All of its contents are unverified.
+type signatureInfo struct {
+	Format                string     `json:"format"`
+	ClaimedIdentity       string     `json:"claimedIdentity,omitempty"`
+	ClaimedManifestDigest string     `json:"claimedManifestDigest,omitempty"`
+	KeyIdentifier         string     `json:"keyIdentifier,omitempty"`
+	Creator               string     `json:"creator,omitempty"`
+	Timestamp             *time.Time `json:"timestamp,omitempty"`
+	Error                 string     `json:"error,omitempty"` // Set if the signature could not be parsed
+
+	raw         []byte            // The simple signing signature, if Format is signatureFormatSimpleSigning
+	payload     []byte            // The payload, if Format is signatureFormatSigstore
+	annotations map[string]string // The attachment layer annotations, if Format is signatureFormatSigstore
+}
+
+// Values of signatureInfo.Format
+const (
+	signatureFormatSimpleSigning = "simple-signing"
+	signatureFormatSigstore      = "sigstore"
+)
+
+func (opts *verifyOptions) run(args []string, stdout io.Writer) (retErr error) {
+	ctx, cancel := opts.global.commandTimeoutContext()
+	defer cancel()
+
+	if len(args) != 1 {
+		return errorShouldDisplayUsage{errors.New("Exactly one argument expected")}
+	}
+	if opts.format != "text" && opts.format != "json" {
+		return fmt.Errorf("unknown output format %q. Choose one of the supported formats: 'text', 'json'", opts.format)
+	}
+	imageName := args[0]
+
+	if err := reexecIfNecessaryForImages(imageName); err != nil {
+		return err
+	}
+
+	policy, err := opts.global.getPolicy()
+	if err != nil {
+		return fmt.Errorf("Error loading trust policy: %w", err)
+	}
+	policyContext, err := opts.global.newPolicyContext(policy)
+	if err != nil {
+		return fmt.Errorf("Error loading trust policy: %w", err)
+	}
+	defer func() {
+		if err := policyContext.Destroy(); err != nil {
+			retErr = noteCloseFailure(retErr, "tearing down policy context", err)
+		}
+	}()
+
+	ref, err := alltransports.ParseImageName(imageName)
+	if err != nil {
+		return fmt.Errorf("Invalid image name %s: %v", imageName, err)
+	}
+	sys, err := opts.image.newSystemContext()
+	if err != nil {
+		return err
+	}
+
+	var src types.ImageSource
+	if err := retry.IfNecessary(ctx, func() error {
+		src, err = ref.NewImageSource(ctx, sys)
+		return err
+	}, opts.retryOpts); err != nil {
+		return fmt.Errorf("Error opening image %q: %w", imageName, err)
+	}
+	defer func() {
+		if err := src.Close(); err != nil {
+			retErr = noteCloseFailure(retErr, "closing image", err)
+		}
+	}()
+	unparsed := image.UnparsedInstance(src, nil)
+
+	var result *verifyResult
+	if err := retry.IfNecessary(ctx, func() error {
+		result, err = verifyImage(ctx, sys, policy, policyContext, unparsed)
+		return err
+	}, opts.retryOpts); err != nil {
+		return err
+	}
+
+	if opts.format == "json" {
+		out, err := json.MarshalIndent(result, "", "    ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "%s\n", string(out))
+	} else {
+		result.writeText(stdout)
+	}
+	if !result.Allowed {
+		return errorWithExitCode{
+			err:      fmt.Errorf("Image %s rejected by policy: %s", transports.ImageName(ref), result.Error),
+			exitCode: exitCodePolicyRejected,
+		}
+	}
+	return nil
+}
+
+// verifyImage evaluates policy for unparsed, using policyContext (created from policy) for the overall decision.
+func verifyImage(ctx context.Context, sys *types.SystemContext, policy *signature.Policy, policyContext *signature.PolicyContext,
+	unparsed types.UnparsedImage,
+) (*verifyResult, error) {
+	ref := unparsed.Reference()
+	manifestBytes, _, err := unparsed.Manifest(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading manifest: %w", err)
+	}
+	manifestDigest, err := manifest.Digest(manifestBytes)
+	if err != nil {
+		return nil, err
+	}
+	res := &verifyResult{
+		Image:          transports.ImageName(ref),
+		ManifestDigest: manifestDigest,
+	}
+
+	allowed, err := policyContext.IsRunningImageAllowed(ctx, unparsed)
+	res.Allowed = allowed
+	if err != nil {
+		var prError signature.PolicyRequirementError
+		if !errors.As(err, &prError) && !allowed {
+			// Not a policy decision, but a failure to evaluate the policy (e.g. a network error).
+			return nil, fmt.Errorf("Error evaluating policy: %w", err)
+		}
+		res.Error = err.Error()
+	}
+
+	res.Signatures, err = imageSignatures(ctx, sys, unparsed, manifestDigest)
+	if err != nil {
+		return nil, err
+	}
+
+	var requirements signature.PolicyRequirements
+	res.Scope, requirements = policyRequirementsForImage(policy, ref)
+	for _, req := range requirements {
+		rr, err := evaluateRequirement(ctx, req, unparsed, res.Signatures)
+		if err != nil {
+			return nil, err
+		}
+		res.Requirements = append(res.Requirements, rr)
+	}
+	if res.Requirements == nil {
+		res.Requirements = []requirementResult{}
+	}
+	return res, nil
+}
+
+// requirementFields contains the fields of a marshaled signature.PolicyRequirement which we need to inspect.
+type requirementFields struct {
+	Type     string   `json:"type"`
+	KeyType  string   `json:"keyType"`
+	KeyPath  string   `json:"keyPath"`
+	KeyPaths []string `json:"keyPaths"`
+	KeyData  []byte   `json:"keyData"`
+}
+
+// evaluateRequirement evaluates a single requirement for unparsed, with signatures found by imageSignatures.
+func evaluateRequirement(ctx context.Context, req signature.PolicyRequirement, unparsed types.UnparsedImage,
+	signatures []signatureInfo,
+) (requirementResult, error) {
+	reqJSON, err := json.Marshal(req)
+	if err != nil {
+		return requirementResult{}, err
+	}
+	var fields requirementFields
+	if err := json.Unmarshal(reqJSON, &fields); err != nil {
+		return requirementResult{}, err
+	}
+	res := requirementResult{
+		Type:        fields.Type,
+		Requirement: reqJSON,
+	}
+
+	allowed, err := isAllowedBySingleRequirement(ctx, req, unparsed)
+	if err != nil {
+		var prError signature.PolicyRequirementError
+		if !errors.As(err, &prError) && !allowed {
+			return requirementResult{}, fmt.Errorf("Error evaluating policy requirement %s: %w", fields.Type, err)
+		}
+		res.Error = err.Error()
+	}
+	res.Allowed = allowed
+
+	switch fields.Type {
+	case "signedBy":
+		keys, err := fields.gpgKeys()
+		if err != nil {
+			logrus.Debugf("Not reporting key fingerprints: %v", err)
+		}
+		for i, sig := range signatures {
+			if sig.Format != signatureFormatSimpleSigning {
+				continue
+			}
+			verdict := signatureVerdict{Index: i}
+			accepted, err := isAllowedBySingleRequirement(ctx, req, singleSignatureImage{UnparsedImage: unparsed, signature: sig.raw})
+			verdict.Accepted = accepted
+			if err != nil {
+				verdict.Error = err.Error()
+			}
+			verdict.KeyFingerprint = verifyingKeyFingerprint(keys, sig.raw)
+			res.Signatures = append(res.Signatures, verdict)
+		}
+	case "sigstoreSigned":
+		// c/image does not allow evaluating individual sigstore signatures, so we do that ourselves; see verify_sigstore.go.
+		verifier, verifierErr := newSigstoreVerifier(reqJSON)
+		for i, sig := range signatures {
+			if sig.Format != signatureFormatSigstore {
+				continue
+			}
+			var verdict signatureVerdict
+			if verifierErr != nil {
+				verdict.Error = verifierErr.Error()
+			} else {
+				verdict = verifier.evaluate(ctx, unparsed, sig)
+			}
+			verdict.Index = i
+			res.Signatures = append(res.Signatures, verdict)
+		}
+	}
+	return res, nil
+}
+
+// isAllowedBySingleRequirement evaluates only req for unparsed.
+func isAllowedBySingleRequirement(ctx context.Context, req signature.PolicyRequirement, unparsed types.UnparsedImage) (_ bool, retErr error) {
+	pc, err := signature.NewPolicyContext(&signature.Policy{Default: signature.PolicyRequirements{req}})
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if err := pc.Destroy(); err != nil {
+			retErr = noteCloseFailure(retErr, "tearing down policy context", err)
+		}
+	}()
+	return pc.IsRunningImageAllowed(ctx, unparsed)
+}
+
+// gpgKeys returns the GPG keyrings trusted by a signedBy requirement.
+func (f *requirementFields) gpgKeys() ([][]byte, error) {
+	if f.KeyType != "GPGKeys" {
+		return nil, fmt.Errorf("unsupported key type %q", f.KeyType)
+	}
+	if f.KeyData != nil {
+		return [][]byte{f.KeyData}, nil
+	}
+	paths := f.KeyPaths
+	if f.KeyPath != "" {
+		paths = []string{f.KeyPath}
+	}
+	var res [][]byte
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, data)
+	}
+	return res, nil
+}
+
+// verifyingKeyFingerprint returns the fingerprint of the key in keys which cryptographically verifies sig, or "".
+// This does not evaluate the signature contents.
+func verifyingKeyFingerprint(keys [][]byte, sig []byte) string {
+	for _, key := range keys {
+		mech, _, err := signature.NewEphemeralGPGSigningMechanism(key)
+		if err != nil {
+			logrus.Debugf("Error loading trusted keys: %v", err)
+			continue
+		}
+		_, keyIdentity, err := mech.Verify(sig)
+		mech.Close()
+		if err == nil {
+			return keyIdentity
+		}
+	}
+	return ""
+}
+
+// singleSignatureImage is an UnparsedImage which only has a single simple signing signature,
+// used to evaluate signatures one at a time.
+type singleSignatureImage struct {
+	types.UnparsedImage
+	signature []byte
+}
+
+func (i singleSignatureImage) Signatures(ctx context.Context) ([][]byte, error) {
+	return [][]byte{i.signature}, nil
+}
+
+// imageSignatures returns information about signatures of unparsed, which has manifestDigest.
+// types.UnparsedImage.Signatures only returns simple signing signatures, so sigstore signatures
+// are only found for docker:// images, by reading their attachments.
+func imageSignatures(ctx context.Context, sys *types.SystemContext, unparsed types.UnparsedImage, manifestDigest digest.Digest) ([]signatureInfo, error) {
+	sigs, err := unparsed.Signatures(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading signatures: %w", err)
+	}
+	infos := []signatureInfo{}
+	for _, sig := range sigs {
+		infos = append(infos, simpleSigningInfo(sig))
+	}
+
+	if unparsed.Reference().Transport().Name() == docker.Transport.Name() {
+		attachments, err := sigstoreAttachments(ctx, sys, unparsed.Reference(), manifestDigest)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, attachments...)
+	}
+	return infos, nil
+}
+
+// simpleSigningInfo returns information about a simple signing signature sig.
+func simpleSigningInfo(sig []byte) signatureInfo {
+	info := signatureInfo{
+		Format: signatureFormatSimpleSigning,
+		raw:    sig,
+	}
+	untrusted, err := signature.GetUntrustedSignatureInformationWithoutVerifying(sig)
+	if err != nil {
+		info.Error = err.Error()
+	} else {
+		info.ClaimedIdentity = untrusted.UntrustedDockerReference
+		info.ClaimedManifestDigest = untrusted.UntrustedDockerManifestDigest.String()
+		info.KeyIdentifier = untrusted.UntrustedShortKeyIdentifier
+		if untrusted.UntrustedCreatorID != nil {
+			info.Creator = *untrusted.UntrustedCreatorID
+		}
+		info.Timestamp = untrusted.UntrustedTimestamp
+	}
+	return info
+}
+
+// sigstoreInfo returns information about a sigstore signature with payload and attachment annotations.
+func sigstoreInfo(payload []byte, annotations map[string]string) signatureInfo {
+	info := signatureInfo{
+		Format:      signatureFormatSigstore,
+		payload:     payload,
+		annotations: annotations,
+	}
+	var parsed sigstorePayload
+	if err := json.Unmarshal(payload, &parsed); err != nil {
+		info.Error = fmt.Sprintf("parsing sigstore payload: %v", err)
+	} else {
+		info.ClaimedIdentity = parsed.Critical.Identity.DockerReference
+		info.ClaimedManifestDigest = parsed.Critical.Image.DockerManifestDigest
+	}
+	return info
+}
+
+// Constants used by sigstore signature attachments.
+const (
+	sigstoreSignatureAnnotationKey = "dev.cosignproject.cosign/signature"
+	sigstoreAttachmentTagSuffix    = ".sig"
+	maxSigstorePayloadSize         = 4 * 1024 * 1024
+)
+
+// sigstorePayload contains the fields of a sigstore signature payload we report.
+type sigstorePayload struct {
+	Critical struct {
+		Type     string `json:"type"`
+		Identity struct {
+			DockerReference string `json:"docker-reference"`
+		} `json:"identity"`
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+	} `json:"critical"`
+}
+
+// sigstoreAttachments returns information about sigstore signatures attached to manifestDigest in the repository of ref.
+func sigstoreAttachments(ctx context.Context, sys *types.SystemContext, ref types.ImageReference, manifestDigest digest.Digest) (_ []signatureInfo, retErr error) {
+	tagged, err := reference.WithTag(reference.TrimNamed(ref.DockerReference()),
+		manifestDigest.Algorithm().String()+"-"+manifestDigest.Encoded()+sigstoreAttachmentTagSuffix)
+	if err != nil {
+		return nil, err
+	}
+	attachmentsRef, err := docker.NewReference(tagged)
+	if err != nil {
+		return nil, err
+	}
+	src, err := attachmentsRef.NewImageSource(ctx, sys)
+	if err != nil {
+		if isNotFoundImageError(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("Error reading sigstore signatures: %w", err)
+	}
+	defer func() {
+		if err := src.Close(); err != nil {
+			retErr = noteCloseFailure(retErr, "closing sigstore signatures", err)
+		}
+	}()
+	manifestBytes, _, err := src.GetManifest(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading sigstore signatures: %w", err)
+	}
+	var m imgspecv1.Manifest
+	if err := json.Unmarshal(manifestBytes, &m); err != nil {
+		return nil, fmt.Errorf("Error parsing sigstore signatures: %w", err)
+	}
+
+	var res []signatureInfo
+	for _, layer := range m.Layers {
+		if _, ok := layer.Annotations[sigstoreSignatureAnnotationKey]; !ok {
+			continue
+		}
+		payload, err := readSigstorePayload(ctx, src, layer)
+		if err != nil {
+			res = append(res, signatureInfo{Format: signatureFormatSigstore, Error: err.Error()})
+			continue
+		}
+		res = append(res, sigstoreInfo(payload, layer.Annotations))
+	}
+	return res, nil
+}
+
+// readSigstorePayload reads the payload of a sigstore signature attachment layer.
+func readSigstorePayload(ctx context.Context, src types.ImageSource, layer imgspecv1.Descriptor) (_ []byte, retErr error) {
+	r, _, err := src.GetBlob(ctx, types.BlobInfo{Digest: layer.Digest, Size: layer.Size}, none.NoCache)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := r.Close(); err != nil {
+			retErr = noteCloseFailure(retErr, "closing sigstore payload", err)
+		}
+	}()
+	return io.ReadAll(io.LimitReader(r, maxSigstorePayloadSize))
+}
+
+// writeText writes a human-readable version of r to w.
+func (r *verifyResult) writeText(w io.Writer) {
+	verdict := "ACCEPTED"
+	if !r.Allowed {
+		verdict = "REJECTED"
+	}
+	fmt.Fprintf(w, "Image:           %s\n", r.Image)
+	fmt.Fprintf(w, "Manifest digest: %s\n", r.ManifestDigest)
+	if r.Scope.Default {
+		fmt.Fprintf(w, "Policy scope:    default\n")
+	} else {
+		fmt.Fprintf(w, "Policy scope:    transport %q, scope %q\n", r.Scope.Transport, r.Scope.Scope)
+	}
+	fmt.Fprintf(w, "Result:          %s\n", verdict)
+	if r.Error != "" {
+		fmt.Fprintf(w, "Reason:          %s\n", r.Error)
+	}
+
+	fmt.Fprintf(w, "\nSignatures:\n")
+	if len(r.Signatures) == 0 {
+		fmt.Fprintf(w, "  (none)\n")
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for i, sig := range r.Signatures {
+		if sig.Error != "" {
+			fmt.Fprintf(tw, "  [%d]\t%s\tinvalid: %s\n", i, sig.Format, sig.Error)
+			continue
+		}
+		fmt.Fprintf(tw, "  [%d]\t%s\tidentity %s\tdigest %s", i, sig.Format, sig.ClaimedIdentity, sig.ClaimedManifestDigest)
+		if sig.KeyIdentifier != "" {
+			fmt.Fprintf(tw, "\tkey %s", sig.KeyIdentifier)
+		}
+		fmt.Fprintf(tw, "\n")
+	}
+	tw.Flush()
+
+	fmt.Fprintf(w, "\nRequirements:\n")
+	for i, req := range r.Requirements {
+		status := "allowed"
+		if !req.Allowed {
+			status = "denied"
+		}
+		fmt.Fprintf(w, "  [%d] %s: %s\n", i, req.Type, status)
+		if req.Error != "" {
+			fmt.Fprintf(w, "      %s\n", req.Error)
+		}
+		for _, sig := range req.Signatures {
+			switch {
+			case sig.Accepted && sig.Identity != "":
+				fmt.Fprintf(w, "      signature [%d]: accepted, signed by %s\n", sig.Index, sig.Identity)
+			case sig.Accepted && sig.KeyFingerprint != "":
+				fmt.Fprintf(w, "      signature [%d]: accepted, verified by key %s\n", sig.Index, sig.KeyFingerprint)
+			case sig.Accepted:
+				fmt.Fprintf(w, "      signature [%d]: accepted\n", sig.Index)
+			case sig.Identity != "":
+				fmt.Fprintf(w, "      signature [%d]: signed by %s, but rejected: %s\n", sig.Index, sig.Identity, sig.Error)
+			case sig.KeyFingerprint != "":
+				fmt.Fprintf(w, "      signature [%d]: verified by key %s, but rejected: %s\n", sig.Index, sig.KeyFingerprint, sig.Error)
+			default:
+				fmt.Fprintf(w, "      signature [%d]: rejected: %s\n", sig.Index, sig.Error)
+			}
+		}
+	}
+}
@@ -0,0 +1,513 @@
+package main
+
+// Per-signature evaluation of sigstoreSigned requirements.
+//
+// c/image evaluates all sigstore signatures of an image at once, and its public API does not allow evaluating
+// individual sigstore signatures; so, to report which signatures are accepted, and by which key or signer identity,
+// this file performs the checks of a sigstoreSigned requirement on a single signature read from an attachment.
+// The overall result of the requirement is always computed by c/image.
+//
+// Rekor SETs are not verified here: if the requirement uses Rekor, a signature without a SET is rejected,
+// but the contents of the SET are only verified by c/image.
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/sirupsen/logrus"
+	"go.podman.io/image/v5/docker/reference"
+	"go.podman.io/image/v5/manifest"
+	"go.podman.io/image/v5/transports"
+	"go.podman.io/image/v5/types"
+)
+
+// Annotations of sigstore signature attachments, in addition to sigstoreSignatureAnnotationKey.
+const (
+	sigstoreCertificateAnnotationKey = "dev.sigstore.cosign/certificate"
+	sigstoreChainAnnotationKey       = "dev.sigstore.cosign/chain"
+	sigstoreSETAnnotationKey         = "dev.sigstore.cosign/bundle"
+)
+
+// sigstoreSignatureType is the value of the critical.type field of sigstore signature payloads.
+const sigstoreSignatureType = "cosign container image signature"
+
+var (
+	// fulcioIssuerV1OID and fulcioIssuerV2OID are the certificate extensions which contain the Fulcio OIDC issuer.
+	fulcioIssuerV1OID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 1}
+	fulcioIssuerV2OID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 8}
+	// subjectAltNameOID is the Subject Alternative Name extension, which Fulcio may mark critical without a Go-supported name.
+	subjectAltNameOID = asn1.ObjectIdentifier{2, 5, 29, 17}
+)
+
+// sigstoreRequirementFields contains the fields of a marshaled sigstoreSigned requirement.
+type sigstoreRequirementFields struct {
+	KeyPath  string   `json:"keyPath"`
+	KeyPaths []string `json:"keyPaths"`
+	KeyData  []byte   `json:"keyData"`
+	KeyDatas [][]byte `json:"keyDatas"`
+	Fulcio   *struct {
+		CAPath       string `json:"caPath"`
+		CAData       []byte `json:"caData"`
+		OIDCIssuer   string `json:"oidcIssuer"`
+		SubjectEmail string `json:"subjectEmail"`
+	} `json:"fulcio"`
+	PKI *struct {
+		CARootsPath         string `json:"caRootsPath"`
+		CARootsData         []byte `json:"caRootsData"`
+		CAIntermediatesPath string `json:"caIntermediatesPath"`
+		CAIntermediatesData []byte `json:"caIntermediatesData"`
+		SubjectEmail        string `json:"subjectEmail"`
+		SubjectHostname     string `json:"subjectHostname"`
+	} `json:"pki"`
+	RekorPublicKeyPath  string               `json:"rekorPublicKeyPath"`
+	RekorPublicKeyPaths []string             `json:"rekorPublicKeyPaths"`
+	RekorPublicKeyData  []byte               `json:"rekorPublicKeyData"`
+	RekorPublicKeyDatas [][]byte             `json:"rekorPublicKeyDatas"`
+	SignedIdentity      referenceMatchFields `json:"signedIdentity"`
+}
+
+// referenceMatchFields contains the fields of a marshaled signature.PolicyReferenceMatch.
+type referenceMatchFields struct {
+	Type             string `json:"type"`
+	DockerReference  string `json:"dockerReference"`
+	DockerRepository string `json:"dockerRepository"`
+	Prefix           string `json:"prefix"`
+	SignedPrefix     string `json:"signedPrefix"`
+}
+
+// sigstoreVerifier evaluates individual sigstore signatures against a sigstoreSigned requirement.
+type sigstoreVerifier struct {
+	fields           sigstoreRequirementFields
+	keys             []crypto.PublicKey // Trusted public keys, if the requirement uses keys
+	caCertificates   *x509.CertPool     // Fulcio CA, or PKI roots
+	caIntermediates  *x509.CertPool     // PKI intermediates, if any
+	requiresRekorSET bool
+}
+
+// newSigstoreVerifier returns a sigstoreVerifier for reqJSON, a marshaled sigstoreSigned requirement.
+func newSigstoreVerifier(reqJSON []byte) (*sigstoreVerifier, error) {
+	v := sigstoreVerifier{}
+	if err := json.Unmarshal(reqJSON, &v.fields); err != nil {
+		return nil, err
+	}
+	f := &v.fields
+	switch {
+	case f.Fulcio != nil:
+		pool, err := loadCertPool(f.Fulcio.CAPath, f.Fulcio.CAData)
+		if err != nil {
+			return nil, fmt.Errorf("loading Fulcio CA: %w", err)
+		}
+		v.caCertificates = pool
+		v.requiresRekorSET = true // Fulcio certificates are only trusted at the time recorded by Rekor
+	case f.PKI != nil:
+		pool, err := loadCertPool(f.PKI.CARootsPath, f.PKI.CARootsData)
+		if err != nil {
+			return nil, fmt.Errorf("loading PKI root certificates: %w", err)
+		}
+		v.caCertificates = pool
+		if f.PKI.CAIntermediatesPath != "" || f.PKI.CAIntermediatesData != nil {
+			pool, err := loadCertPool(f.PKI.CAIntermediatesPath, f.PKI.CAIntermediatesData)
+			if err != nil {
+				return nil, fmt.Errorf("loading PKI intermediate certificates: %w", err)
+			}
+			v.caIntermediates = pool
+		}
+	default:
+		keyDatas, err := loadByteSources(f.KeyPath, f.KeyPaths, f.KeyData, f.KeyDatas)
+		if err != nil {
+			return nil, fmt.Errorf("loading public keys: %w", err)
+		}
+		for _, data := range keyDatas {
+			key, err := parsePublicKeyPEM(data)
+			if err != nil {
+				return nil, fmt.Errorf("loading public keys: %w", err)
+			}
+			v.keys = append(v.keys, key)
+		}
+	}
+	if f.RekorPublicKeyPath != "" || f.RekorPublicKeyPaths != nil || f.RekorPublicKeyData != nil || f.RekorPublicKeyDatas != nil {
+		v.requiresRekorSET = true
+	}
+	return &v, nil
+}
+
+// loadByteSources returns the contents of path, paths, data and datas, whichever are set.
+func loadByteSources(path string, paths []string, data []byte, datas [][]byte) ([][]byte, error) {
+	if path != "" {
+		paths = append([]string{path}, paths...)
+	}
+	res := slices.Clone(datas)
+	if data != nil {
+		res = append(res, data)
+	}
+	for _, path := range paths {
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, contents)
+	}
+	return res, nil
+}
+
+// loadCertPool returns a pool with the PEM certificates in the file at path, or in data.
+func loadCertPool(path string, data []byte) (*x509.CertPool, error) {
+	if path != "" {
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		data = contents
+	}
+	certs, err := parseCertificatesPEM(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no certificates found")
+	}
+	pool := x509.NewCertPool()
+	for _, cert := range certs {
+		pool.AddCert(cert)
+	}
+	return pool, nil
+}
+
+// parseCertificatesPEM returns the certificates in PEM data.
+func parseCertificatesPEM(data []byte) ([]*x509.Certificate, error) {
+	var res []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return res, nil
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, cert)
+	}
+}
+
+// parsePublicKeyPEM returns the public key in PEM data.
+func parsePublicKeyPEM(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+// publicKeyFingerprint returns a fingerprint of key: the SHA-256 digest of its PKIX encoding.
+func publicKeyFingerprint(key crypto.PublicKey) string {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return ""
+	}
+	return digest.FromBytes(der).String()
+}
+
+// verifySigstoreSignature returns nil if sig is a valid signature of payload by key.
+func verifySigstoreSignature(key crypto.PublicKey, payload, sig []byte) error {
+	hash := sha256.Sum256(payload)
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(key, hash[:], sig) {
+			return errors.New("invalid ECDSA signature")
+		}
+		return nil
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], sig)
+	case ed25519.PublicKey:
+		if !ed25519.Verify(key, payload, sig) {
+			return errors.New("invalid Ed25519 signature")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported public key type %T", key)
+	}
+}
+
+// evaluate evaluates sig, a sigstore signature of unparsed.
+// The returned verdict does not have Index set.
+func (v *sigstoreVerifier) evaluate(ctx context.Context, unparsed types.UnparsedImage, sig signatureInfo) signatureVerdict {
+	var res signatureVerdict
+	err := v.verifySignature(&res, sig)
+	if err == nil {
+		err = v.verifyPayload(ctx, unparsed, sig.payload)
+	}
+	if err != nil {
+		res.Error = err.Error()
+	} else {
+		res.Accepted = true
+	}
+	return res
+}
+
+// verifySignature verifies the cryptographic signature of sig, and sets res.KeyFingerprint or res.Identity.
+func (v *sigstoreVerifier) verifySignature(res *signatureVerdict, sig signatureInfo) error {
+	if sig.Error != "" {
+		return errors.New(sig.Error)
+	}
+	rawSig, err := base64.StdEncoding.DecodeString(sig.annotations[sigstoreSignatureAnnotationKey])
+	if err != nil {
+		return fmt.Errorf("decoding signature: %w", err)
+	}
+	if v.requiresRekorSET && sig.annotations[sigstoreSETAnnotationKey] == "" {
+		return errors.New("signature does not have a Rekor SET, which the requirement requires")
+	}
+
+	switch {
+	case v.fields.Fulcio != nil, v.fields.PKI != nil:
+		cert, identity, err := v.verifyCertificate(sig.annotations)
+		if err != nil {
+			return err
+		}
+		if err := verifySigstoreSignature(cert.PublicKey, sig.payload, rawSig); err != nil {
+			return fmt.Errorf("signature not valid for certificate of %s: %w", identity, err)
+		}
+		res.Identity = identity
+		return nil
+	default:
+		for _, key := range v.keys {
+			if verifySigstoreSignature(key, sig.payload, rawSig) == nil {
+				res.KeyFingerprint = publicKeyFingerprint(key)
+				return nil
+			}
+		}
+		return errors.New("signature not valid with any trusted public key")
+	}
+}
+
+// verifyCertificate verifies the certificate in annotations of a signature, and returns it with the verified signer identity.
+func (v *sigstoreVerifier) verifyCertificate(annotations map[string]string) (*x509.Certificate, string, error) {
+	certs, err := parseCertificatesPEM([]byte(annotations[sigstoreCertificateAnnotationKey]))
+	if err != nil {
+		return nil, "", fmt.Errorf("parsing certificate: %w", err)
+	}
+	if len(certs) != 1 {
+		return nil, "", fmt.Errorf("expected one certificate, found %d", len(certs))
+	}
+	cert := certs[0]
+	intermediates := x509.NewCertPool()
+	if v.caIntermediates != nil {
+		intermediates = v.caIntermediates.Clone()
+	}
+	chain, err := parseCertificatesPEM([]byte(annotations[sigstoreChainAnnotationKey]))
+	if err != nil {
+		return nil, "", fmt.Errorf("parsing certificate chain: %w", err)
+	}
+	if len(chain) > 1 { // The last certificate of the chain is the root, which must be trusted on its own
+		for _, c := range chain[:len(chain)-1] {
+			intermediates.AddCert(c)
+		}
+	}
+	opts := x509.VerifyOptions{
+		Intermediates: intermediates,
+		Roots:         v.caCertificates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}
+
+	if f := v.fields.Fulcio; f != nil {
+		// Fulcio marks the Subject Alternative Name critical, possibly with names Go does not handle; we check the email ourselves.
+		cert.UnhandledCriticalExtensions = slices.DeleteFunc(cert.UnhandledCriticalExtensions, func(oid asn1.ObjectIdentifier) bool {
+			return oid.Equal(subjectAltNameOID)
+		})
+		// Fulcio certificates are short-lived; like c/image, verify them at the time recorded by Rekor.
+		// The SET itself is only verified by c/image.
+		opts.CurrentTime, err = rekorIntegratedTime(annotations[sigstoreSETAnnotationKey])
+		if err != nil {
+			return nil, "", err
+		}
+		if _, err := cert.Verify(opts); err != nil {
+			return nil, "", fmt.Errorf("verifying certificate: %w", err)
+		}
+		issuer, err := fulcioIssuer(cert)
+		if err != nil {
+			return nil, "", err
+		}
+		if issuer != f.OIDCIssuer {
+			return nil, "", fmt.Errorf("unexpected Fulcio OIDC issuer %q", issuer)
+		}
+		if !slices.Contains(cert.EmailAddresses, f.SubjectEmail) {
+			return nil, "", fmt.Errorf("required email %q not found (got %q)", f.SubjectEmail, cert.EmailAddresses)
+		}
+		return cert, fmt.Sprintf("%s (OIDC issuer %s)", f.SubjectEmail, issuer), nil
+	}
+
+	p := v.fields.PKI
+	if _, err := cert.Verify(opts); err != nil {
+		return nil, "", fmt.Errorf("verifying certificate: %w", err)
+	}
+	var identities []string
+	if p.SubjectEmail != "" {
+		if !slices.Contains(cert.EmailAddresses, p.SubjectEmail) {
+			return nil, "", fmt.Errorf("required email %q not found (got %q)", p.SubjectEmail, cert.EmailAddresses)
+		}
+		identities = append(identities, p.SubjectEmail)
+	}
+	if p.SubjectHostname != "" {
+		if err := cert.VerifyHostname(p.SubjectHostname); err != nil {
+			return nil, "", fmt.Errorf("unexpected subject hostname: %w", err)
+		}
+		identities = append(identities, p.SubjectHostname)
+	}
+	return cert, strings.Join(identities, ", "), nil
+}
+
+// rekorIntegratedTime returns the (unverified) time at which the Rekor SET set was recorded.
+func rekorIntegratedTime(set string) (time.Time, error) {
+	var parsed struct {
+		Payload struct {
+			IntegratedTime int64 `json:"integratedTime"`
+		} `json:"Payload"`
+	}
+	if err := json.Unmarshal([]byte(set), &parsed); err != nil {
+		return time.Time{}, fmt.Errorf("parsing Rekor SET: %w", err)
+	}
+	return time.Unix(parsed.Payload.IntegratedTime, 0), nil
+}
+
+// fulcioIssuer returns the OIDC issuer recorded in a Fulcio certificate.
+func fulcioIssuer(cert *x509.Certificate) (string, error) {
+	var v1, v2 string
+	for _, ext := range cert.Extensions {
+		switch {
+		case ext.Id.Equal(fulcioIssuerV1OID):
+			v1 = string(ext.Value)
+		case ext.Id.Equal(fulcioIssuerV2OID):
+			rest, err := asn1.Unmarshal(ext.Value, &v2)
+			if err != nil || len(rest) != 0 {
+				return "", errors.New("invalid ASN.1 in OIDC issuer v2 extension")
+			}
+		}
+	}
+	switch {
+	case v1 != "" && v2 != "" && v1 != v2:
+		return "", fmt.Errorf("inconsistent OIDC issuer extension values: v1 %q, v2 %q", v1, v2)
+	case v2 != "":
+		return v2, nil
+	case v1 != "":
+		return v1, nil
+	default:
+		return "", errors.New("Fulcio certificate is missing the issuer extension")
+	}
+}
+
+// verifyPayload verifies that the payload of a sigstore signature matches unparsed and the signedIdentity of the requirement.
+func (v *sigstoreVerifier) verifyPayload(ctx context.Context, unparsed types.UnparsedImage, payload []byte) error {
+	var parsed sigstorePayload
+	if err := json.Unmarshal(payload, &parsed); err != nil {
+		return fmt.Errorf("parsing sigstore payload: %w", err)
+	}
+	if parsed.Critical.Type != sigstoreSignatureType {
+		return fmt.Errorf("unexpected signature type %q", parsed.Critical.Type)
+	}
+	signedDigest, err := digest.Parse(parsed.Critical.Image.DockerManifestDigest)
+	if err != nil {
+		return fmt.Errorf("invalid manifest digest in signature: %w", err)
+	}
+	manifestBytes, _, err := unparsed.Manifest(ctx)
+	if err != nil {
+		return err
+	}
+	matches, err := manifest.MatchesDigest(manifestBytes, signedDigest)
+	if err != nil {
+		return err
+	}
+	if !matches {
+		return fmt.Errorf("Signature for digest %s does not match the image", signedDigest)
+	}
+	signedIdentity := parsed.Critical.Identity.DockerReference
+	if !v.fields.SignedIdentity.matches(unparsed.Reference(), signedIdentity) {
+		return fmt.Errorf("Signature for identity %q is not accepted", signedIdentity)
+	}
+	return nil
+}
+
+// matches returns true if signedIdentity, the identity claimed by a signature of ref, is accepted by m.
+// This follows the semantics of signedIdentity in containers-policy.json(5).
+func (m *referenceMatchFields) matches(ref types.ImageReference, signedIdentity string) bool {
+	signed, err := reference.ParseNormalizedNamed(signedIdentity)
+	if err != nil {
+		return false
+	}
+	switch m.Type {
+	case "exactReference":
+		intended, err := reference.ParseNormalizedNamed(m.DockerReference)
+		if err != nil || reference.IsNameOnly(intended) || reference.IsNameOnly(signed) {
+			return false
+		}
+		return signed.String() == intended.String()
+	case "exactRepository":
+		intended, err := reference.ParseNormalizedNamed(m.DockerRepository)
+		if err != nil {
+			return false
+		}
+		return signed.Name() == intended.Name()
+	}
+
+	intended := ref.DockerReference()
+	if intended == nil {
+		logrus.Debugf("Docker reference match attempted on image %s with no known Docker reference identity", transports.ImageName(ref))
+		return false
+	}
+	switch m.Type {
+	case "matchExact":
+		if reference.IsNameOnly(intended) || reference.IsNameOnly(signed) {
+			return false
+		}
+		return signed.String() == intended.String()
+	case "matchRepoDigestOrExact":
+		return matchRepoDigestOrExact(intended, signed)
+	case "matchRepository":
+		return signed.Name() == intended.Name()
+	case "remapIdentity":
+		name := intended.Name()
+		if name == m.Prefix || (strings.HasPrefix(name, m.Prefix) && name[len(m.Prefix)] == '/') {
+			remapped, err := reference.ParseNamed(strings.Replace(intended.String(), m.Prefix, m.SignedPrefix, 1))
+			if err != nil {
+				return false
+			}
+			intended = remapped
+		}
+		return matchRepoDigestOrExact(intended, signed)
+	default:
+		return false
+	}
+}
+
+// matchRepoDigestOrExact implements the matchRepoDigestOrExact signedIdentity for intended and signed.
+func matchRepoDigestOrExact(intended, signed reference.Named) bool {
+	if reference.IsNameOnly(signed) {
+		return false
+	}
+	switch intended.(type) {
+	case reference.NamedTagged:
+		return signed.String() == intended.String()
+	case reference.Canonical: // The manifest digest is verified separately
+		return signed.Name() == intended.Name()
+	default:
+		return false
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.podman.io/image/v5/directory"
+	"go.podman.io/image/v5/docker"
+	"go.podman.io/image/v5/image"
+	"go.podman.io/image/v5/signature"
+	"go.podman.io/image/v5/types"
+)
+
+// newSigstoreTestKey returns a new sigstore signing key, and its PEM-encoded public key.
+func newSigstoreTestKey(t *testing.T) (*ecdsa.PrivateKey, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	require.NoError(t, err)
+	return key, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+// newSigstoreTestCertificate returns a certificate for key, signed by parent and parentKey, or self-signed if parent is nil,
+// as PEM and as a parsed certificate.
+func newSigstoreTestCertificate(t *testing.T, template *x509.Certificate, key *ecdsa.PrivateKey,
+	parent *x509.Certificate, parentKey *ecdsa.PrivateKey,
+) ([]byte, *x509.Certificate) {
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), cert
+}
+
+// newSigstoreTestSignature returns a sigstore signature by key claiming identity and manifestDigest,
+// with the attachment annotations in extraAnnotations.
+func newSigstoreTestSignature(t *testing.T, key crypto.Signer, identity string, manifestDigest digest.Digest,
+	extraAnnotations map[string]string,
+) signatureInfo {
+	payload, err := json.Marshal(map[string]any{
+		"critical": map[string]any{
+			"identity": map[string]any{"docker-reference": identity},
+			"image":    map[string]any{"docker-manifest-digest": manifestDigest.String()},
+			"type":     sigstoreSignatureType,
+		},
+		"optional": map[string]any{},
+	})
+	require.NoError(t, err)
+	hash := sha256.Sum256(payload)
+	sig, err := key.Sign(rand.Reader, hash[:], crypto.SHA256)
+	require.NoError(t, err)
+	annotations := map[string]string{sigstoreSignatureAnnotationKey: base64.StdEncoding.EncodeToString(sig)}
+	for k, v := range extraAnnotations {
+		annotations[k] = v
+	}
+	return sigstoreInfo(payload, annotations)
+}
+
+// newSigstoreTestVerifier returns a sigstoreVerifier for the requirement created by options.
+func newSigstoreTestVerifier(t *testing.T, options ...signature.PRSigstoreSignedOption) *sigstoreVerifier {
+	req, err := signature.NewPRSigstoreSigned(options...)
+	require.NoError(t, err)
+	reqJSON, err := json.Marshal(req)
+	require.NoError(t, err)
+	v, err := newSigstoreVerifier(reqJSON)
+	require.NoError(t, err)
+	return v
+}
+
+func TestSigstoreVerifier(t *testing.T) {
+	ctx := context.Background()
+	ref, err := directory.NewReference(prepareSignedDirImage(t))
+	require.NoError(t, err)
+	src, err := ref.NewImageSource(ctx, nil)
+	require.NoError(t, err)
+	defer src.Close()
+	unparsed := image.UnparsedInstance(src, nil)
+
+	exactRepository, err := signature.NewPRMExactRepository("example.com/test")
+	require.NoError(t, err)
+	signedIdentity := signature.PRSigstoreSignedWithSignedIdentity(exactRepository)
+	key, publicKey := newSigstoreTestKey(t)
+	otherKey, _ := newSigstoreTestKey(t)
+	_, rekorPublicKey := newSigstoreTestKey(t)
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	require.NoError(t, err)
+	keyFingerprint := digest.FromBytes(der).String()
+
+	// Public keys
+	v := newSigstoreTestVerifier(t, signature.PRSigstoreSignedWithKeyData(publicKey), signedIdentity)
+	verdict := v.evaluate(ctx, unparsed, newSigstoreTestSignature(t, key, "example.com/test:latest", fixturesTestImageManifestDigest, nil))
+	assert.Equal(t, signatureVerdict{Accepted: true, KeyFingerprint: keyFingerprint}, verdict)
+	verdict = v.evaluate(ctx, unparsed, newSigstoreTestSignature(t, key, "example.com/other:latest", fixturesTestImageManifestDigest, nil))
+	assert.False(t, verdict.Accepted)
+	assert.Equal(t, keyFingerprint, verdict.KeyFingerprint)
+	assert.Contains(t, verdict.Error, `Signature for identity "example.com/other:latest" is not accepted`)
+	verdict = v.evaluate(ctx, unparsed, newSigstoreTestSignature(t, key, "example.com/test:latest", digest.FromString("other"), nil))
+	assert.False(t, verdict.Accepted)
+	assert.Contains(t, verdict.Error, "does not match the image")
+	verdict = v.evaluate(ctx, unparsed, newSigstoreTestSignature(t, otherKey, "example.com/test:latest", fixturesTestImageManifestDigest, nil))
+	assert.Equal(t, signatureVerdict{Error: "signature not valid with any trusted public key"}, verdict)
+	verdict = v.evaluate(ctx, unparsed, signatureInfo{Format: signatureFormatSigstore, Error: "invalid payload"})
+	assert.Equal(t, signatureVerdict{Error: "invalid payload"}, verdict)
+
+	// Rekor requires a SET
+	v = newSigstoreTestVerifier(t, signature.PRSigstoreSignedWithKeyData(publicKey),
+		signature.PRSigstoreSignedWithRekorPublicKeyData(rekorPublicKey), signedIdentity)
+	verdict = v.evaluate(ctx, unparsed, newSigstoreTestSignature(t, key, "example.com/test:latest", fixturesTestImageManifestDigest, nil))
+	assert.False(t, verdict.Accepted)
+	assert.Contains(t, verdict.Error, "does not have a Rekor SET")
+
+	// Certificates
+	caKey, _ := newSigstoreTestKey(t)
+	caPEM, caCert := newSigstoreTestCertificate(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, caKey, nil, nil)
+	leafPEM, _ := newSigstoreTestCertificate(t, &x509.Certificate{
+		EmailAddresses: []string{"user@example.com"},
+		KeyUsage:       x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+		ExtraExtensions: []pkix.Extension{
+			{Id: fulcioIssuerV1OID, Value: []byte("https://issuer.example.com")},
+		},
+	}, key, caCert, caKey)
+	certAnnotations := map[string]string{
+		sigstoreCertificateAnnotationKey: string(leafPEM),
+		sigstoreChainAnnotationKey:       string(caPEM),
+		sigstoreSETAnnotationKey:         fmt.Sprintf(`{"SignedEntryTimestamp":"","Payload":{"integratedTime":%d}}`, time.Now().Unix()),
+	}
+	certSignature := newSigstoreTestSignature(t, key, "example.com/test:latest", fixturesTestImageManifestDigest, certAnnotations)
+
+	for _, c := range []struct {
+		email, issuer    string
+		expectedIdentity string
+	}{
+		{"user@example.com", "https://issuer.example.com", "user@example.com (OIDC issuer https://issuer.example.com)"},
+		{"other@example.com", "https://issuer.example.com", ""},
+		{"user@example.com", "https://other.example.com", ""},
+	} {
+		fulcio, err := signature.NewPRSigstoreSignedFulcio(signature.PRSigstoreSignedFulcioWithCAData(caPEM),
+			signature.PRSigstoreSignedFulcioWithOIDCIssuer(c.issuer), signature.PRSigstoreSignedFulcioWithSubjectEmail(c.email))
+		require.NoError(t, err)
+		v = newSigstoreTestVerifier(t, signature.PRSigstoreSignedWithFulcio(fulcio),
+			signature.PRSigstoreSignedWithRekorPublicKeyData(rekorPublicKey), signedIdentity)
+		verdict = v.evaluate(ctx, unparsed, certSignature)
+		assert.Equal(t, c.expectedIdentity != "", verdict.Accepted, c)
+		assert.Equal(t, c.expectedIdentity, verdict.Identity, c)
+	}
+
+	for _, c := range []struct {
+		email, hostname  string
+		expectedIdentity string
+	}{
+		{"user@example.com", "", "user@example.com"},
+		{"other@example.com", "", ""},
+		{"", "example.com", ""},
+	} {
+		var options []signature.PRSigstoreSignedPKIOption
+		if c.email != "" {
+			options = append(options, signature.PRSigstoreSignedPKIWithSubjectEmail(c.email))
+		}
+		if c.hostname != "" {
+			options = append(options, signature.PRSigstoreSignedPKIWithSubjectHostname(c.hostname))
+		}
+		pki, err := signature.NewPRSigstoreSignedPKI(append(options, signature.PRSigstoreSignedPKIWithCARootsData(caPEM))...)
+		require.NoError(t, err)
+		v = newSigstoreTestVerifier(t, signature.PRSigstoreSignedWithPKI(pki), signedIdentity)
+		verdict = v.evaluate(ctx, unparsed, certSignature)
+		assert.Equal(t, c.expectedIdentity != "", verdict.Accepted, c)
+		assert.Equal(t, c.expectedIdentity, verdict.Identity, c)
+	}
+
+	// A certificate from an untrusted CA
+	otherCAPEM, _ := newSigstoreTestCertificate(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Other CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, otherKey, nil, nil)
+	pki, err := signature.NewPRSigstoreSignedPKI(signature.PRSigstoreSignedPKIWithCARootsData(otherCAPEM),
+		signature.PRSigstoreSignedPKIWithSubjectEmail("user@example.com"))
+	require.NoError(t, err)
+	v = newSigstoreTestVerifier(t, signature.PRSigstoreSignedWithPKI(pki), signedIdentity)
+	verdict = v.evaluate(ctx, unparsed, certSignature)
+	assert.False(t, verdict.Accepted)
+	assert.Contains(t, verdict.Error, "verifying certificate")
+}
+
+func TestReferenceMatchFieldsMatches(t *testing.T) {
+	parseRef := func(s string) types.ImageReference {
+		ref, err := docker.ParseReference("//" + s)
+		require.NoError(t, err)
+		return ref
+	}
+	const digested = "example.com/ns/repo@sha256:0000000000000000000000000000000000000000000000000000000000000000"
+	for _, c := range []struct {
+		match    referenceMatchFields
+		ref      string
+		signed   string
+		expected bool
+	}{
+		{referenceMatchFields{Type: "matchExact"}, "example.com/ns/repo:tag", "example.com/ns/repo:tag", true},
+		{referenceMatchFields{Type: "matchExact"}, "example.com/ns/repo:tag", "example.com/ns/repo:other", false},
+		{referenceMatchFields{Type: "matchExact"}, "example.com/ns/repo:tag", "example.com/ns/repo", false},
+		{referenceMatchFields{Type: "matchRepoDigestOrExact"}, "example.com/ns/repo:tag", "example.com/ns/repo:tag", true},
+		{referenceMatchFields{Type: "matchRepoDigestOrExact"}, "example.com/ns/repo:tag", "example.com/ns/repo:other", false},
+		{referenceMatchFields{Type: "matchRepoDigestOrExact"}, digested, "example.com/ns/repo:tag", true},
+		{referenceMatchFields{Type: "matchRepoDigestOrExact"}, digested, "example.com/ns/other:tag", false},
+		{referenceMatchFields{Type: "matchRepository"}, "example.com/ns/repo:tag", "example.com/ns/repo:other", true},
+		{referenceMatchFields{Type: "matchRepository"}, "example.com/ns/repo:tag", "example.com/ns/other:tag", false},
+		{referenceMatchFields{Type: "exactReference", DockerReference: "example.com/x:1"}, "example.com/ns/repo:tag", "example.com/x:1", true},
+		{referenceMatchFields{Type: "exactReference", DockerReference: "example.com/x:1"}, "example.com/ns/repo:tag", "example.com/x:2", false},
+		{referenceMatchFields{Type: "exactRepository", DockerRepository: "example.com/x"}, "example.com/ns/repo:tag", "example.com/x:2", true},
+		{referenceMatchFields{Type: "exactRepository", DockerRepository: "example.com/x"}, "example.com/ns/repo:tag", "example.com/y:2", false},
+		{referenceMatchFields{Type: "remapIdentity", Prefix: "example.com/ns", SignedPrefix: "signed.example.com/ns"},
+			"example.com/ns/repo:tag", "signed.example.com/ns/repo:tag", true},
+		{referenceMatchFields{Type: "remapIdentity", Prefix: "example.com/ns", SignedPrefix: "signed.example.com/ns"},
+			"example.com/ns/repo:tag", "example.com/ns/repo:tag", false},
+		{referenceMatchFields{Type: "remapIdentity", Prefix: "example.com/n", SignedPrefix: "signed.example.com/n"},
+			"example.com/ns/repo:tag", "example.com/ns/repo:tag", true},
+		{referenceMatchFields{Type: "unknown"}, "example.com/ns/repo:tag", "example.com/ns/repo:tag", false},
+	} {
+		assert.Equal(t, c.expected, c.match.matches(parseRef(c.ref), c.signed), c)
+	}
+}
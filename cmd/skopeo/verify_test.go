@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// prepareSignedDirImage creates a dir: image with fixtures/image.manifest.json and fixtures/image.signature,
+// and returns its path.
+func prepareSignedDirImage(t *testing.T) string {
+	dir := t.TempDir()
+	for src, dest := range map[string]string{
+		"fixtures/image.manifest.json": "manifest.json",
+		"fixtures/image.signature":     "signature-1",
+	} {
+		data, err := os.ReadFile(src)
+		require.NoError(t, err)
+		err = os.WriteFile(filepath.Join(dir, dest), data, 0o644)
+		require.NoError(t, err)
+	}
+	err := os.WriteFile(filepath.Join(dir, "version"), []byte("Directory Transport Version: 1.1\n"), 0o644)
+	require.NoError(t, err)
+	return dir
+}
+
+// writeDirPolicy writes a policy requiring a signature by the fixtures key with signedIdentity for dir: images,
+// and returns its path.
+func writeDirPolicy(t *testing.T, signedIdentity string) string {
+	keyPath, err := filepath.Abs("fixtures/pubring.gpg")
+	require.NoError(t, err)
+	policyPath := filepath.Join(t.TempDir(), "policy.json")
+	err = os.WriteFile(policyPath, fmt.Appendf(nil, `{"default":[{"type":"reject"}],"transports":{"dir":{"":[`+
+		`{"type":"signedBy","keyType":"GPGKeys","keyPath":%q,"signedIdentity":%s}]}}}`, keyPath, signedIdentity), 0o644)
+	require.NoError(t, err)
+	return policyPath
+}
+
+func TestVerify(t *testing.T) {
+	imageDir := prepareSignedDirImage(t)
+
+	// Accepted
+	policyPath := writeDirPolicy(t, `{"type":"exactRepository","dockerRepository":"testing/manifest"}`)
+	out, err := runSkopeo("--policy", policyPath, "verify", "--format", "json", "dir:"+imageDir)
+	require.NoError(t, err)
+	var res verifyResult
+	err = json.Unmarshal([]byte(out), &res)
+	require.NoError(t, err)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, fixturesTestImageManifestDigest, res.ManifestDigest)
+	assert.Equal(t, policyScope{Transport: "dir", Scope: ""}, res.Scope)
+	require.Len(t, res.Signatures, 1)
+	assert.Equal(t, signatureFormatSimpleSigning, res.Signatures[0].Format)
+	assert.Equal(t, "testing/manifest", res.Signatures[0].ClaimedIdentity)
+	assert.Equal(t, fixturesTestImageManifestDigest.String(), res.Signatures[0].ClaimedManifestDigest)
+	assert.Equal(t, fixturesTestKeyShortID, res.Signatures[0].KeyIdentifier)
+	require.Len(t, res.Requirements, 1)
+	assert.Equal(t, "signedBy", res.Requirements[0].Type)
+	assert.True(t, res.Requirements[0].Allowed)
+	assert.Equal(t, []signatureVerdict{{Index: 0, Accepted: true, KeyFingerprint: fixturesTestKeyFingerprint}}, res.Requirements[0].Signatures)
+
+	// Text output
+	out, err = runSkopeo("--policy", policyPath, "verify", "dir:"+imageDir)
+	require.NoError(t, err)
+	assert.Contains(t, out, "ACCEPTED")
+	assert.Contains(t, out, "verified by key "+fixturesTestKeyFingerprint)
+
+	// Rejected: the signature is valid, but claims a different identity
+	policyPath = writeDirPolicy(t, `{"type":"exactRepository","dockerRepository":"testing/other"}`)
+	out, err = runSkopeo("--policy", policyPath, "verify", "--format", "json", "dir:"+imageDir)
+	var exitCodeErr errorWithExitCode
+	require.True(t, errors.As(err, &exitCodeErr))
+	assert.Equal(t, exitCodePolicyRejected, exitCodeErr.exitCode)
+	res = verifyResult{}
+	err = json.Unmarshal([]byte(out), &res)
+	require.NoError(t, err)
+	assert.False(t, res.Allowed)
+	assert.NotEmpty(t, res.Error)
+	require.Len(t, res.Requirements, 1)
+	assert.False(t, res.Requirements[0].Allowed)
+	require.Len(t, res.Requirements[0].Signatures, 1)
+	assert.False(t, res.Requirements[0].Signatures[0].Accepted)
+	assert.Equal(t, fixturesTestKeyFingerprint, res.Requirements[0].Signatures[0].KeyFingerprint)
+	assert.NotEmpty(t, res.Requirements[0].Signatures[0].Error)
+
+	// Invalid usage
+	_, err = runSkopeo("--insecure-policy", "verify")
+	assert.Error(t, err)
+	_, err = runSkopeo("--insecure-policy", "verify", "--format", "yaml", "dir:"+imageDir)
+	assert.Error(t, err)
+	// A missing image is an error, not a rejection
+	_, err = runSkopeo("--insecure-policy", "verify", "dir:/this/does/not/exist")
+	assert.Error(t, err)
+	assert.False(t, errors.As(err, &exitCodeErr))
+}
+
+func TestVerifySigstore(t *testing.T) {
+	const fixture = "../../integration/fixtures/uncompressed-image-s2"
+	dir := t.TempDir()
+	passphraseFile := filepath.Join(dir, "passphrase")
+	err := os.WriteFile(passphraseFile, []byte("pass"), 0o600)
+	require.NoError(t, err)
+	keyPrefix := filepath.Join(dir, "key")
+	_, err = runSkopeo("generate-sigstore-key", "--output-prefix", keyPrefix, "--passphrase-file", passphraseFile)
+	require.NoError(t, err)
+	// An image with two signatures by the same key, claiming different identities
+	imageDir := filepath.Join(dir, "image")
+	src := "dir:" + fixture
+	for i, identity := range []string{"example.com/test:latest", "example.com/other:latest"} {
+		dest := fmt.Sprintf("dir:%s%d", imageDir, i)
+		_, err = runSkopeo("--insecure-policy", "copy", "-q", "--sign-by-sigstore-private-key", keyPrefix+".private",
+			"--sign-passphrase-file", passphraseFile, "--sign-identity", identity, src, dest)
+		require.NoError(t, err)
+		src = dest
+	}
+	policyPath := filepath.Join(dir, "policy.json")
+	err = os.WriteFile(policyPath, fmt.Appendf(nil, `{"default":[{"type":"reject"}],"transports":{"dir":{"":[`+
+		`{"type":"sigstoreSigned","keyPath":%q,"signedIdentity":{"type":"exactRepository","dockerRepository":"example.com/test"}}]}}}`,
+		keyPrefix+".pub"), 0o644)
+	require.NoError(t, err)
+
+	// c/image evaluates the sigstore signatures of dir: images, but does not expose them; so they are not listed
+	// and not evaluated individually (see TestSigstoreVerifier for that).
+	out, err := runSkopeo("--policy", policyPath, "verify", "--format", "json", src)
+	require.NoError(t, err)
+	var res verifyResult
+	err = json.Unmarshal([]byte(out), &res)
+	require.NoError(t, err)
+	assert.True(t, res.Allowed)
+	assert.Empty(t, res.Signatures)
+	require.Len(t, res.Requirements, 1)
+	assert.Equal(t, "sigstoreSigned", res.Requirements[0].Type)
+	assert.True(t, res.Requirements[0].Allowed)
+	assert.Empty(t, res.Requirements[0].Signatures)
+
+	// Rejected: no signature claims example.com/none
+	policyPath = filepath.Join(dir, "policy-other.json")
+	err = os.WriteFile(policyPath, fmt.Appendf(nil, `{"default":[{"type":"reject"}],"transports":{"dir":{"":[`+
+		`{"type":"sigstoreSigned","keyPath":%q,"signedIdentity":{"type":"exactRepository","dockerRepository":"example.com/none"}}]}}}`,
+		keyPrefix+".pub"), 0o644)
+	require.NoError(t, err)
+	out, err = runSkopeo("--policy", policyPath, "verify", src)
+	var exitCodeErr errorWithExitCode
+	require.True(t, errors.As(err, &exitCodeErr))
+	assert.Equal(t, exitCodePolicyRejected, exitCodeErr.exitCode)
+	assert.Contains(t, out, "REJECTED")
+}